@@ -1710,6 +1710,80 @@ func (m *ModuleBase) GetArchProperties(dst interface{}) map[ArchType]interface{}
 	return archToProp
 }
 
+// GetArchFeatureProperties returns a map of architectures to a map of the
+// features of that architecture (e.g. "avx2" for x86) to the values of the
+// properties of the 'dst' struct that are specific to that feature, as set in
+// e.g. arch: { x86: { avx2: { ... } } }.
+//
+// Like GetArchProperties, the returned values can be type asserted back into
+// the same struct as 'dst'.
+func (m *ModuleBase) GetArchFeatureProperties(dst interface{}) map[ArchType]map[string]interface{} {
+	archToFeatureProps := map[ArchType]map[string]interface{}{}
+
+	// Nothing to do for non-arch-specific modules.
+	if !m.ArchSpecific() {
+		return archToFeatureProps
+	}
+
+	for i := range m.archProperties {
+		if m.archProperties[i] == nil {
+			// Skip over nil arch props
+			continue
+		}
+
+		for _, arch := range ArchTypeList() {
+			for _, archProperties := range m.archProperties[i] {
+				archPropValues := reflect.ValueOf(archProperties).Elem()
+
+				// Traverse into the Arch nested struct, as GetArchProperties does.
+				src := archPropValues.FieldByName("Arch").Elem()
+				if src.Kind() == reflect.Ptr {
+					if src.IsNil() {
+						continue
+					}
+					src = src.Elem()
+				}
+
+				src = src.FieldByName(arch.Field)
+				if !src.IsValid() || src.Kind() != reflect.Struct {
+					continue
+				}
+
+				// Each feature of the arch is a field next to the BlueprintEmbed field of the arch
+				// struct. See createArchPropTypeDesc.
+				matched := false
+				for _, feature := range archFeatures[arch] {
+					featureSrc := src.FieldByName(proptools.FieldNameForProperty(variantReplacer.Replace(feature)))
+					if !featureSrc.IsValid() {
+						continue
+					}
+
+					// Clone the destination prop, since we want a unique prop struct per feature.
+					dstClone := reflect.New(reflect.ValueOf(dst).Elem().Type()).Interface()
+
+					err := proptools.ExtendMatchingProperties([]interface{}{dstClone}, featureSrc.Interface(), nil, proptools.OrderReplace)
+					if err != nil {
+						// This is fine, it just means the src struct doesn't match.
+						continue
+					}
+
+					if archToFeatureProps[arch] == nil {
+						archToFeatureProps[arch] = map[string]interface{}{}
+					}
+					archToFeatureProps[arch][feature] = dstClone
+					matched = true
+				}
+
+				if matched {
+					// Go to the next arch.
+					break
+				}
+			}
+		}
+	}
+	return archToFeatureProps
+}
+
 // GetTargetProperties returns a map of OS target (e.g. android, windows) to the
 // values of the properties of the 'dst' struct that are specific to that OS
 // target.
@@ -1796,3 +1870,70 @@ func (m *ModuleBase) GetTargetProperties(dst interface{}) map[OsType]interface{}
 	}
 	return osToProp
 }
+
+// GetTargetArchProperties returns a map of OS target (e.g. android) to a map of the architectures
+// of that OS target to the values of the properties of the 'dst' struct that are specific to the
+// combination, as set in e.g. target: { android_arm64: { ... } }.
+//
+// Like GetTargetProperties, the returned values can be type asserted back into the same struct as
+// 'dst'.
+func (m *ModuleBase) GetTargetArchProperties(dst interface{}) map[OsType]map[ArchType]interface{} {
+	osToArchProps := map[OsType]map[ArchType]interface{}{}
+
+	// Nothing to do for non-OS/arch-specific modules.
+	if !m.ArchSpecific() {
+		return osToArchProps
+	}
+
+	for i := range m.archProperties {
+		if m.archProperties[i] == nil {
+			continue
+		}
+
+		for _, os := range OsTypeList {
+			for _, arch := range osArchTypeMap[os] {
+				// e.g. Android_arm64, Linux_glibc_x86_64
+				field := os.Field + "_" + arch.Name
+
+				for _, archProperties := range m.archProperties[i] {
+					archPropValues := reflect.ValueOf(archProperties).Elem()
+
+					// Traverse into the Target nested struct, as GetTargetProperties does.
+					src := archPropValues.FieldByName("Target").Elem()
+					if src.Kind() == reflect.Ptr {
+						if src.IsNil() {
+							continue
+						}
+						src = src.Elem()
+					}
+
+					src = src.FieldByName(field)
+					if !src.IsValid() || src.IsNil() {
+						continue
+					}
+					if src.Kind() != reflect.Ptr || src.Elem().Kind() != reflect.Struct {
+						continue
+					}
+
+					// Clone the destination prop, since we want a unique prop struct per os and arch.
+					dstClone := reflect.New(reflect.ValueOf(dst).Elem().Type()).Interface()
+
+					err := proptools.ExtendMatchingProperties([]interface{}{dstClone}, src.Interface(), nil, proptools.OrderReplace)
+					if err != nil {
+						// This is fine, it just means the src struct doesn't match.
+						continue
+					}
+
+					if osToArchProps[os] == nil {
+						osToArchProps[os] = map[ArchType]interface{}{}
+					}
+					osToArchProps[os][arch] = dstClone
+
+					// Go to the next arch.
+					break
+				}
+			}
+		}
+	}
+	return osToArchProps
+}
@@ -15,12 +15,16 @@
 package android
 
 import (
+	"android/soong/bazel"
 	"fmt"
 	"io/ioutil"
 	"path/filepath"
 	"strings"
+	"sync"
+	"text/scanner"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/parser"
 	"github.com/google/blueprint/proptools"
 )
 
@@ -39,6 +43,10 @@ type bazelModuleProperties struct {
 	// To opt-out a module, set bazel_module: { bp2build_available: false }
 	// To defer the default setting for the directory, do not set the value.
 	Bp2build_available *bool
+
+	// Labels of the Bazel toolchains required by the converted target, e.g. a toolchain providing
+	// a code generator. These have no Soong equivalent and are only used by bp2build.
+	Toolchains []string
 }
 
 // Properties contains common module properties for Bazel migration purposes.
@@ -92,6 +100,11 @@ func (b *BazelModuleBase) HandcraftedLabel() string {
 	return proptools.String(b.bazelProperties.Bazel_module.Label)
 }
 
+// BazelToolchains returns the labels of the Bazel toolchains required by the converted target.
+func (b *BazelModuleBase) BazelToolchains() []string {
+	return b.bazelProperties.Bazel_module.Toolchains
+}
+
 // GetBazelLabel returns the Bazel label for the given BazelModuleBase.
 func (b *BazelModuleBase) GetBazelLabel(ctx BazelConversionPathContext, module blueprint.Module) string {
 	if b.HasHandcraftedLabel() {
@@ -275,8 +288,124 @@ func (b *BazelModuleBase) GetBazelBuildFileContents(c Config, path, name string)
 	return string(data[:]), nil
 }
 
+// BlueprintModulePositions returns the positions of the definitions of the modules in the given
+// Android.bp file, keyed by module name. Modules whose name is not a string literal are omitted.
+func BlueprintModulePositions(c Config, blueprintFile string) (map[string]scanner.Position, error) {
+	f, err := c.fs.Open(blueprintFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file, errs := parser.Parse(blueprintFile, f, parser.NewScope(nil))
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	positions := make(map[string]scanner.Position)
+	for _, def := range file.Defs {
+		module, ok := def.(*parser.Module)
+		if !ok {
+			continue
+		}
+		if prop, ok := module.GetProperty("name"); ok {
+			if name, ok := prop.Value.(*parser.String); ok {
+				positions[name.Value] = module.TypePos
+			}
+		}
+	}
+	return positions, nil
+}
+
 // ConvertedToBazel returns whether this module has been converted to Bazel, whether automatically
 // or manually
 func (b *BazelModuleBase) ConvertedToBazel(ctx BazelConversionPathContext) bool {
 	return b.ConvertWithBp2build(ctx) || b.HasHandcraftedLabel()
 }
+
+// https://docs.bazel.build/versions/master/be/general.html#alias
+type bazelAliasAttributes struct {
+	Actual bazel.Label
+}
+
+type bazelAlias struct {
+	BazelTargetModuleBase
+	bazelAliasAttributes
+}
+
+func bazelAliasFactory() Module {
+	module := &bazelAlias{}
+	module.AddProperties(&module.bazelAliasAttributes)
+	InitBazelTargetModule(module)
+	return module
+}
+
+func (a *bazelAlias) Name() string {
+	return a.BaseModuleName()
+}
+
+func (a *bazelAlias) GenerateAndroidBuildActions(ctx ModuleContext) {}
+
+type bazelRequiredDependencyTag struct {
+	blueprint.BaseDependencyTag
+}
+
+var bazelRequiredTag = bazelRequiredDependencyTag{}
+
+func registerRequiredDepsMutatorBp2Build(ctx RegisterMutatorsContext) {
+	ctx.BottomUp("required_deps_bp2build", requiredDepsMutatorBp2Build).Parallel()
+}
+
+// Adds dependencies on the required, host_required and target_required modules of a module being
+// converted to Bazel, so that they can be resolved to labels. Required modules may be defined in
+// Make only, those are skipped.
+func requiredDepsMutatorBp2Build(ctx BottomUpMutatorContext) {
+	m := ctx.Module()
+	if b, ok := m.(Bazelable); !ok || !b.ConvertWithBp2build(ctx) {
+		return
+	}
+	props := m.base().commonProperties
+	var required []string
+	required = append(required, props.Required...)
+	required = append(required, props.Host_required...)
+	required = append(required, props.Target_required...)
+	for _, dep := range SortedUniqueStrings(required) {
+		if ctx.OtherModuleExists(dep) {
+			ctx.AddDependency(m, bazelRequiredTag, dep)
+		}
+	}
+}
+
+var bazelExportedFilesKey = NewOnceKey("BazelExportedFiles")
+
+type bazelExportedFiles struct {
+	sync.Mutex
+	files map[string][]string
+}
+
+func getBazelExportedFiles(config Config) *bazelExportedFiles {
+	return config.Once(bazelExportedFilesKey, func() interface{} {
+		return &bazelExportedFiles{files: make(map[string][]string)}
+	}).(*bazelExportedFiles)
+}
+
+// recordBazelExportedFile records that the file, relative to the Bazel package pkg, is referenced
+// from another package, so must be exported by pkg.
+func recordBazelExportedFile(config Config, pkg, file string) {
+	exported := getBazelExportedFiles(config)
+	exported.Lock()
+	defer exported.Unlock()
+	exported.files[pkg] = append(exported.files[pkg], file)
+}
+
+// BazelExportedFiles returns the sorted files which must be exported by each Bazel package, keyed
+// by package, because targets of other packages reference them.
+func BazelExportedFiles(config Config) map[string][]string {
+	exported := getBazelExportedFiles(config)
+	exported.Lock()
+	defer exported.Unlock()
+	ret := make(map[string][]string, len(exported.files))
+	for pkg, files := range exported.files {
+		ret[pkg] = SortedUniqueStrings(files)
+	}
+	return ret
+}
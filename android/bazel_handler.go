@@ -16,15 +16,23 @@ package android
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"android/soong/bazel/cquery"
 
@@ -41,11 +49,12 @@ const (
 	getAllFilesAndCcObjectFiles
 )
 
-// Map key to describe bazel cquery requests.
-type cqueryKey struct {
-	label       string
-	requestType cquery.RequestType
-	archType    ArchType
+// CqueryKey describes a bazel cquery request: the result of the given request type for the given
+// bazel target label, built for the given architecture.
+type CqueryKey struct {
+	Label       string
+	RequestType cquery.RequestType
+	ArchType    ArchType
 }
 
 type BazelContext interface {
@@ -60,12 +69,60 @@ type BazelContext interface {
 	// Returns the results of GetOutputFiles and GetCcObjectFiles in a single query (in that order).
 	GetOutputFilesAndCcObjectFiles(label string, archType ArchType) ([]string, []string, bool)
 
+	// Returns the object files compiled for the given bazel target label, without also querying
+	// its output files.
+	GetCcObjectFiles(label string, archType ArchType) ([]string, bool)
+
+	// Returns the container (e.g. APEX or APK) file built by the given bazel target label, along
+	// with the files bundled in it.
+	GetContainerInfo(label string, archType ArchType) (cquery.GetContainerInfo_Result, bool)
+
+	// Returns the include and system include directories exported by the given bazel cc target
+	// label and its dependencies.
+	GetCcIncludes(label string, archType ArchType) (cquery.GetCcIncludes_Result, bool)
+
+	// Returns the execroot-relative paths of the runfiles of the given bazel target label, e.g.
+	// the files a host tool needs at runtime.
+	GetRunfiles(label string, archType ArchType) ([]string, bool)
+
+	// Returns the execroot-relative paths of the files generated by the given bazel target label,
+	// e.g. .java or .cpp sources consumed by a Soong module, excluding checked-in source files.
+	GetGeneratedSources(label string, archType ArchType) ([]string, bool)
+
+	// Returns the fields of the ApexInfo provider of the given bazel apex target label, such as its
+	// min_sdk_version and payload files. This is queried along with GetContainerInfo, and returns an
+	// error if the target does not provide ApexInfo.
+	GetApexInfo(label string, archType ArchType) (cquery.ApexInfo, bool, error)
+
+	// Returns the execroot-relative path of the executable of the given bazel target label, e.g. a
+	// host tool run by a Soong rule. Returns an error if the target has no executable.
+	GetExecutable(label string, archType ArchType) (string, bool, error)
+
+	// Queues GetOutputFiles requests for all of the given bazel target labels at once. This is
+	// equivalent to calling GetOutputFiles for each label, but avoids contention on the request
+	// queue for modules that depend on many bazel targets.
+	QueueOutputFilesRequests(labels []string, archType ArchType)
+
 	// ** End cquery methods
 
 	// Issues commands to Bazel to receive results for all cquery requests
 	// queued in the BazelContext.
 	InvokeBazel() error
 
+	// Issues commands to Bazel to receive results for the given cquery requests only, returning
+	// the results and build statements instead of storing them in the BazelContext. Neither the
+	// queued requests nor the results of InvokeBazel are affected.
+	InvokeBazelForRequests(keys []CqueryKey) (map[CqueryKey]string, []bazel.BuildStatement, error)
+
+	// Returns the queued cquery requests which have no result, e.g. because the last InvokeBazel
+	// only partially succeeded, sorted by cquery id.
+	UnresolvedRequests() []CqueryKey
+
+	// Returns whether the given bazel label names an existing target. This issues a bazel query
+	// right away, which only loads the package of the label, so is much cheaper than queuing a
+	// cquery request for the label.
+	TargetExists(label string) (bool, error)
+
 	// Returns true if bazel is enabled for the given configuration.
 	BazelEnabled() bool
 
@@ -74,11 +131,27 @@ type BazelContext interface {
 
 	// Returns build statements which should get registered to reflect Bazel's outputs.
 	BuildStatementsToRegister() []bazel.BuildStatement
+
+	// Returns a copy of the raw results of all cquery requests issued by InvokeBazel, keyed by
+	// cquery id. Intended for debugging mixed builds.
+	AllResults() map[string]string
 }
 
-// A context object which tracks queued requests that need to be made to Bazel,
-// and their results after the requests have been made.
-type bazelContext struct {
+type bazelRunner interface {
+	// Issues the given bazel command with given build label and additional flags.
+	// Returns (stdout, stderr, error).
+	issueBazelCommand(paths *bazelPaths, runName bazel.RunName, command bazelCommand,
+		extraFlags ...string) (string, string, error)
+
+	// Issues the given bazel command like issueBazelCommand, but writes stdout directly to the
+	// file at outputPath instead of buffering it in memory. Returns (stderr, error).
+	issueBazelCommandToFile(paths *bazelPaths, runName bazel.RunName, command bazelCommand,
+		outputPath string, extraFlags ...string) (string, error)
+}
+
+// Paths to Bazel and the directories it operates on, shared between the bazelContext and the
+// bazelRunner which invokes Bazel.
+type bazelPaths struct {
 	homeDir      string
 	bazelPath    string
 	outputBase   string
@@ -86,13 +159,43 @@ type bazelContext struct {
 	buildDir     string
 	metricsDir   string
 
-	requests     map[cqueryKey]bool // cquery requests that have not yet been issued to Bazel
+	// An optional bazelrc file with site-specific flags, e.g. for caching.
+	rcFile string
+
+	// Optional directories for the install bases of Bazel servers and for the disk cache of action
+	// outputs, which may be shared with other builds on the machine, e.g. to keep a cache warm on
+	// CI machines. As Bazel is always invoked with an explicit --output_base, the output user root
+	// only holds the install base; the output base itself stays at BAZEL_OUTPUT_BASE.
+	outputUserRoot string
+	diskCache      string
+}
+
+// A context object which tracks queued requests that need to be made to Bazel,
+// and their results after the requests have been made.
+type bazelContext struct {
+	bazelRunner
+	paths *bazelPaths
+
+	requests     map[CqueryKey]bool // cquery requests that have not yet been issued to Bazel
 	requestMutex sync.Mutex         // requests can be written in parallel
 
-	results map[cqueryKey]string // Results of cquery requests after Bazel invocations
+	results map[CqueryKey]string // Results of cquery requests after Bazel invocations
+
+	// Additional flags passed to every Bazel command issued with a given run name.
+	runFlags map[bazel.RunName][]string
 
 	// Build statements which should get registered to reflect Bazel's outputs.
 	buildStatements []bazel.BuildStatement
+
+	// Whether the build statements are written to build_statements.json in the intermediates
+	// directory after Bazel is invoked, for debugging the Ninja rules generated from them.
+	dumpBuildStatements bool
+
+	// Whether a separate cquery is issued in parallel for each request type, each with a Starlark
+	// file formatting only the requests of that type, instead of a single cquery for all requests.
+	// A Bazel server runs a single command at a time, so each cquery but the first uses its own
+	// output base, and so its own server.
+	splitCquery bool
 }
 
 var _ BazelContext = &bazelContext{}
@@ -104,23 +207,154 @@ var _ BazelContext = noopBazelContext{}
 
 // A bazel context to use for tests.
 type MockBazelContext struct {
+	// The files of labels, returned as both output files and cc object files of labels that are
+	// not in LabelToOutputFiles or LabelToCcObjects respectively.
 	AllFiles map[string][]string
+
+	// The output files of labels, returned by GetOutputFiles and GetOutputFilesAndCcObjectFiles.
+	LabelToOutputFiles map[string][]string
+
+	// The cc object files of labels, returned by GetCcObjectFiles and
+	// GetOutputFilesAndCcObjectFiles.
+	LabelToCcObjects map[string][]string
+
+	// The runfiles of labels, returned by GetRunfiles.
+	AllRunfiles map[string][]string
+
+	// The ApexInfo of labels, returned by GetApexInfo.
+	AllApexInfo map[string]cquery.ApexInfo
+
+	// The executables of labels, returned by GetExecutable.
+	AllExecutables map[string]string
+
+	// The raw cquery results of requests, returned by InvokeBazelForRequests.
+	RequestResults map[CqueryKey]string
+
+	// The build statements returned by InvokeBazelForRequests.
+	BuildStatements []bazel.BuildStatement
 }
 
-func (m MockBazelContext) GetOutputFiles(label string, archType ArchType) ([]string, bool) {
+// outputFiles returns the output files of the label in LabelToOutputFiles, falling back to
+// AllFiles.
+func (m MockBazelContext) outputFiles(label string) ([]string, bool) {
+	if result, ok := m.LabelToOutputFiles[label]; ok {
+		return result, true
+	}
+	result, ok := m.AllFiles[label]
+	return result, ok
+}
+
+// ccObjects returns the cc object files of the label in LabelToCcObjects, falling back to
+// AllFiles.
+func (m MockBazelContext) ccObjects(label string) ([]string, bool) {
+	if result, ok := m.LabelToCcObjects[label]; ok {
+		return result, true
+	}
 	result, ok := m.AllFiles[label]
 	return result, ok
 }
 
+func (m MockBazelContext) GetOutputFiles(label string, archType ArchType) ([]string, bool) {
+	return m.outputFiles(label)
+}
+
+// GetOutputFilesAndCcObjectFiles returns the output files and cc object files of the label, and
+// whether either of them was stubbed.
 func (m MockBazelContext) GetOutputFilesAndCcObjectFiles(label string, archType ArchType) ([]string, []string, bool) {
+	outputFiles, outputFilesOk := m.outputFiles(label)
+	ccObjects, ccObjectsOk := m.ccObjects(label)
+	return outputFiles, ccObjects, outputFilesOk || ccObjectsOk
+}
+
+func (m MockBazelContext) GetCcObjectFiles(label string, archType ArchType) ([]string, bool) {
+	return m.ccObjects(label)
+}
+
+// GetContainerInfo returns the first file of the label in AllFiles as the container file, and the
+// remaining files as its contents.
+func (m MockBazelContext) GetContainerInfo(label string, archType ArchType) (cquery.GetContainerInfo_Result, bool) {
 	result, ok := m.AllFiles[label]
-	return result, result, ok
+	if !ok || len(result) == 0 {
+		return cquery.GetContainerInfo_Result{}, false
+	}
+	return cquery.GetContainerInfo_Result{ContainerFile: result[0], Contents: result[1:]}, true
+}
+
+// GetCcIncludes returns the files of the label in AllFiles as its include directories.
+func (m MockBazelContext) GetCcIncludes(label string, archType ArchType) (cquery.GetCcIncludes_Result, bool) {
+	result, ok := m.AllFiles[label]
+	return cquery.GetCcIncludes_Result{Includes: result}, ok
+}
+
+func (m MockBazelContext) GetRunfiles(label string, archType ArchType) ([]string, bool) {
+	result, ok := m.AllRunfiles[label]
+	return result, ok
+}
+
+// GetGeneratedSources returns the files of the label in AllFiles which are under bazel-out.
+func (m MockBazelContext) GetGeneratedSources(label string, archType ArchType) ([]string, bool) {
+	result, ok := m.AllFiles[label]
+	if !ok {
+		return nil, false
+	}
+	generated := []string{}
+	for _, file := range result {
+		if strings.HasPrefix(file, "bazel-out/") {
+			generated = append(generated, file)
+		}
+	}
+	return generated, true
+}
+
+func (m MockBazelContext) GetApexInfo(label string, archType ArchType) (cquery.ApexInfo, bool, error) {
+	result, ok := m.AllApexInfo[label]
+	return result, ok, nil
+}
+
+func (m MockBazelContext) GetExecutable(label string, archType ArchType) (string, bool, error) {
+	result, ok := m.AllExecutables[label]
+	return result, ok, nil
+}
+
+func (m MockBazelContext) QueueOutputFilesRequests(labels []string, archType ArchType) {
 }
 
 func (m MockBazelContext) InvokeBazel() error {
 	panic("unimplemented")
 }
 
+// InvokeBazelForRequests returns the results of the requests in RequestResults along with
+// BuildStatements, or the partial results and an error if any of the requests has no result.
+func (m MockBazelContext) InvokeBazelForRequests(keys []CqueryKey) (map[CqueryKey]string, []bazel.BuildStatement, error) {
+	requests := make(map[CqueryKey]bool, len(keys))
+	results := make(map[CqueryKey]string)
+	for _, key := range keys {
+		requests[key] = true
+		if result, ok := m.RequestResults[key]; ok {
+			results[key] = result
+		}
+	}
+	if unresolved := unresolvedRequests(requests, results); len(unresolved) > 0 {
+		var ids []string
+		for _, key := range unresolved {
+			ids = append(ids, getCqueryId(key))
+		}
+		return results, nil, fmt.Errorf("missing results for bazel targets %s", strings.Join(ids, ", "))
+	}
+	return results, m.BuildStatements, nil
+}
+
+// TargetExists returns whether the label is in AllFiles.
+func (m MockBazelContext) TargetExists(label string) (bool, error) {
+	_, ok := m.AllFiles[label]
+	return ok, nil
+}
+
+// UnresolvedRequests returns no requests, as requests are never queued in a MockBazelContext.
+func (m MockBazelContext) UnresolvedRequests() []CqueryKey {
+	return nil
+}
+
 func (m MockBazelContext) BazelEnabled() bool {
 	return true
 }
@@ -133,8 +367,100 @@ func (m MockBazelContext) BuildStatementsToRegister() []bazel.BuildStatement {
 	return []bazel.BuildStatement{}
 }
 
+func (m MockBazelContext) AllResults() map[string]string {
+	return map[string]string{}
+}
+
 var _ BazelContext = MockBazelContext{}
 
+// A BazelContext call recorded by RecordingBazelContext.
+type RecordedBazelCall struct {
+	Label       string
+	ArchType    ArchType
+	RequestType cquery.RequestType
+}
+
+// RecordingBazelContext wraps a BazelContext and records every cquery getter call made to it, so
+// that tests can assert which labels were queried.
+type RecordingBazelContext struct {
+	BazelContext
+
+	callsMutex sync.Mutex
+	calls      []RecordedBazelCall
+}
+
+// NewRecordingBazelContext returns a RecordingBazelContext delegating to the given BazelContext.
+func NewRecordingBazelContext(bazelCtx BazelContext) *RecordingBazelContext {
+	return &RecordingBazelContext{BazelContext: bazelCtx}
+}
+
+func (r *RecordingBazelContext) record(label string, archType ArchType, requestType cquery.RequestType) {
+	r.callsMutex.Lock()
+	defer r.callsMutex.Unlock()
+	r.calls = append(r.calls, RecordedBazelCall{label, archType, requestType})
+}
+
+// Calls returns the getter calls made so far, in the order they were made.
+func (r *RecordingBazelContext) Calls() []RecordedBazelCall {
+	r.callsMutex.Lock()
+	defer r.callsMutex.Unlock()
+	return append([]RecordedBazelCall(nil), r.calls...)
+}
+
+func (r *RecordingBazelContext) GetOutputFiles(label string, archType ArchType) ([]string, bool) {
+	r.record(label, archType, cquery.GetOutputFiles)
+	return r.BazelContext.GetOutputFiles(label, archType)
+}
+
+func (r *RecordingBazelContext) GetOutputFilesAndCcObjectFiles(label string, archType ArchType) ([]string, []string, bool) {
+	r.record(label, archType, cquery.GetOutputFilesAndCcObjectFiles)
+	return r.BazelContext.GetOutputFilesAndCcObjectFiles(label, archType)
+}
+
+func (r *RecordingBazelContext) GetCcObjectFiles(label string, archType ArchType) ([]string, bool) {
+	r.record(label, archType, cquery.GetCcObjectFiles)
+	return r.BazelContext.GetCcObjectFiles(label, archType)
+}
+
+func (r *RecordingBazelContext) QueueOutputFilesRequests(labels []string, archType ArchType) {
+	for _, label := range labels {
+		r.record(label, archType, cquery.GetOutputFiles)
+	}
+	r.BazelContext.QueueOutputFilesRequests(labels, archType)
+}
+
+func (r *RecordingBazelContext) GetContainerInfo(label string, archType ArchType) (cquery.GetContainerInfo_Result, bool) {
+	r.record(label, archType, cquery.GetContainerInfo)
+	return r.BazelContext.GetContainerInfo(label, archType)
+}
+
+func (r *RecordingBazelContext) GetCcIncludes(label string, archType ArchType) (cquery.GetCcIncludes_Result, bool) {
+	r.record(label, archType, cquery.GetCcIncludes)
+	return r.BazelContext.GetCcIncludes(label, archType)
+}
+
+func (r *RecordingBazelContext) GetRunfiles(label string, archType ArchType) ([]string, bool) {
+	r.record(label, archType, cquery.GetRunfiles)
+	return r.BazelContext.GetRunfiles(label, archType)
+}
+
+func (r *RecordingBazelContext) GetGeneratedSources(label string, archType ArchType) ([]string, bool) {
+	r.record(label, archType, cquery.GetGeneratedSources)
+	return r.BazelContext.GetGeneratedSources(label, archType)
+}
+
+func (r *RecordingBazelContext) GetApexInfo(label string, archType ArchType) (cquery.ApexInfo, bool, error) {
+	r.record(label, archType, cquery.GetContainerInfo)
+	return r.BazelContext.GetApexInfo(label, archType)
+}
+
+func (r *RecordingBazelContext) GetExecutable(label string, archType ArchType) (string, bool, error) {
+	r.record(label, archType, cquery.GetExecutable)
+	return r.BazelContext.GetExecutable(label, archType)
+}
+
+var _ BazelContext = &RecordingBazelContext{}
+
 func (bazelCtx *bazelContext) GetOutputFiles(label string, archType ArchType) ([]string, bool) {
 	rawString, ok := bazelCtx.cquery(label, cquery.GetOutputFiles, archType)
 	var ret []string
@@ -160,16 +486,146 @@ func (bazelCtx *bazelContext) GetOutputFilesAndCcObjectFiles(label string, archT
 	return outputFiles, ccObjects, ok
 }
 
+func (bazelCtx *bazelContext) GetCcObjectFiles(label string, archType ArchType) ([]string, bool) {
+	rawString, ok := bazelCtx.cquery(label, cquery.GetCcObjectFiles, archType)
+	var ret []string
+	if ok {
+		bazelOutput := strings.TrimSpace(rawString)
+		ret = cquery.GetCcObjectFiles.ParseResult(bazelOutput).([]string)
+	}
+	return ret, ok
+}
+
+func (bazelCtx *bazelContext) QueueOutputFilesRequests(labels []string, archType ArchType) {
+	bazelCtx.requestMutex.Lock()
+	defer bazelCtx.requestMutex.Unlock()
+	for _, label := range labels {
+		key := CqueryKey{label, cquery.GetOutputFiles, archType}
+		if _, ok := bazelCtx.results[key]; !ok {
+			bazelCtx.requests[key] = true
+		}
+	}
+}
+
+func (bazelCtx *bazelContext) GetContainerInfo(label string, archType ArchType) (cquery.GetContainerInfo_Result, bool) {
+	var ret cquery.GetContainerInfo_Result
+	result, ok := bazelCtx.cquery(label, cquery.GetContainerInfo, archType)
+	if ok {
+		bazelOutput := strings.TrimSpace(result)
+		ret = cquery.GetContainerInfo.ParseResult(bazelOutput).(cquery.GetContainerInfo_Result)
+	}
+	return ret, ok
+}
+
+func (bazelCtx *bazelContext) GetCcIncludes(label string, archType ArchType) (cquery.GetCcIncludes_Result, bool) {
+	var ret cquery.GetCcIncludes_Result
+	result, ok := bazelCtx.cquery(label, cquery.GetCcIncludes, archType)
+	if ok {
+		bazelOutput := strings.TrimSpace(result)
+		ret = cquery.GetCcIncludes.ParseResult(bazelOutput).(cquery.GetCcIncludes_Result)
+	}
+	return ret, ok
+}
+
+func (bazelCtx *bazelContext) GetRunfiles(label string, archType ArchType) ([]string, bool) {
+	rawString, ok := bazelCtx.cquery(label, cquery.GetRunfiles, archType)
+	var ret []string
+	if ok {
+		bazelOutput := strings.TrimSpace(rawString)
+		ret = cquery.GetRunfiles.ParseResult(bazelOutput).([]string)
+	}
+	return ret, ok
+}
+
+func (bazelCtx *bazelContext) GetGeneratedSources(label string, archType ArchType) ([]string, bool) {
+	rawString, ok := bazelCtx.cquery(label, cquery.GetGeneratedSources, archType)
+	var ret []string
+	if ok {
+		bazelOutput := strings.TrimSpace(rawString)
+		ret = cquery.GetGeneratedSources.ParseResult(bazelOutput).([]string)
+	}
+	return ret, ok
+}
+
+func (bazelCtx *bazelContext) GetApexInfo(label string, archType ArchType) (cquery.ApexInfo, bool, error) {
+	containerInfo, ok := bazelCtx.GetContainerInfo(label, archType)
+	if !ok {
+		return cquery.ApexInfo{}, false, nil
+	}
+	if containerInfo.ApexInfo == nil {
+		return cquery.ApexInfo{}, true, fmt.Errorf("%s does not provide ApexInfo", label)
+	}
+	return *containerInfo.ApexInfo, true, nil
+}
+
+func (bazelCtx *bazelContext) GetExecutable(label string, archType ArchType) (string, bool, error) {
+	rawString, ok := bazelCtx.cquery(label, cquery.GetExecutable, archType)
+	if !ok {
+		return "", false, nil
+	}
+	bazelOutput := strings.TrimSpace(rawString)
+	ret := cquery.GetExecutable.ParseResult(bazelOutput).(string)
+	if ret == "" {
+		return "", true, fmt.Errorf("%s has no executable; only executable targets such as *_binary can be run", label)
+	}
+	return ret, true, nil
+}
+
+// The getters of noopBazelContext report results as not available from Bazel, so that code paths
+// reaching them with Bazel disabled fall back to Soong instead of crashing.
 func (n noopBazelContext) GetOutputFiles(label string, archType ArchType) ([]string, bool) {
-	panic("unimplemented")
+	return nil, false
 }
 
 func (n noopBazelContext) GetOutputFilesAndCcObjectFiles(label string, archType ArchType) ([]string, []string, bool) {
-	panic("unimplemented")
+	return nil, nil, false
+}
+
+func (n noopBazelContext) GetCcObjectFiles(label string, archType ArchType) ([]string, bool) {
+	return nil, false
+}
+
+func (n noopBazelContext) GetContainerInfo(label string, archType ArchType) (cquery.GetContainerInfo_Result, bool) {
+	return cquery.GetContainerInfo_Result{}, false
+}
+
+func (n noopBazelContext) GetCcIncludes(label string, archType ArchType) (cquery.GetCcIncludes_Result, bool) {
+	return cquery.GetCcIncludes_Result{}, false
+}
+
+func (n noopBazelContext) GetRunfiles(label string, archType ArchType) ([]string, bool) {
+	return nil, false
+}
+
+func (n noopBazelContext) GetGeneratedSources(label string, archType ArchType) ([]string, bool) {
+	return nil, false
+}
+
+func (n noopBazelContext) GetApexInfo(label string, archType ArchType) (cquery.ApexInfo, bool, error) {
+	return cquery.ApexInfo{}, false, nil
+}
+
+func (n noopBazelContext) GetExecutable(label string, archType ArchType) (string, bool, error) {
+	return "", false, nil
+}
+
+func (n noopBazelContext) QueueOutputFilesRequests(labels []string, archType ArchType) {
 }
 
 func (n noopBazelContext) InvokeBazel() error {
-	panic("unimplemented")
+	return nil
+}
+
+func (n noopBazelContext) InvokeBazelForRequests(keys []CqueryKey) (map[CqueryKey]string, []bazel.BuildStatement, error) {
+	return nil, nil, nil
+}
+
+func (n noopBazelContext) UnresolvedRequests() []CqueryKey {
+	return nil
+}
+
+func (n noopBazelContext) TargetExists(label string) (bool, error) {
+	return false, nil
 }
 
 func (m noopBazelContext) OutputBase() string {
@@ -184,6 +640,10 @@ func (m noopBazelContext) BuildStatementsToRegister() []bazel.BuildStatement {
 	return []bazel.BuildStatement{}
 }
 
+func (m noopBazelContext) AllResults() map[string]string {
+	return map[string]string{}
+}
+
 func NewBazelContext(c *config) (BazelContext, error) {
 	// TODO(cparsons): Assess USE_BAZEL=1 instead once "mixed Soong/Bazel builds"
 	// are production ready.
@@ -191,121 +651,586 @@ func NewBazelContext(c *config) (BazelContext, error) {
 		return noopBazelContext{}, nil
 	}
 
-	bazelCtx := bazelContext{buildDir: c.buildDir, requests: make(map[cqueryKey]bool)}
+	p, err := bazelPathsFromConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	runner, err := newBuiltinBazelRunner(c)
+	if err != nil {
+		return nil, err
+	}
+	return &bazelContext{
+		bazelRunner:         runner,
+		paths:               p,
+		requests:            make(map[CqueryKey]bool),
+		runFlags:            defaultRunFlags(),
+		dumpBuildStatements: c.IsEnvTrue("BAZEL_DUMP_BUILD_STATEMENTS"),
+		splitCquery:         c.IsEnvTrue("BAZEL_SPLIT_CQUERY"),
+	}, nil
+}
+
+// defaultRunFlags returns the flags passed to Bazel invocations of specific run names, in addition
+// to the flags common to all invocations.
+func defaultRunFlags() map[bazel.RunName][]string {
+	return map[bazel.RunName][]string{
+		// The phony root build only needs to create symlink forests for the dependencies of the
+		// build; the actions it runs are trivial, so avoid the overhead of sandboxing and runfiles
+		// trees.
+		bazel.BazelBuildPhonyRootRunName: []string{
+			"--spawn_strategy=local",
+			"--nobuild_runfile_links",
+		},
+	}
+}
+
+// flagsForRun returns the flags for a Bazel invocation of the given run name: the flags configured
+// for the run name followed by extraFlags.
+func (context *bazelContext) flagsForRun(runName bazel.RunName, extraFlags ...string) []string {
+	var flags []string
+	flags = append(flags, context.runFlags[runName]...)
+	return append(flags, extraFlags...)
+}
+
+func bazelPathsFromConfig(c *config) (*bazelPaths, error) {
+	p := bazelPaths{
+		buildDir: c.buildDir,
+	}
 	missingEnvVars := []string{}
 	if len(c.Getenv("BAZEL_HOME")) > 1 {
-		bazelCtx.homeDir = c.Getenv("BAZEL_HOME")
+		p.homeDir = c.Getenv("BAZEL_HOME")
 	} else {
 		missingEnvVars = append(missingEnvVars, "BAZEL_HOME")
 	}
 	if len(c.Getenv("BAZEL_PATH")) > 1 {
-		bazelCtx.bazelPath = c.Getenv("BAZEL_PATH")
+		p.bazelPath = c.Getenv("BAZEL_PATH")
 	} else {
 		missingEnvVars = append(missingEnvVars, "BAZEL_PATH")
 	}
 	if len(c.Getenv("BAZEL_OUTPUT_BASE")) > 1 {
-		bazelCtx.outputBase = c.Getenv("BAZEL_OUTPUT_BASE")
+		p.outputBase = c.Getenv("BAZEL_OUTPUT_BASE")
 	} else {
 		missingEnvVars = append(missingEnvVars, "BAZEL_OUTPUT_BASE")
 	}
 	if len(c.Getenv("BAZEL_WORKSPACE")) > 1 {
-		bazelCtx.workspaceDir = c.Getenv("BAZEL_WORKSPACE")
+		p.workspaceDir = c.Getenv("BAZEL_WORKSPACE")
 	} else {
 		missingEnvVars = append(missingEnvVars, "BAZEL_WORKSPACE")
 	}
 	if len(c.Getenv("BAZEL_METRICS_DIR")) > 1 {
-		bazelCtx.metricsDir = c.Getenv("BAZEL_METRICS_DIR")
+		p.metricsDir = c.Getenv("BAZEL_METRICS_DIR")
 	} else {
 		missingEnvVars = append(missingEnvVars, "BAZEL_METRICS_DIR")
 	}
+	p.rcFile = c.Getenv("BAZEL_RC_FILE")
+	p.outputUserRoot = c.Getenv("BAZEL_OUTPUT_USER_ROOT")
+	p.diskCache = c.Getenv("BAZEL_DISK_CACHE")
 	if len(missingEnvVars) > 0 {
 		return nil, errors.New(fmt.Sprintf("missing required env vars to use bazel: %s", missingEnvVars))
 	} else {
-		return &bazelCtx, nil
+		return &p, nil
+	}
+}
+
+// validate returns an error if the Bazel binary or workspace of the paths do not exist, or if the
+// optional output user root or disk cache directories can't be created, so that a misconfigured
+// environment is reported up front rather than when Bazel is first invoked.
+func (p *bazelPaths) validate() error {
+	if info, err := os.Stat(p.bazelPath); err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("BAZEL_PATH %s is not an executable file", p.bazelPath)
+	}
+	if info, err := os.Stat(p.workspaceDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("BAZEL_WORKSPACE %s is not a directory", p.workspaceDir)
+	}
+	for _, dir := range []struct{ envVar, path string }{
+		{"BAZEL_OUTPUT_USER_ROOT", p.outputUserRoot},
+		{"BAZEL_DISK_CACHE", p.diskCache},
+	} {
+		if dir.path == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir.path, 0777); err != nil {
+			return fmt.Errorf("%s %s is not a directory and could not be created", dir.envVar, dir.path)
+		}
+	}
+	return nil
+}
+
+func (p *bazelPaths) BazelMetricsDir() string {
+	return p.metricsDir
+}
+
+func (context *bazelContext) BazelEnabled() bool {
+	return true
+}
+
+// Adds a cquery request to the Bazel request queue, to be later invoked, or
+// returns the result of the given request if the request was already made.
+// If the given request was already made (and the results are available), then
+// returns (result, true). If the request is queued but no results are available,
+// then returns ("", false).
+func (context *bazelContext) cquery(label string, requestType cquery.RequestType,
+	archType ArchType) (string, bool) {
+	key := CqueryKey{label, requestType, archType}
+	if result, ok := context.results[key]; ok {
+		return result, true
+	} else {
+		context.requestMutex.Lock()
+		defer context.requestMutex.Unlock()
+		context.requests[key] = true
+		return "", false
+	}
+}
+
+func pwdPrefix() string {
+	// Darwin doesn't have /proc
+	if runtime.GOOS != "darwin" {
+		return "PWD=/proc/self/cwd"
+	}
+	return ""
+}
+
+type bazelCommand struct {
+	command string
+	// query or label
+	expression string
+}
+
+type mockBazelRunner struct {
+	// Guards the recorded commands, as commands may be issued from multiple goroutines.
+	mutex               sync.Mutex
+	bazelCommandResults map[bazelCommand]string
+	// Errors returned by issued commands, keyed by the command.
+	bazelCommandErrors map[bazelCommand]error
+	commands           []bazelCommand
+	// Extra flags of each issued command, keyed by the command.
+	extraFlags map[bazelCommand][]string
+}
+
+func (r *mockBazelRunner) issueBazelCommand(paths *bazelPaths,
+	runName bazel.RunName,
+	command bazelCommand,
+	extraFlags ...string) (string, string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.commands = append(r.commands, command)
+	if r.extraFlags == nil {
+		r.extraFlags = map[bazelCommand][]string{}
+	}
+	r.extraFlags[command] = extraFlags
+	if err, ok := r.bazelCommandErrors[command]; ok {
+		return "", "", err
 	}
+	if ret, ok := r.bazelCommandResults[command]; ok {
+		return ret, "", nil
+	}
+	return "", "", nil
+}
+
+func (r *mockBazelRunner) issueBazelCommandToFile(paths *bazelPaths,
+	runName bazel.RunName,
+	command bazelCommand,
+	outputPath string,
+	extraFlags ...string) (string, error) {
+	stdout, stderr, err := r.issueBazelCommand(paths, runName, command, extraFlags...)
+	if err != nil {
+		return stderr, err
+	}
+	return stderr, ioutil.WriteFile(outputPath, []byte(stdout), 0666)
+}
+
+// BazelCommandError is the error returned when a Bazel invocation fails, either because Bazel
+// could not be run or because it exited with an error.
+type BazelCommandError struct {
+	// The command line of the invocation.
+	Command string
+	// The exit code of Bazel, or -1 if Bazel did not exit by itself.
+	ExitCode int
+	// The output of the invocation. Stdout is empty if it was written to a file.
+	Stdout string
+	Stderr string
+	// The underlying error.
+	Err error
+}
+
+func (e *BazelCommandError) Error() string {
+	return fmt.Sprintf("bazel command failed. command: [%s], exit code: %d, error: %s, stderr: [%s]",
+		e.Command, e.ExitCode, e.Err, e.Stderr)
+}
+
+func (e *BazelCommandError) Unwrap() error {
+	return e.Err
+}
+
+const (
+	// The time after which a Bazel invocation is assumed to be hung and is killed, unless
+	// overridden with BAZEL_TIMEOUT.
+	defaultBazelTimeout = 20 * time.Minute
+
+	// The number of times a Bazel invocation failing with a transient error is retried, unless
+	// overridden with BAZEL_RETRIES.
+	defaultBazelRetries = 2
+
+	// The delay before the first retry of a Bazel invocation, which doubles with each further
+	// retry.
+	defaultBazelRetryDelay = 5 * time.Second
+)
+
+// Patterns in the stderr of a failed Bazel invocation indicating that it may succeed if retried.
+// Bazel waits for the lock of the output base held by another command, so failing to acquire it is
+// not transient.
+var transientBazelErrors = []string{
+	"Server terminated abruptly",
+}
+
+type builtinBazelRunner struct {
+	// The OS and arch of the build host, which determine the host platform and toolchains of
+	// Bazel invocations.
+	hostOs   OsType
+	hostArch ArchType
+
+	// The time after which a Bazel invocation is killed, or 0 for no limit.
+	timeout time.Duration
+
+	// The number of times a Bazel invocation failing with a transient error is retried.
+	retries int
+
+	// The delay before the first retry of a Bazel invocation, which doubles with each further
+	// retry.
+	retryDelay time.Duration
+
+	// Where the stderr of Bazel invocations, including their progress messages, is streamed to as
+	// it is written, or nil if it is only captured.
+	progress io.Writer
+
+	// The arguments passed to the JVM of the Bazel server, e.g. -Xmx16g, each with a
+	// --host_jvm_args startup flag.
+	hostJvmArgs []string
+}
+
+func newBuiltinBazelRunner(c *config) (*builtinBazelRunner, error) {
+	r := &builtinBazelRunner{
+		hostOs:     BuildOs,
+		hostArch:   BuildArch,
+		timeout:    defaultBazelTimeout,
+		retries:    defaultBazelRetries,
+		retryDelay: defaultBazelRetryDelay,
+	}
+	if targets := c.Targets[BuildOs]; len(targets) > 0 {
+		r.hostArch = targets[0].Arch.ArchType
+	}
+	if s := c.Getenv("BAZEL_TIMEOUT"); s != "" {
+		timeout, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BAZEL_TIMEOUT %q: %s", s, err)
+		}
+		r.timeout = timeout
+	}
+	if s := c.Getenv("BAZEL_RETRIES"); s != "" {
+		retries, err := strconv.Atoi(s)
+		if err != nil || retries < 0 {
+			return nil, fmt.Errorf("invalid BAZEL_RETRIES %q: must be a non-negative integer", s)
+		}
+		r.retries = retries
+	}
+	if c.IsEnvTrue("BAZEL_VERBOSE") {
+		r.progress = os.Stderr
+	}
+	r.hostJvmArgs = strings.Fields(c.Getenv("BAZEL_HOST_JVM_ARGS"))
+	return r, nil
+}
+
+// waitBeforeRetry sleeps before the given retry of a failed Bazel invocation, starting at 0, giving
+// the cause of a transient failure time to clear.
+func (r *builtinBazelRunner) waitBeforeRetry(retry int) {
+	time.Sleep(r.retryDelay << uint(retry))
+}
+
+// isTransientBazelError returns true if err is the error of a Bazel invocation which may succeed
+// if retried.
+func isTransientBazelError(err error) bool {
+	var commandErr *BazelCommandError
+	if !errors.As(err, &commandErr) {
+		return false
+	}
+	for _, pattern := range transientBazelErrors {
+		if strings.Contains(commandErr.Stderr, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// Issues the given bazel command with given build label and additional flags.
+// Returns (stdout, stderr, error). The first and second return values are strings
+// containing the stdout and stderr of the run command, and an error is returned if
+// the invocation returned an error code. Invocations failing with a transient error
+// are retried.
+func (r *builtinBazelRunner) issueBazelCommand(paths *bazelPaths, runName bazel.RunName, command bazelCommand,
+	extraFlags ...string) (string, string, error) {
+	for attempt := 0; ; attempt++ {
+		stdout, stderr, err := r.issueBazelCommandOnce(paths, runName, command, extraFlags...)
+		if err == nil || attempt >= r.retries || !isTransientBazelError(err) {
+			return stdout, stderr, err
+		}
+		r.waitBeforeRetry(attempt)
+	}
+}
+
+func (r *builtinBazelRunner) issueBazelCommandOnce(paths *bazelPaths, runName bazel.RunName, command bazelCommand,
+	extraFlags ...string) (string, string, error) {
+	ctx, cancel := r.commandContext()
+	defer cancel()
+	bazelCmd, err := r.bazelCmd(ctx, paths, runName, command, extraFlags...)
+	if err != nil {
+		return "", "", err
+	}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	bazelCmd.Stdout = stdout
+	bazelCmd.Stderr = r.stderrWriter(stderr)
+
+	if err := runBazelCmd(ctx, paths, bazelCmd, stdout, stderr); err != nil {
+		return "", string(stderr.Bytes()), err
+	}
+	return string(stdout.Bytes()), string(stderr.Bytes()), nil
+}
+
+// Issues the given bazel command with given build label and additional flags, writing its stdout
+// to the file at outputPath. Returns (stderr, error). Invocations failing with a transient error
+// are retried.
+func (r *builtinBazelRunner) issueBazelCommandToFile(paths *bazelPaths, runName bazel.RunName, command bazelCommand,
+	outputPath string, extraFlags ...string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		stderr, err := r.issueBazelCommandToFileOnce(paths, runName, command, outputPath, extraFlags...)
+		if err == nil || attempt >= r.retries || !isTransientBazelError(err) {
+			return stderr, err
+		}
+		r.waitBeforeRetry(attempt)
+	}
+}
+
+func (r *builtinBazelRunner) issueBazelCommandToFileOnce(paths *bazelPaths, runName bazel.RunName, command bazelCommand,
+	outputPath string, extraFlags ...string) (string, error) {
+	outputFile, err := os.Create(outputPath)
+	if err != nil {
+		return "", err
+	}
+	defer outputFile.Close()
+
+	ctx, cancel := r.commandContext()
+	defer cancel()
+	bazelCmd, err := r.bazelCmd(ctx, paths, runName, command, extraFlags...)
+	if err != nil {
+		return "", err
+	}
+	stderr := &bytes.Buffer{}
+	bazelCmd.Stdout = outputFile
+	bazelCmd.Stderr = r.stderrWriter(stderr)
+
+	if err := runBazelCmd(ctx, paths, bazelCmd, nil, stderr); err != nil {
+		return string(stderr.Bytes()), err
+	}
+	return string(stderr.Bytes()), nil
+}
+
+// stderrWriter returns the writer for the stderr of a Bazel invocation, which is captured in
+// stderr for reporting errors and, if the runner is verbose, also streamed to the console.
+func (r *builtinBazelRunner) stderrWriter(stderr *bytes.Buffer) io.Writer {
+	if r.progress == nil {
+		return stderr
+	}
+	return io.MultiWriter(stderr, r.progress)
+}
+
+// commandContext returns the context of a Bazel invocation, which expires after the timeout of
+// the runner.
+func (r *builtinBazelRunner) commandContext() (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), r.timeout)
+}
+
+func (r *builtinBazelRunner) bazelCmd(ctx context.Context, paths *bazelPaths, runName bazel.RunName,
+	command bazelCommand, extraFlags ...string) (*exec.Cmd, error) {
+	cmdFlags, err := r.bazelCmdFlags(paths, runName, command, extraFlags...)
+	if err != nil {
+		return nil, err
+	}
+	bazelCmd := exec.CommandContext(ctx, paths.bazelPath, cmdFlags...)
+	bazelCmd.Dir = paths.workspaceDir
+	bazelCmd.Env = append(os.Environ(), "HOME="+paths.homeDir, pwdPrefix(),
+		// Disables local host detection of gcc; toolchain information is defined
+		// explicitly in BUILD files.
+		"BAZEL_DO_NOT_DETECT_CPP_TOOLCHAIN=1")
+	return bazelCmd, nil
 }
 
-func (context *bazelContext) BazelMetricsDir() string {
-	return context.metricsDir
+// killBazelServer kills the Bazel server of the output base of paths, which keeps running the
+// command of a client that timed out. The pid of the server is read from the output base; nothing
+// is killed if no server is running.
+func killBazelServer(paths *bazelPaths) {
+	pidFile := filepath.Join(paths.outputBase, "server", "server.pid.txt")
+	if !filepath.IsAbs(pidFile) {
+		// The output base is relative to the directory the client runs in.
+		pidFile = filepath.Join(paths.workspaceDir, pidFile)
+	}
+	contents, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil || pid <= 0 {
+		return
+	}
+	syscall.Kill(pid, syscall.SIGKILL)
 }
 
-func (context *bazelContext) BazelEnabled() bool {
-	return true
-}
+// runBazelCmd runs bazelCmd, killing the client and the Bazel server of paths if ctx expires
+// before it finishes. The client stays in the foreground process group, so that it receives
+// terminal signals such as Ctrl-C. Returns a BazelCommandError if it fails or times out. stdout
+// is nil if the output of bazelCmd is not buffered.
+func runBazelCmd(ctx context.Context, paths *bazelPaths, bazelCmd *exec.Cmd, stdout, stderr *bytes.Buffer) error {
+	commandError := func(err error) error {
+		ret := &BazelCommandError{
+			Command:  bazelCmd.String(),
+			ExitCode: -1,
+			Stderr:   stderr.String(),
+			Err:      err,
+		}
+		if stdout != nil {
+			ret.Stdout = stdout.String()
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			ret.ExitCode = exitErr.ExitCode()
+		}
+		return ret
+	}
 
-// Adds a cquery request to the Bazel request queue, to be later invoked, or
-// returns the result of the given request if the request was already made.
-// If the given request was already made (and the results are available), then
-// returns (result, true). If the request is queued but no results are available,
-// then returns ("", false).
-func (context *bazelContext) cquery(label string, requestType cquery.RequestType,
-	archType ArchType) (string, bool) {
-	key := cqueryKey{label, requestType, archType}
-	if result, ok := context.results[key]; ok {
-		return result, true
-	} else {
-		context.requestMutex.Lock()
-		defer context.requestMutex.Unlock()
-		context.requests[key] = true
-		return "", false
+	start := time.Now()
+	if err := bazelCmd.Start(); err != nil {
+		return commandError(err)
 	}
-}
 
-func pwdPrefix() string {
-	// Darwin doesn't have /proc
-	if runtime.GOOS != "darwin" {
-		return "PWD=/proc/self/cwd"
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			bazelCmd.Process.Kill()
+			killBazelServer(paths)
+		case <-done:
+		}
+	}()
+	err := bazelCmd.Wait()
+	close(done)
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return commandError(fmt.Errorf("timed out after %s", time.Since(start).Round(time.Millisecond)))
+	} else if err != nil {
+		return commandError(err)
 	}
-	return ""
+	return nil
 }
 
-// Issues the given bazel command with given build label and additional flags.
-// Returns (stdout, stderr, error). The first and second return values are strings
-// containing the stdout and stderr of the run command, and an error is returned if
-// the invocation returned an error code.
-func (context *bazelContext) issueBazelCommand(runName bazel.RunName, command string, labels []string,
-	extraFlags ...string) (string, string, error) {
-
-	cmdFlags := []string{"--output_base=" + context.outputBase, command}
-	cmdFlags = append(cmdFlags, labels...)
-	cmdFlags = append(cmdFlags, "--package_path=%workspace%/"+context.intermediatesDir())
-	cmdFlags = append(cmdFlags, "--profile="+shared.BazelMetricsFilename(context, runName))
+// bazelCmdFlags returns the command line flags of a Bazel invocation of the given command, or an
+// error if the host of the runner has no Bazel platform.
+func (r *builtinBazelRunner) bazelCmdFlags(paths *bazelPaths, runName bazel.RunName, command bazelCommand,
+	extraFlags ...string) ([]string, error) {
+	cmdFlags := []string{"--output_base=" + paths.outputBase}
+	if paths.outputUserRoot != "" {
+		cmdFlags = append(cmdFlags, "--output_user_root="+paths.outputUserRoot)
+	}
+	if paths.rcFile != "" {
+		cmdFlags = append(cmdFlags, "--bazelrc="+paths.rcFile)
+	}
+	for _, arg := range r.hostJvmArgs {
+		cmdFlags = append(cmdFlags, "--host_jvm_args="+arg)
+	}
+	cmdFlags = append(cmdFlags, command.command)
+	cmdFlags = append(cmdFlags, command.expression)
+	cmdFlags = append(cmdFlags, "--package_path=%workspace%/"+paths.intermediatesDir())
+	cmdFlags = append(cmdFlags, "--profile="+shared.BazelMetricsFilename(paths, runName))
+	if command.command == "query" {
+		// query only loads targets, so doesn't accept the build and configuration flags below.
+		cmdFlags = append(cmdFlags, "--experimental_repository_disable_download")
+		return append(cmdFlags, extraFlags...), nil
+	}
+	if paths.diskCache != "" {
+		cmdFlags = append(cmdFlags, "--disk_cache="+paths.diskCache)
+	}
 
 	// Set default platforms to canonicalized values for mixed builds requests.
 	// If these are set in the bazelrc, they will have values that are
 	// non-canonicalized to @sourceroot labels, and thus be invalid when
-	// referenced from the buildroot.
+	// referenced from the buildroot. Command line flags take precedence over
+	// those of the bazelrc, including a BAZEL_RC_FILE.
 	//
-	// The actual platform values here may be overridden by configuration
+	// The actual platform values here may be overridden by the --platforms flag
+	// derived from the requests (see targetPlatformFlags), and by configuration
 	// transitions from the buildroot.
-	cmdFlags = append(cmdFlags,
-		fmt.Sprintf("--platforms=%s", canonicalizeLabel("//build/bazel/platforms:android_x86_64")))
-	cmdFlags = append(cmdFlags,
-		fmt.Sprintf("--extra_toolchains=%s", canonicalizeLabel("//prebuilts/clang/host/linux-x86:all")))
-	// This should be parameterized on the host OS, but let's restrict to linux
-	// to keep things simple for now.
-	cmdFlags = append(cmdFlags,
-		fmt.Sprintf("--host_platform=%s", canonicalizeLabel("//build/bazel/platforms:linux_x86_64")))
+	platformsFlag, err := platformFlag("--platforms", bazel.OS_ANDROID, bazel.ARCH_X86_64)
+	if err != nil {
+		return nil, err
+	}
+	hostPlatformFlag, err := platformFlag("--host_platform", r.hostOs.Name, r.hostArch.Name)
+	if err != nil {
+		return nil, err
+	}
+	cmdFlags = append(cmdFlags, platformsFlag)
+	cmdFlags = append(cmdFlags, fmt.Sprintf("--extra_toolchains=%s",
+		sourcerootLabel("//prebuilts/clang/host/"+hostPrebuiltTag(r.hostOs)+":all")))
+	cmdFlags = append(cmdFlags, hostPlatformFlag)
 
 	// Explicitly disable downloading rules (such as canonical C++ and Java rules) from the network.
 	cmdFlags = append(cmdFlags, "--experimental_repository_disable_download")
-	cmdFlags = append(cmdFlags, extraFlags...)
+	return append(cmdFlags, extraFlags...), nil
+}
 
-	bazelCmd := exec.Command(context.bazelPath, cmdFlags...)
-	bazelCmd.Dir = context.workspaceDir
-	bazelCmd.Env = append(os.Environ(), "HOME="+context.homeDir, pwdPrefix(),
-		// Disables local host detection of gcc; toolchain information is defined
-		// explicitly in BUILD files.
-		"BAZEL_DO_NOT_DETECT_CPP_TOOLCHAIN=1")
-	stderr := &bytes.Buffer{}
-	bazelCmd.Stderr = stderr
+// hostPrebuiltTag returns the name of the directory of the prebuilts for the given host OS, e.g.
+// linux-x86.
+func hostPrebuiltTag(hostOs OsType) string {
+	if hostOs == Darwin {
+		return "darwin-x86"
+	}
+	return "linux-x86"
+}
 
-	if output, err := bazelCmd.Output(); err != nil {
-		return "", string(stderr.Bytes()),
-			fmt.Errorf("bazel command failed. command: [%s], env: [%s], error [%s]", bazelCmd, bazelCmd.Env, stderr)
-	} else {
-		return string(output), string(stderr.Bytes()), nil
+// platformFlag returns a command line flag setting the given flag to the canonicalized label of
+// the Bazel platform for os and arch, or an error if there is no such platform.
+func platformFlag(flag, os, arch string) (string, error) {
+	label, err := bazel.PlatformLabel(os, arch)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", flag, err)
+	}
+	return fmt.Sprintf("%s=%s", flag, sourcerootLabel(label)), nil
+}
+
+// targetPlatformFlags returns the flags overriding the default target platform of Bazel commands
+// for the given requests. If all requests are for the same device architecture, the commands
+// target the Android platform of that architecture, so that the output paths of the buildroot
+// match those of the requested targets. Otherwise the default platform is kept and the
+// architecture of each request is set by the transition of its config_node.
+func targetPlatformFlags(requests map[CqueryKey]bool) ([]string, error) {
+	arch := ""
+	for key := range requests {
+		if keyArch := getArchString(key); arch == "" {
+			arch = keyArch
+		} else if arch != keyArch {
+			return nil, nil
+		}
 	}
+	if _, ok := bazel.PlatformArchMap[arch]; !ok || arch == bazel.ARCH_X86_64 {
+		return nil, nil
+	}
+	flag, err := platformFlag("--platforms", bazel.OS_ANDROID, arch)
+	if err != nil {
+		return nil, err
+	}
+	return []string{flag}, nil
 }
 
 // Returns the string contents of a workspace file that should be output
@@ -325,42 +1250,91 @@ local_repository(
     path = "%s/build/bazel/rules_cc",
 )
 `
-	return []byte(fmt.Sprintf(formatString, context.workspaceDir, context.workspaceDir))
+	return []byte(fmt.Sprintf(formatString, context.paths.workspaceDir, context.paths.workspaceDir))
 }
 
-func (context *bazelContext) mainBzlFileContents() []byte {
-	// TODO(cparsons): Define configuration transitions programmatically based
-	// on available archs.
-	contents := `
-#####################################################
-# This file is generated by soong_build. Do not edit.
-#####################################################
+// requestedArchs returns the sorted distinct arch strings of the given requests, or an error if
+// there is no Android platform for one of them.
+func requestedArchs(requests map[CqueryKey]bool) ([]string, error) {
+	archSet := map[string]bool{}
+	for key := range requests {
+		archSet[getArchString(key)] = true
+	}
+	archs := make([]string, 0, len(archSet))
+	for arch := range archSet {
+		if _, err := bazel.PlatformLabel(bazel.OS_ANDROID, platformArch(arch)); err != nil {
+			return nil, fmt.Errorf("no Android platform for the arch of requests: %s", err)
+		}
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+	return archs, nil
+}
+
+// platformArch returns the arch of the Android platform that requests of the given arch string
+// are built for. Archless requests have no platform of their own, so they are built for the
+// default platform.
+func platformArch(arch string) string {
+	if arch == bazel.ARCH_COMMON {
+		return bazel.ARCH_X86_64
+	}
+	return arch
+}
+
+// mainBzlFileContents returns the contents of the main.bzl file of the buildroot. For each arch
+// of the given requests, it defines a rule whose deps transition to the Android platform of that
+// arch. The config_node macro dispatches to the rule for its arch.
+func (context *bazelContext) mainBzlFileContents(requests map[CqueryKey]bool) ([]byte, error) {
+	archs, err := requestedArchs(requests)
+	if err != nil {
+		return nil, err
+	}
 
-def _config_node_transition_impl(settings, attr):
+	configNodeFormatString := `
+def _config_node_transition_%[1]s_impl(settings, attr):
     return {
-        "//command_line_option:platforms": "@sourceroot//build/bazel/platforms:android_%s" % attr.arch,
+        "//command_line_option:platforms": "%[2]s",
     }
 
-_config_node_transition = transition(
-    implementation = _config_node_transition_impl,
+_config_node_transition_%[1]s = transition(
+    implementation = _config_node_transition_%[1]s_impl,
     inputs = [],
     outputs = [
         "//command_line_option:platforms",
     ],
 )
 
-def _passthrough_rule_impl(ctx):
-    return [DefaultInfo(files = depset(ctx.files.deps))]
-
-config_node = rule(
+_config_node_%[1]s = rule(
     implementation = _passthrough_rule_impl,
     attrs = {
-        "arch" : attr.string(mandatory = True),
-        "deps" : attr.label_list(cfg = _config_node_transition),
+        "deps" : attr.label_list(cfg = _config_node_transition_%[1]s),
         "_allowlist_function_transition": attr.label(default = "@bazel_tools//tools/allowlists/function_transition_allowlist"),
     },
 )
+`
+	configNodesSection := ""
+	configNodeRuleEntries := []string{}
+	for _, arch := range archs {
+		platform, _ := bazel.PlatformLabel(bazel.OS_ANDROID, platformArch(arch))
+		configNodesSection += fmt.Sprintf(configNodeFormatString, arch, sourcerootLabel(platform))
+		configNodeRuleEntries = append(configNodeRuleEntries, fmt.Sprintf("%q: _config_node_%s,", arch, arch))
+	}
+
+	formatString := `
+#####################################################
+# This file is generated by soong_build. Do not edit.
+#####################################################
+
+def _passthrough_rule_impl(ctx):
+    return [DefaultInfo(files = depset(ctx.files.deps))]
+%s
+_config_node_rules = {
+    %s
+}
 
+# Macro depending on targets in the configuration of the Android platform of arch.
+def config_node(name, arch, deps):
+    _config_node_rules[arch](name = name, deps = deps)
 
 # Rule representing the root of the build, to depend on all Bazel targets that
 # are required for the build. Building this target will build the entire Bazel
@@ -384,24 +1358,37 @@ phony_root = rule(
     attrs = {"deps" : attr.label_list()},
 )
 `
-	return []byte(contents)
+	return []byte(fmt.Sprintf(formatString, configNodesSection,
+		strings.Join(configNodeRuleEntries, "\n    "))), nil
 }
 
 // Returns a "canonicalized" corresponding to the given sourcetree-level label.
 // This abstraction is required because a sourcetree label such as //foo/bar:baz
 // must be referenced via the local repository prefix, such as
-// @sourceroot//foo/bar:baz.
-func canonicalizeLabel(label string) string {
-	if strings.HasPrefix(label, "//") {
+// @sourceroot//foo/bar:baz. Labels of external repositories, such as
+// @bazel_tools//foo:bar, are already canonical and are returned unchanged.
+// Relative labels such as :foo have no package to canonicalize them against, and
+// return an error.
+func canonicalizeLabel(label string) (string, error) {
+	if strings.HasPrefix(label, ":") {
+		return "", fmt.Errorf("cannot canonicalize relative label %q, use an absolute label such as //foo%s", label, label)
+	}
+	return sourcerootLabel(label), nil
+}
+
+// sourcerootLabel returns the canonicalized form of a label known to be absolute, such as the
+// labels of the toolchains and platforms of the buildroot.
+func sourcerootLabel(label string) string {
+	if strings.HasPrefix(label, "@") {
+		return label
+	} else if strings.HasPrefix(label, "//") {
 		return "@sourceroot" + label
 	} else {
 		return "@sourceroot//" + label
 	}
 }
 
-func (context *bazelContext) mainBuildFileContents() []byte {
-	// TODO(cparsons): Map label to attribute programmatically; don't use hard-coded
-	// architecture mapping.
+func (context *bazelContext) mainBuildFileContents(requests map[CqueryKey]bool) ([]byte, error) {
 	formatString := `
 # This file is generated by soong_build. Do not edit.
 load(":main.bzl", "config_node", "mixed_build_root", "phony_root")
@@ -426,20 +1413,32 @@ config_node(name = "%s",
 	configNodesSection := ""
 
 	labelsByArch := map[string][]string{}
-	for val, _ := range context.requests {
-		labelString := fmt.Sprintf("\"%s\"", canonicalizeLabel(val.label))
+	for val, _ := range requests {
+		label, err := canonicalizeLabel(val.Label)
+		if err != nil {
+			return nil, err
+		}
+		labelString := fmt.Sprintf("\"%s\"", label)
 		archString := getArchString(val)
 		labelsByArch[archString] = append(labelsByArch[archString], labelString)
 	}
 
+	archStrings := make([]string, 0, len(labelsByArch))
+	for archString := range labelsByArch {
+		archStrings = append(archStrings, archString)
+	}
+	sort.Strings(archStrings)
+
 	configNodeLabels := []string{}
-	for archString, labels := range labelsByArch {
+	for _, archString := range archStrings {
+		labels := labelsByArch[archString]
+		sort.Strings(labels)
 		configNodeLabels = append(configNodeLabels, fmt.Sprintf("\":%s\"", archString))
 		labelsString := strings.Join(labels, ",\n            ")
 		configNodesSection += fmt.Sprintf(configNodeFormatString, archString, archString, labelsString)
 	}
 
-	return []byte(fmt.Sprintf(formatString, configNodesSection, strings.Join(configNodeLabels, ",\n            ")))
+	return []byte(fmt.Sprintf(formatString, configNodesSection, strings.Join(configNodeLabels, ",\n            "))), nil
 }
 
 func indent(original string) string {
@@ -452,16 +1451,20 @@ func indent(original string) string {
 
 // Returns the file contents of the buildroot.cquery file that should be used for the cquery
 // expression in order to obtain information about buildroot and its dependencies.
-// The contents of this file depend on the bazelContext's requests; requests are enumerated
+// The contents of this file depend on the given requests; requests are enumerated
 // and grouped by their request type. The data retrieved for each label depends on its
-// request type.
-func (context *bazelContext) cqueryStarlarkFileContents() []byte {
+// request type. The contents do not depend on the state of a bazelContext, so that the
+// Starlark generated for a request type can be tested without running Bazel.
+func cqueryStarlarkFileContents(requests map[CqueryKey]bool) ([]byte, error) {
 	requestTypeToCqueryIdEntries := map[cquery.RequestType][]string{}
-	for val, _ := range context.requests {
+	for val, _ := range requests {
+		if _, err := canonicalizeLabel(val.Label); err != nil {
+			return nil, err
+		}
 		cqueryId := getCqueryId(val)
 		mapEntryString := fmt.Sprintf("%q : True", cqueryId)
-		requestTypeToCqueryIdEntries[val.requestType] =
-			append(requestTypeToCqueryIdEntries[val.requestType], mapEntryString)
+		requestTypeToCqueryIdEntries[val.RequestType] =
+			append(requestTypeToCqueryIdEntries[val.RequestType], mapEntryString)
 	}
 	labelRegistrationMapSection := ""
 	functionDefSection := ""
@@ -478,11 +1481,13 @@ def %s(target):
 `
 	mainSwitchSectionFormatString := `
   if id_string in %s:
-    return id_string + ">>" + %s(target)
+    return id_string + "\t" + %s(target)
 `
 
+	labelMapNames := []string{}
 	for _, requestType := range cquery.RequestTypes {
 		labelMapName := requestType.Name() + "_Labels"
+		labelMapNames = append(labelMapNames, labelMapName)
 		functionName := requestType.Name() + "_Fn"
 		labelRegistrationMapSection += fmt.Sprintf(mapDeclarationFormatString,
 			labelMapName,
@@ -519,142 +1524,424 @@ def get_arch(target):
     return "UNKNOWN"
   return platform_name[len("android_"):]
 
-def format(target):
-  id_string = str(target.label) + "|" + get_arch(target)
+def is_requested(id_string):
+  for labels in [%s]:
+    if id_string in labels:
+      return True
+  return False
 
+def format_id(id_string, target):
   # Main switch section
   %s
+  return None
+
+def format(target):
+  id_strings = [str(target.label) + "|" + get_arch(target)]
+  if get_arch(target) == "%s":
+    # Archless requests are built for the default platform, so a target requested both without an
+    # arch and for the arch of the default platform is a single configured target, which is
+    # formatted for both requests.
+    id_strings.append(str(target.label) + "|common")
+  results = [format_id(id_string, target) for id_string in id_strings if is_requested(id_string)]
+  if results:
+    return "\n".join(results)
+
   # This target was not requested via cquery, and thus must be a dependency
   # of a requested target.
-  return id_string + ">>NONE"
+  return id_strings[0] + "\tNONE"
 `
 
 	return []byte(fmt.Sprintf(formatString, labelRegistrationMapSection, functionDefSection,
-		mainSwitchSection))
+		strings.Join(labelMapNames, ", "), mainSwitchSection, platformArch(bazel.ARCH_COMMON))), nil
+}
+
+// writeWorkspaceFile writes the WORKSPACE.bazel file of the buildroot to the intermediates
+// directory.
+func (context *bazelContext) writeWorkspaceFile() error {
+	return ioutil.WriteFile(
+		absolutePath(filepath.Join(context.paths.intermediatesDir(), "WORKSPACE.bazel")),
+		context.workspaceFileContents(), 0666)
 }
 
 // Returns a workspace-relative path containing build-related metadata required
 // for interfacing with Bazel. Example: out/soong/bazel.
-func (context *bazelContext) intermediatesDir() string {
-	return filepath.Join(context.buildDir, "bazel")
+func (p *bazelPaths) intermediatesDir() string {
+	return filepath.Join(p.buildDir, "bazel")
 }
 
 // Issues commands to Bazel to receive results for all cquery requests
 // queued in the BazelContext.
 func (context *bazelContext) InvokeBazel() error {
-	context.results = make(map[cqueryKey]string)
+	context.results = make(map[CqueryKey]string)
+
+	results, buildStatements, err := context.invokeBazel(context.requests)
+	if results != nil {
+		// Keep partial results, so that UnresolvedRequests reports only the missing ones.
+		context.results = results
+	}
+	if err != nil {
+		return err
+	}
+	context.buildStatements = buildStatements
+	if context.dumpBuildStatements {
+		if err := context.writeBuildStatements(); err != nil {
+			return err
+		}
+	}
+
+	// Clear requests.
+	context.requests = map[CqueryKey]bool{}
+	return nil
+}
+
+func (context *bazelContext) InvokeBazelForRequests(keys []CqueryKey) (map[CqueryKey]string, []bazel.BuildStatement, error) {
+	requests := make(map[CqueryKey]bool, len(keys))
+	for _, key := range keys {
+		requests[key] = true
+	}
+	return context.invokeBazel(requests)
+}
+
+// invokeBazel issues the cquery, aquery and build commands for the given requests, and returns
+// the cquery results and the build statements of the Bazel build tree. If some requests have no
+// cquery result, the partial results are returned along with an error listing the unresolved
+// requests.
+func (context *bazelContext) invokeBazel(requests map[CqueryKey]bool) (map[CqueryKey]string, []bazel.BuildStatement, error) {
+	results := make(map[CqueryKey]string)
 
 	var cqueryOutput string
 	var cqueryErr string
 	var err error
 
-	intermediatesDirPath := absolutePath(context.intermediatesDir())
+	intermediatesDirPath := absolutePath(context.paths.intermediatesDir())
 	if _, err := os.Stat(intermediatesDirPath); os.IsNotExist(err) {
-		err = os.Mkdir(intermediatesDirPath, 0777)
+		if err := os.Mkdir(intermediatesDirPath, 0777); err != nil {
+			return nil, nil, err
+		}
 	}
 
+	mainBzlContents, err := context.mainBzlFileContents(requests)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	err = ioutil.WriteFile(
-		absolutePath(filepath.Join(context.intermediatesDir(), "main.bzl")),
-		context.mainBzlFileContents(), 0666)
+		absolutePath(filepath.Join(context.paths.intermediatesDir(), "main.bzl")),
+		mainBzlContents, 0666)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	err = ioutil.WriteFile(
-		absolutePath(filepath.Join(context.intermediatesDir(), "BUILD.bazel")),
-		context.mainBuildFileContents(), 0666)
+	mainBuildContents, err := context.mainBuildFileContents(requests)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	cqueryFileRelpath := filepath.Join(context.intermediatesDir(), "buildroot.cquery")
 	err = ioutil.WriteFile(
-		absolutePath(cqueryFileRelpath),
-		context.cqueryStarlarkFileContents(), 0666)
+		absolutePath(filepath.Join(context.paths.intermediatesDir(), "BUILD.bazel")),
+		mainBuildContents, 0666)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	workspaceFileRelpath := filepath.Join(context.intermediatesDir(), "WORKSPACE.bazel")
-	err = ioutil.WriteFile(
-		absolutePath(workspaceFileRelpath),
-		context.workspaceFileContents(), 0666)
-	if err != nil {
-		return err
+	if err := context.writeWorkspaceFile(); err != nil {
+		return nil, nil, err
 	}
 	buildrootLabel := "//:buildroot"
-	cqueryOutput, cqueryErr, err = context.issueBazelCommand(bazel.CqueryBuildRootRunName, "cquery",
-		[]string{fmt.Sprintf("kind(rule, deps(%s))", buildrootLabel)},
-		"--output=starlark",
-		"--starlark:file="+cqueryFileRelpath)
-	err = ioutil.WriteFile(
-		absolutePath(filepath.Join(context.intermediatesDir(), "cquery.out")),
-		[]byte(cqueryOutput), 0666)
+	platformFlags, err := targetPlatformFlags(requests)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+	var cqueryResults map[string]string
+	if context.splitCquery {
+		cqueryResults, cqueryOutput, cqueryErr, err = context.splitCqueryBuildRoot(requests, platformFlags)
+	} else {
+		cqueryOutput, cqueryErr, err = context.cqueryBuildRoot(context.paths, bazel.CqueryBuildRootRunName,
+			"buildroot.cquery", requests, platformFlags)
+		cqueryResults = map[string]string{}
+		parseCqueryOutput(cqueryOutput, nil, cqueryResults)
 	}
-
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("cquery of %s failed: %w", buildrootLabel, err)
 	}
-
-	cqueryResults := map[string]string{}
-	for _, outputLine := range strings.Split(cqueryOutput, "\n") {
-		if strings.Contains(outputLine, ">>") {
-			splitLine := strings.SplitN(outputLine, ">>", 2)
-			cqueryResults[splitLine[0]] = splitLine[1]
-		}
+	err = ioutil.WriteFile(
+		absolutePath(filepath.Join(context.paths.intermediatesDir(), "cquery.out")),
+		[]byte(cqueryOutput), 0666)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	for val, _ := range context.requests {
+	for val, _ := range requests {
 		if cqueryResult, ok := cqueryResults[getCqueryId(val)]; ok {
-			context.results[val] = string(cqueryResult)
-		} else {
-			return fmt.Errorf("missing result for bazel target %s. query output: [%s], cquery err: [%s]",
-				getCqueryId(val), cqueryOutput, cqueryErr)
+			results[val] = string(cqueryResult)
+		}
+	}
+	if unresolved := unresolvedRequests(requests, results); len(unresolved) > 0 {
+		var ids []string
+		for _, key := range unresolved {
+			ids = append(ids, getCqueryId(key))
 		}
+		return results, nil, fmt.Errorf("missing results for bazel targets %s. query output: [%s], cquery err: [%s]",
+			strings.Join(ids, ", "), cqueryOutput, cqueryErr)
 	}
 
-	// Issue an aquery command to retrieve action information about the bazel build tree.
+	// Issue an aquery command to retrieve action information about the bazel build tree. The
+	// action graph may be very large, so it is written directly to a file and parsed from there
+	// instead of being buffered in memory.
 	//
 	// TODO(cparsons): Use --target_pattern_file to avoid command line limits.
-	var aqueryOutput string
-	aqueryOutput, _, err = context.issueBazelCommand(bazel.AqueryBuildRootRunName, "aquery",
-		[]string{fmt.Sprintf("deps(%s)", buildrootLabel),
-			// Use jsonproto instead of proto; actual proto parsing would require a dependency on Bazel's
-			// proto sources, which would add a number of unnecessary dependencies.
-			"--output=jsonproto"})
+	aqueryFilePath := absolutePath(filepath.Join(context.paths.intermediatesDir(), "aquery.out"))
+	_, err = context.issueBazelCommandToFile(context.paths, bazel.AqueryBuildRootRunName,
+		bazelCommand{"aquery", fmt.Sprintf("deps(%s)", buildrootLabel)},
+		aqueryFilePath,
+		// Use jsonproto instead of proto; actual proto parsing would require a dependency on Bazel's
+		// proto sources, which would add a number of unnecessary dependencies.
+		context.flagsForRun(bazel.AqueryBuildRootRunName, append(platformFlags, "--output=jsonproto")...)...)
 
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("aquery of %s failed: %w", buildrootLabel, err)
 	}
 
-	context.buildStatements, err = bazel.AqueryBuildStatements([]byte(aqueryOutput))
+	buildStatements, err := aqueryBuildStatementsFromFile(aqueryFilePath)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Issue a build command of the phony root to generate symlink forests for dependencies of the
 	// Bazel build. This is necessary because aquery invocations do not generate this symlink forest,
 	// but some of symlinks may be required to resolve source dependencies of the build.
-	_, _, err = context.issueBazelCommand(bazel.BazelBuildPhonyRootRunName, "build",
-		[]string{"//:phonyroot"})
+	_, _, err = context.issueBazelCommand(context.paths, bazel.BazelBuildPhonyRootRunName,
+		bazelCommand{"build", "//:phonyroot"},
+		context.flagsForRun(bazel.BazelBuildPhonyRootRunName, platformFlags...)...)
 
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("build of //:phonyroot failed: %w", err)
 	}
 
-	// Clear requests.
-	context.requests = map[cqueryKey]bool{}
-	return nil
+	return results, buildStatements, nil
+}
+
+// cqueryBuildRoot writes the Starlark file formatting the given requests to the intermediates
+// directory under the given file name, and issues a cquery of the dependencies of the buildroot
+// with it using the given paths. It returns the stdout and stderr of the cquery.
+func (context *bazelContext) cqueryBuildRoot(paths *bazelPaths, runName bazel.RunName, starlarkFileName string,
+	requests map[CqueryKey]bool, platformFlags []string) (string, string, error) {
+	cqueryFileRelpath := filepath.Join(context.paths.intermediatesDir(), starlarkFileName)
+	cqueryFileContents, err := cqueryStarlarkFileContents(requests)
+	if err != nil {
+		return "", "", err
+	}
+	err = ioutil.WriteFile(
+		absolutePath(cqueryFileRelpath),
+		cqueryFileContents, 0666)
+	if err != nil {
+		return "", "", err
+	}
+	return context.issueBazelCommand(paths, runName,
+		bazelCommand{"cquery", "kind(rule, deps(//:buildroot))"},
+		context.flagsForRun(bazel.CqueryBuildRootRunName, append(platformFlags,
+			"--output=starlark",
+			"--starlark:file="+cqueryFileRelpath)...)...)
+}
+
+// splitCqueryBuildRoot issues a cquery for each request type of the given requests in parallel,
+// each with a Starlark file formatting only the requests of that type, and merges their results.
+// The first cquery uses the output base of the context, so that later commands reuse its analysis
+// cache, and the others each use their own output base next to it, as the commands of a single
+// Bazel server are serialized. It returns the results keyed by cquery id, and the concatenated
+// stdout and stderr of the cqueries.
+func (context *bazelContext) splitCqueryBuildRoot(requests map[CqueryKey]bool,
+	platformFlags []string) (map[string]string, string, string, error) {
+	requestsByType := map[cquery.RequestType]map[CqueryKey]bool{}
+	for key := range requests {
+		if requestsByType[key.RequestType] == nil {
+			requestsByType[key.RequestType] = map[CqueryKey]bool{}
+		}
+		requestsByType[key.RequestType][key] = true
+	}
+
+	cqueryResults := map[string]string{}
+	// Outputs are indexed like cquery.RequestTypes, so that they are concatenated in a stable order.
+	stdouts := make([]string, len(cquery.RequestTypes))
+	stderrs := make([]string, len(cquery.RequestTypes))
+	var firstErr error
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	firstCquery := true
+	for i, requestType := range cquery.RequestTypes {
+		typeRequests, ok := requestsByType[requestType]
+		if !ok {
+			continue
+		}
+		paths := context.paths
+		if !firstCquery {
+			typePaths := *context.paths
+			typePaths.outputBase = context.paths.outputBase + "-" + requestType.Name()
+			paths = &typePaths
+		}
+		firstCquery = false
+		wg.Add(1)
+		go func(i int, requestType cquery.RequestType, typeRequests map[CqueryKey]bool, paths *bazelPaths) {
+			defer wg.Done()
+			// Each cquery has its own run name so that concurrent runs don't write to the same profile.
+			runName := bazel.RunName(bazel.CqueryBuildRootRunName.String() + "-" + requestType.Name())
+			stdout, stderr, err := context.cqueryBuildRoot(paths, runName,
+				"buildroot."+requestType.Name()+".cquery", typeRequests, platformFlags)
+			stdouts[i], stderrs[i] = stdout, stderr
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			parseCqueryOutput(stdout, typeRequests, cqueryResults)
+		}(i, requestType, typeRequests, paths)
+	}
+	wg.Wait()
+
+	return cqueryResults, strings.Join(stdouts, "\n"), strings.Join(stderrs, "\n"), firstErr
+}
+
+// cqueryResultDelimiter separates the cquery id of a target from its result in each line of the
+// output of the Starlark file generated by cqueryStarlarkFileContents. Labels can't contain
+// whitespace, so unlike ">", which is valid in target names, it can't be part of a cquery id.
+const cqueryResultDelimiter = "\t"
+
+// parseCqueryOutput adds the results in the output of a cquery issued with the Starlark file
+// generated by cqueryStarlarkFileContents to results, keyed by cquery id. If requests is not nil,
+// only the results of the given requests are added; every cquery of the buildroot formats all of
+// its dependencies, so the results of requests of other cqueries would otherwise be overwritten.
+func parseCqueryOutput(output string, requests map[CqueryKey]bool, results map[string]string) {
+	var requestedIds map[string]bool
+	if requests != nil {
+		requestedIds = make(map[string]bool, len(requests))
+		for key := range requests {
+			requestedIds[getCqueryId(key)] = true
+		}
+	}
+	for _, outputLine := range strings.Split(output, "\n") {
+		if strings.Contains(outputLine, cqueryResultDelimiter) {
+			splitLine := strings.SplitN(outputLine, cqueryResultDelimiter, 2)
+			if requestedIds == nil || requestedIds[splitLine[0]] {
+				results[splitLine[0]] = splitLine[1]
+			}
+		}
+	}
+}
+
+// Returns the build statements described by the aquery jsonproto output in the file at the given
+// path.
+func aqueryBuildStatementsFromFile(path string) ([]bazel.BuildStatement, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return bazel.AqueryBuildStatementsFromReader(f)
 }
 
 func (context *bazelContext) BuildStatementsToRegister() []bazel.BuildStatement {
 	return context.buildStatements
 }
 
+// writeBuildStatements writes the build statements to register as JSON to build_statements.json in
+// the intermediates directory, next to the raw cquery and aquery outputs.
+func (context *bazelContext) writeBuildStatements() error {
+	contents, err := json.MarshalIndent(context.buildStatements, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(
+		absolutePath(filepath.Join(context.paths.intermediatesDir(), "build_statements.json")),
+		contents, 0666)
+}
+
+// UnresolvedRequests returns the queued requests without a result, e.g. so that the labels and
+// archs of the bazel targets that failed to build can be reported.
+func (context *bazelContext) UnresolvedRequests() []CqueryKey {
+	context.requestMutex.Lock()
+	defer context.requestMutex.Unlock()
+	return unresolvedRequests(context.requests, context.results)
+}
+
+// missingTargetErrors are the errors reported by Bazel for labels of targets which don't exist.
+var missingTargetErrors = []string{
+	"no such target",
+	"no such package",
+}
+
+func (context *bazelContext) TargetExists(label string) (bool, error) {
+	canonicalLabel, err := canonicalizeLabel(label)
+	if err != nil {
+		return false, err
+	}
+	intermediatesDirPath := absolutePath(context.paths.intermediatesDir())
+	if err := os.MkdirAll(intermediatesDirPath, 0777); err != nil {
+		return false, err
+	}
+	// The query is issued with the package path of the buildroot, whose workspace declares the
+	// @sourceroot repository of canonicalized labels.
+	if err := context.writeWorkspaceFile(); err != nil {
+		return false, err
+	}
+	stdout, _, err := context.issueBazelCommand(context.paths, bazel.QueryTargetExistsRunName,
+		bazelCommand{"query", canonicalLabel},
+		context.flagsForRun(bazel.QueryTargetExistsRunName, "--output=label")...)
+	if err != nil {
+		var commandErr *BazelCommandError
+		if errors.As(err, &commandErr) {
+			for _, pattern := range missingTargetErrors {
+				if strings.Contains(commandErr.Stderr, pattern) {
+					return false, nil
+				}
+			}
+		}
+		return false, fmt.Errorf("query of %s failed: %w", label, err)
+	}
+	return strings.TrimSpace(stdout) != "", nil
+}
+
+// unresolvedRequests returns the requests which have no result, sorted by cquery id.
+func unresolvedRequests(requests map[CqueryKey]bool, results map[CqueryKey]string) []CqueryKey {
+	var ret []CqueryKey
+	for key := range requests {
+		if _, ok := results[key]; !ok {
+			ret = append(ret, key)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return getCqueryId(ret[i]) < getCqueryId(ret[j])
+	})
+	return ret
+}
+
+// QueuedRequests returns a copy of the requests queued for the next InvokeBazel, sorted by cquery
+// id and then by request type name.
+func (context *bazelContext) QueuedRequests() []CqueryKey {
+	context.requestMutex.Lock()
+	defer context.requestMutex.Unlock()
+	ret := make([]CqueryKey, 0, len(context.requests))
+	for key := range context.requests {
+		ret = append(ret, key)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if idI, idJ := getCqueryId(ret[i]), getCqueryId(ret[j]); idI != idJ {
+			return idI < idJ
+		}
+		return ret[i].RequestType.Name() < ret[j].RequestType.Name()
+	})
+	return ret
+}
+
+func (context *bazelContext) AllResults() map[string]string {
+	ret := make(map[string]string, len(context.results))
+	for key, result := range context.results {
+		ret[getCqueryId(key)] = result
+	}
+	return ret
+}
+
 func (context *bazelContext) OutputBase() string {
-	return context.outputBase
+	return context.paths.outputBase
 }
 
 // Singleton used for registering BUILD file ninja dependencies (needed
@@ -685,46 +1972,196 @@ func (c *bazelSingleton) GenerateBuildActions(ctx SingletonContext) {
 		ctx.AddNinjaFileDeps(file)
 	}
 
-	// Register bazel-owned build statements (obtained from the aquery invocation).
-	for index, buildStatement := range ctx.Config().BazelContext.BuildStatementsToRegister() {
-		if len(buildStatement.Command) < 1 {
-			panic(fmt.Sprintf("unhandled build statement: %s", buildStatement))
+	// Register bazel-owned build statements (obtained from the aquery invocation). Statements are
+	// partitioned by the configuration of their outputs, so that the same action built for
+	// multiple architectures gets a distinct rule for each.
+	buildStatements, err := dedupBuildStatements(ctx.Config().BazelContext.BuildStatementsToRegister())
+	if err != nil {
+		ctx.Errorf(err.Error())
+		return
+	}
+	configs, buildStatementsByConfig, err := partitionBuildStatementsByConfig(buildStatements)
+	if err != nil {
+		ctx.Errorf(err.Error())
+		return
+	}
+	for _, config := range configs {
+		for index, buildStatement := range buildStatementsByConfig[config] {
+			command, ok := bazelBuildStatementCommand(buildStatement)
+			if !ok {
+				registerUnsupportedBazelBuildStatement(ctx, buildStatement)
+				continue
+			}
+			registerBazelBuildStatement(ctx, buildStatement, command, bazelBuildStatementRuleName(config, index))
+		}
+	}
+}
+
+// bazelBuildStatementCommand returns the command, relative to the execroot, running the given
+// Bazel build statement. aquery emits no command for some actions that Bazel runs internally; a
+// command is synthesized for symlink actions, and false is returned for other such actions, such
+// as template expansions, which cannot be run by Ninja.
+func bazelBuildStatementCommand(buildStatement bazel.BuildStatement) (string, bool) {
+	if buildStatement.Command != "" {
+		return buildStatement.Command, true
+	}
+	switch buildStatement.Mnemonic {
+	case "Symlink", "SolibSymlink":
+		if len(buildStatement.InputPaths) != 1 || len(buildStatement.OutputPaths) != 1 {
+			return "", false
+		}
+		input, output := buildStatement.InputPaths[0], buildStatement.OutputPaths[0]
+		target, err := filepath.Rel(filepath.Dir(output), input)
+		if err != nil {
+			return "", false
 		}
-		rule := NewRuleBuilder(pctx, ctx)
-		cmd := rule.Command()
-		cmd.Text(fmt.Sprintf("cd %s/execroot/__main__ && %s",
-			ctx.Config().BazelContext.OutputBase(), buildStatement.Command))
+		return fmt.Sprintf("mkdir -p %s && ln -sf %s %s", filepath.Dir(output), target, output), true
+	}
+	return "", false
+}
+
+// registerBazelBuildStatement registers a rule running the given command of a Bazel build
+// statement.
+func registerBazelBuildStatement(ctx SingletonContext, buildStatement bazel.BuildStatement, command, name string) {
+	rule := NewRuleBuilder(pctx, ctx)
+	cmd := rule.Command()
+	cmd.Text(fmt.Sprintf("cd %s/execroot/__main__ && %s",
+		ctx.Config().BazelContext.OutputBase(), command))
+
+	for _, outputPath := range buildStatement.OutputPaths {
+		cmd.ImplicitOutput(PathForBazelOut(ctx, outputPath))
+	}
+	for _, inputPath := range buildStatement.InputPaths {
+		cmd.Implicit(PathForBazelOut(ctx, inputPath))
+	}
+
+	if depfile := buildStatement.Depfile; depfile != nil {
+		cmd.ImplicitDepFile(PathForBazelOut(ctx, *depfile))
+	}
+
+	// This is required to silence warnings pertaining to unexpected timestamps. Particularly,
+	// some Bazel builtins (such as files in the bazel_tools directory) have far-future
+	// timestamps. Without restat, Ninja would emit warnings that the input files of a
+	// build statement have later timestamps than the outputs.
+	rule.Restat()
+
+	rule.Build(name, buildStatement.Mnemonic)
+}
+
+// registerUnsupportedBazelBuildStatement registers a rule for the outputs of a Bazel build
+// statement which cannot be run by Ninja, failing with an error naming the action if any of them
+// is built.
+func registerUnsupportedBazelBuildStatement(ctx SingletonContext, buildStatement bazel.BuildStatement) {
+	if len(buildStatement.OutputPaths) == 0 {
+		return
+	}
+	var outputs WritablePaths
+	for _, outputPath := range buildStatement.OutputPaths {
+		outputs = append(outputs, PathForBazelOut(ctx, outputPath))
+	}
+	ctx.Build(pctx, BuildParams{
+		Rule:        ErrorRule,
+		Outputs:     outputs,
+		Description: buildStatement.Mnemonic,
+		Args: map[string]string{
+			"error": fmt.Sprintf("Bazel %s action without a command is not supported", buildStatement.Mnemonic),
+		},
+	})
+}
 
+// bazelOutConfig returns the configuration directory of a path in bazel-out, e.g. "k8-fastbuild"
+// for "bazel-out/k8-fastbuild/bin/foo.o", or "" if the path is not in a configuration directory.
+func bazelOutConfig(path string) string {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 3 || parts[0] != "bazel-out" {
+		return ""
+	}
+	return parts[1]
+}
+
+// dedupBuildStatements returns the given build statements without duplicates of an earlier
+// statement, such as those resulting from flattening the aquery output. Ninja allows only a single
+// rule per output, so an error is returned if distinct statements produce the same output.
+func dedupBuildStatements(buildStatements []bazel.BuildStatement) ([]bazel.BuildStatement, error) {
+	var ret []bazel.BuildStatement
+	emittedOutputs := make(map[string]int)
+	for _, buildStatement := range buildStatements {
+		duplicate := false
 		for _, outputPath := range buildStatement.OutputPaths {
-			cmd.ImplicitOutput(PathForBazelOut(ctx, outputPath))
+			if index, ok := emittedOutputs[outputPath]; ok {
+				if !reflect.DeepEqual(ret[index], buildStatement) {
+					return nil, fmt.Errorf("Bazel %s and %s actions both produce output %q",
+						ret[index].Mnemonic, buildStatement.Mnemonic, outputPath)
+				}
+				duplicate = true
+			}
+		}
+		if duplicate {
+			continue
 		}
-		for _, inputPath := range buildStatement.InputPaths {
-			cmd.Implicit(PathForBazelOut(ctx, inputPath))
+		for _, outputPath := range buildStatement.OutputPaths {
+			emittedOutputs[outputPath] = len(ret)
 		}
+		ret = append(ret, buildStatement)
+	}
+	return ret, nil
+}
 
-		if depfile := buildStatement.Depfile; depfile != nil {
-			cmd.ImplicitDepFile(PathForBazelOut(ctx, *depfile))
+// partitionBuildStatementsByConfig groups build statements by the configuration of their
+// outputs, as determined by the bazel-out configuration directory of their first output. Returns
+// the configurations in sorted order along with the statements in each, in their original order.
+// Rules of all configurations are registered in the same Ninja file, so an error is returned if
+// statements produce the same output, e.g. when a statement of one configuration also writes
+// outputs into another.
+func partitionBuildStatementsByConfig(buildStatements []bazel.BuildStatement) ([]string, map[string][]bazel.BuildStatement, error) {
+	buildStatementsByConfig := make(map[string][]bazel.BuildStatement)
+	outputConfigs := make(map[string]string)
+	for _, buildStatement := range buildStatements {
+		config := ""
+		if len(buildStatement.OutputPaths) > 0 {
+			config = bazelOutConfig(buildStatement.OutputPaths[0])
+		}
+		for _, outputPath := range buildStatement.OutputPaths {
+			if otherConfig, ok := outputConfigs[outputPath]; ok {
+				return nil, nil, fmt.Errorf("Bazel build statements of configurations %q and %q both produce output %q",
+					otherConfig, config, outputPath)
+			}
+			outputConfigs[outputPath] = config
 		}
+		buildStatementsByConfig[config] = append(buildStatementsByConfig[config], buildStatement)
+	}
 
-		// This is required to silence warnings pertaining to unexpected timestamps. Particularly,
-		// some Bazel builtins (such as files in the bazel_tools directory) have far-future
-		// timestamps. Without restat, Ninja would emit warnings that the input files of a
-		// build statement have later timestamps than the outputs.
-		rule.Restat()
+	configs := make([]string, 0, len(buildStatementsByConfig))
+	for config := range buildStatementsByConfig {
+		configs = append(configs, config)
+	}
+	sort.Strings(configs)
+	return configs, buildStatementsByConfig, nil
+}
 
-		rule.Build(fmt.Sprintf("bazel %d", index), buildStatement.Mnemonic)
+// bazelBuildStatementRuleName returns the name of the rule for the build statement at the given
+// index within its output configuration.
+func bazelBuildStatementRuleName(config string, index int) string {
+	if config == "" {
+		return fmt.Sprintf("bazel %d", index)
 	}
+	return fmt.Sprintf("bazel %s %d", config, index)
 }
 
-func getCqueryId(key cqueryKey) string {
-	return canonicalizeLabel(key.label) + "|" + getArchString(key)
+// getCqueryId returns the id of the result of the given request in the cquery output. Requests of
+// relative labels are rejected by mainBuildFileContents before any cquery is issued, so their ids
+// only order and describe the requests.
+func getCqueryId(key CqueryKey) string {
+	return sourcerootLabel(key.Label) + "|" + getArchString(key)
 }
 
-func getArchString(key cqueryKey) string {
-	arch := key.archType.Name
+// getArchString returns the arch string of the request, which is "common" for requests without an
+// arch, e.g. for host or arch-independent modules.
+func getArchString(key CqueryKey) string {
+	arch := key.ArchType.Name
 	if len(arch) > 0 {
 		return arch
 	} else {
-		return "x86_64"
+		return bazel.ARCH_COMMON
 	}
 }
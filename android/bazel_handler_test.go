@@ -0,0 +1,1605 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package android
+
+import (
+	"android/soong/bazel"
+	"android/soong/bazel/cquery"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvokeBazelReadsAqueryOutputFromFile(t *testing.T) {
+	bazelContext, buildDir := testBazelContext(t, map[bazelCommand]string{
+		bazelCommand{command: "aquery", expression: "deps(//:buildroot)"}: `
+{
+  "artifacts": [{ "id": 1, "pathFragmentId": 1 }, { "id": 2, "pathFragmentId": 2 }],
+  "actions": [{
+    "targetId": 1,
+    "actionKey": "x",
+    "mnemonic": "x",
+    "arguments": ["touch", "foo"],
+    "inputDepSetIds": [1],
+    "outputIds": [2],
+    "primaryOutputId": 2
+  }],
+  "depSetOfFiles": [{ "id": 1, "directArtifactIds": [1] }],
+  "pathFragments": [{ "id": 1, "label": "one" }, { "id": 2, "label": "two" }]
+}`,
+	})
+
+	err := bazelContext.InvokeBazel()
+	if err != nil {
+		t.Fatalf("Did not expect error invoking Bazel, but got %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(buildDir, "bazel", "aquery.out")); err != nil {
+		t.Errorf("Expected aquery output to be written to a file, but got %s", err)
+	}
+
+	buildStatements := bazelContext.BuildStatementsToRegister()
+	if len(buildStatements) != 1 {
+		t.Fatalf("Expected 1 build statement, got %d: %v", len(buildStatements), buildStatements)
+	}
+	if g, w := buildStatements[0].OutputPaths, []string{"two"}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected output paths %q, got %q", w, g)
+	}
+}
+
+func TestInvokeBazelDumpsBuildStatements(t *testing.T) {
+	aqueryOutput := `
+{
+  "artifacts": [{ "id": 1, "pathFragmentId": 1 }, { "id": 2, "pathFragmentId": 2 }],
+  "actions": [{
+    "targetId": 1,
+    "actionKey": "x",
+    "mnemonic": "Genrule",
+    "arguments": ["touch", "foo"],
+    "environmentVariables": [{ "key": "PATH", "value": "/bin" }],
+    "inputDepSetIds": [1],
+    "outputIds": [2],
+    "primaryOutputId": 2
+  }],
+  "depSetOfFiles": [{ "id": 1, "directArtifactIds": [1] }],
+  "pathFragments": [{ "id": 1, "label": "one" }, { "id": 2, "label": "two" }]
+}`
+	for _, dump := range []bool{false, true} {
+		bazelContext, buildDir := testBazelContext(t, map[bazelCommand]string{
+			bazelCommand{command: "aquery", expression: "deps(//:buildroot)"}: aqueryOutput,
+		})
+		bazelContext.dumpBuildStatements = dump
+		if err := bazelContext.InvokeBazel(); err != nil {
+			t.Fatalf("Did not expect error invoking Bazel, but got %s", err)
+		}
+
+		dumpPath := filepath.Join(buildDir, "bazel", "build_statements.json")
+		contents, err := ioutil.ReadFile(dumpPath)
+		if !dump {
+			if err == nil {
+				t.Errorf("Expected no build statements dump without BAZEL_DUMP_BUILD_STATEMENTS")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Expected build statements to be written to %s, but got %s", dumpPath, err)
+		}
+		var dumped []bazel.BuildStatement
+		if err := json.Unmarshal(contents, &dumped); err != nil {
+			t.Fatalf("Expected build statements dump to be valid JSON, got %s: %s", err, contents)
+		}
+		if g, w := dumped, bazelContext.BuildStatementsToRegister(); len(w) != 1 || !reflect.DeepEqual(w, g) {
+			t.Errorf("Expected dumped build statements %v, got %v", w, g)
+		}
+	}
+}
+
+func TestInvokeBazelPassesRunSpecificFlags(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{})
+
+	err := bazelContext.InvokeBazel()
+	if err != nil {
+		t.Fatalf("Did not expect error invoking Bazel, but got %s", err)
+	}
+
+	runner := bazelContext.bazelRunner.(*mockBazelRunner)
+	phonyRootFlags := runner.extraFlags[bazelCommand{command: "build", expression: "//:phonyroot"}]
+	if w := []string{"--spawn_strategy=local", "--nobuild_runfile_links"}; !reflect.DeepEqual(w, phonyRootFlags) {
+		t.Errorf("Expected phony root build flags %q, got %q", w, phonyRootFlags)
+	}
+	aqueryFlags := runner.extraFlags[bazelCommand{command: "aquery", expression: "deps(//:buildroot)"}]
+	if w := []string{"--output=jsonproto"}; !reflect.DeepEqual(w, aqueryFlags) {
+		t.Errorf("Expected aquery flags %q, got %q", w, aqueryFlags)
+	}
+}
+
+func TestInvokeBazelTargetsPlatformOfRequests(t *testing.T) {
+	bazelContext, buildDir := testBazelContext(t, map[bazelCommand]string{
+		bazelCommand{command: "cquery", expression: "kind(rule, deps(//:buildroot))"}: "@sourceroot//foo:bar|arm64\tbazel-out/android_arm64-fastbuild/bin/foo/bar.out",
+	})
+	bazelContext.requests[CqueryKey{"//foo:bar", cquery.GetOutputFiles, Arm64}] = true
+
+	err := bazelContext.InvokeBazel()
+	if err != nil {
+		t.Fatalf("Did not expect error invoking Bazel, but got %s", err)
+	}
+
+	buildFile, err := ioutil.ReadFile(filepath.Join(buildDir, "bazel", "BUILD.bazel"))
+	if err != nil {
+		t.Fatalf("Expected the main BUILD file to be written, but got %s", err)
+	}
+	configNode := `config_node(name = "arm64",
+    arch = "arm64",
+    deps = ["@sourceroot//foo:bar"],
+)`
+	if !strings.Contains(string(buildFile), configNode) {
+		t.Errorf("Expected main BUILD file to contain %q, got:\n%s", configNode, buildFile)
+	}
+	mainBzl, err := ioutil.ReadFile(filepath.Join(buildDir, "bazel", "main.bzl"))
+	if err != nil {
+		t.Fatalf("Expected the main.bzl file to be written, but got %s", err)
+	}
+	if transition := `"//command_line_option:platforms": "@sourceroot//build/bazel/platforms:android_arm64"`; !strings.Contains(string(mainBzl), transition) {
+		t.Errorf("Expected the config_node transition to set the platform %q", transition)
+	}
+
+	runner := bazelContext.bazelRunner.(*mockBazelRunner)
+	platformFlag := "--platforms=@sourceroot//build/bazel/platforms:android_arm64"
+	for _, command := range []bazelCommand{
+		{command: "cquery", expression: "kind(rule, deps(//:buildroot))"},
+		{command: "aquery", expression: "deps(//:buildroot)"},
+		{command: "build", expression: "//:phonyroot"},
+	} {
+		if flags := runner.extraFlags[command]; !InList(platformFlag, flags) {
+			t.Errorf("Expected %s flags to contain %q, got %q", command.command, platformFlag, flags)
+		}
+	}
+
+	if files, ok := bazelContext.GetOutputFiles("//foo:bar", Arm64); !ok || !reflect.DeepEqual(files, []string{"bazel-out/android_arm64-fastbuild/bin/foo/bar.out"}) {
+		t.Errorf("Expected arm64 output paths, got %q (ok: %t)", files, ok)
+	}
+}
+
+func TestTargetPlatformFlags(t *testing.T) {
+	testCases := []struct {
+		description string
+		requests    map[CqueryKey]bool
+		expected    []string
+	}{
+		{
+			description: "no requests",
+		},
+		{
+			description: "x86_64 requests keep the default platform",
+			requests: map[CqueryKey]bool{
+				CqueryKey{"//foo:bar", cquery.GetOutputFiles, X86_64}: true,
+			},
+		},
+		{
+			description: "arm64 requests",
+			requests: map[CqueryKey]bool{
+				CqueryKey{"//foo:bar", cquery.GetOutputFiles, Arm64}: true,
+				CqueryKey{"//foo:baz", cquery.GetOutputFiles, Arm64}: true,
+			},
+			expected: []string{"--platforms=@sourceroot//build/bazel/platforms:android_arm64"},
+		},
+		{
+			description: "requests for several archs rely on transitions",
+			requests: map[CqueryKey]bool{
+				CqueryKey{"//foo:bar", cquery.GetOutputFiles, Arm64}: true,
+				CqueryKey{"//foo:bar", cquery.GetOutputFiles, Arm}:   true,
+			},
+		},
+	}
+	for _, tc := range testCases {
+		actual, err := targetPlatformFlags(tc.requests)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tc.description, err)
+		}
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("%s: expected flags %q, got %q", tc.description, tc.expected, actual)
+		}
+	}
+}
+
+func TestBazelCmdFlagsForHostOs(t *testing.T) {
+	testCases := []struct {
+		hostOs                 OsType
+		expectedHostPlatform   string
+		expectedExtraToolchain string
+	}{
+		{
+			hostOs:                 Linux,
+			expectedHostPlatform:   "--host_platform=@sourceroot//build/bazel/platforms:linux_x86_64",
+			expectedExtraToolchain: "--extra_toolchains=@sourceroot//prebuilts/clang/host/linux-x86:all",
+		},
+		{
+			hostOs:                 Darwin,
+			expectedHostPlatform:   "--host_platform=@sourceroot//build/bazel/platforms:darwin_x86_64",
+			expectedExtraToolchain: "--extra_toolchains=@sourceroot//prebuilts/clang/host/darwin-x86:all",
+		},
+	}
+	for _, tc := range testCases {
+		runner := &builtinBazelRunner{hostOs: tc.hostOs, hostArch: X86_64}
+		flags, err := runner.bazelCmdFlags(&bazelPaths{buildDir: "out"}, bazel.CqueryBuildRootRunName,
+			bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range []string{tc.expectedHostPlatform, tc.expectedExtraToolchain} {
+			if !InList(w, flags) {
+				t.Errorf("%s host: expected flags to contain %q, got %q", tc.hostOs, w, flags)
+			}
+		}
+	}
+}
+
+func TestBazelCmdFlagsForUnknownHostPlatform(t *testing.T) {
+	runner := &builtinBazelRunner{hostOs: OsType{Name: "plan9"}, hostArch: X86_64}
+	_, err := runner.bazelCmdFlags(&bazelPaths{buildDir: "out"}, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err == nil || !strings.Contains(err.Error(), "Unknown os: plan9") {
+		t.Errorf("Expected an unknown os error for the host platform, got %v", err)
+	}
+}
+
+func TestBazelCmdFlagsWithRcFile(t *testing.T) {
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64}
+	paths := &bazelPaths{buildDir: "out", outputBase: "outputbase", rcFile: "site.bazelrc"}
+	flags, err := runner.bazelCmdFlags(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w, g := []string{"--output_base=outputbase", "--bazelrc=site.bazelrc", "cquery"}, flags[:3]; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected the bazelrc to be passed before the command as %q, got %q", w, g)
+	}
+	platforms := IndexList("--platforms=@sourceroot//build/bazel/platforms:android_x86_64", flags)
+	if platforms < 3 {
+		t.Errorf("Expected the default platform override after the command, got %q", flags)
+	}
+
+	flags, err = runner.bazelCmdFlags(&bazelPaths{buildDir: "out"}, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "--bazelrc") {
+			t.Errorf("Expected no --bazelrc flag without BAZEL_RC_FILE, got %q", flags)
+		}
+	}
+}
+
+func TestBazelCmdFlagsWithCacheDirs(t *testing.T) {
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64}
+	paths := &bazelPaths{buildDir: "out", outputBase: "outputbase",
+		outputUserRoot: "/cache/bazel_root", diskCache: "/cache/bazel_disk_cache"}
+	flags, err := runner.bazelCmdFlags(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if w, g := []string{"--output_base=outputbase", "--output_user_root=/cache/bazel_root", "cquery"}, flags[:3]; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected the output user root to be passed before the command as %q, got %q", w, g)
+	}
+	if i := IndexList("--disk_cache=/cache/bazel_disk_cache", flags); i < 3 {
+		t.Errorf("Expected the disk cache to be passed after the command, got %q", flags)
+	}
+
+	flags, err = runner.bazelCmdFlags(&bazelPaths{buildDir: "out"}, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "--output_user_root") || strings.HasPrefix(flag, "--disk_cache") {
+			t.Errorf("Expected no cache flags without BAZEL_OUTPUT_USER_ROOT or BAZEL_DISK_CACHE, got %q", flags)
+		}
+	}
+}
+
+func TestBazelCmdFlagsWithHostJvmArgs(t *testing.T) {
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64, hostJvmArgs: []string{"-Xmx16g", "-XX:+UseParallelGC"}}
+	paths := &bazelPaths{buildDir: "out", outputBase: "outputbase", rcFile: "site.bazelrc"}
+	flags, err := runner.bazelCmdFlags(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := []string{
+		"--output_base=outputbase",
+		"--bazelrc=site.bazelrc",
+		"--host_jvm_args=-Xmx16g",
+		"--host_jvm_args=-XX:+UseParallelGC",
+		"cquery",
+	}
+	if g := flags[:len(w)]; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected the host JVM args to be passed before the command as %q, got %q", w, g)
+	}
+
+	flags, err = runner.bazelCmdFlags(&bazelPaths{buildDir: "out"}, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := IndexList("cquery", flags); i != 3 {
+		t.Errorf("Expected the command to follow the host JVM args, got %q", flags)
+	}
+
+	runner.hostJvmArgs = nil
+	flags, err = runner.bazelCmdFlags(&bazelPaths{buildDir: "out"}, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, flag := range flags {
+		if strings.HasPrefix(flag, "--host_jvm_args") {
+			t.Errorf("Expected no --host_jvm_args flag without BAZEL_HOST_JVM_ARGS, got %q", flags)
+		}
+	}
+}
+
+func TestInvokeBazelReturnsIntermediatesDirError(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	bazelContext, buildDir := testBazelContext(t, map[bazelCommand]string{})
+	if err := os.Chmod(buildDir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(buildDir, 0755)
+
+	err := bazelContext.InvokeBazel()
+	if err == nil {
+		t.Fatalf("Expected an error creating the intermediates directory")
+	}
+	if pathErr, ok := err.(*os.PathError); !ok || pathErr.Op != "mkdir" || !os.IsPermission(err) {
+		t.Errorf("Expected a mkdir permission error, got %s", err)
+	}
+}
+
+func TestBuiltinBazelRunnerTimeout(t *testing.T) {
+	dir := t.TempDir()
+	fakeBazel := filepath.Join(dir, "bazel")
+	// The fake client starts a fake server holding its output open, which is only killed through
+	// the pid file in the output base.
+	script := "#!/bin/sh\n" +
+		"mkdir -p outputbase/server\n" +
+		"sleep 60 &\n" +
+		"echo $! > outputbase/server/server.pid.txt\n" +
+		"exec sleep 60\n"
+	if err := ioutil.WriteFile(fakeBazel, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	paths := &bazelPaths{
+		bazelPath:    fakeBazel,
+		buildDir:     dir,
+		outputBase:   "outputbase",
+		workspaceDir: dir,
+	}
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64, timeout: 100 * time.Millisecond}
+
+	start := time.Now()
+	_, _, err := runner.issueBazelCommand(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err == nil {
+		t.Fatalf("Expected the hung bazel command to time out")
+	}
+	if !strings.Contains(err.Error(), "timed out after") || !strings.Contains(err.Error(), fakeBazel) {
+		t.Errorf("Expected a timeout error describing the command, got %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Errorf("Expected the bazel command to be killed after the timeout, but it ran for %s", elapsed)
+	}
+}
+
+func TestInvokeBazelReturnsBazelCommandError(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{})
+	runner := bazelContext.bazelRunner.(*mockBazelRunner)
+	runner.bazelCommandErrors = map[bazelCommand]error{
+		bazelCommand{command: "aquery", expression: "deps(//:buildroot)"}: &BazelCommandError{Command: "bazel aquery", ExitCode: 37},
+	}
+
+	err := bazelContext.InvokeBazel()
+	var commandErr *BazelCommandError
+	if !errors.As(err, &commandErr) {
+		t.Fatalf("Expected a BazelCommandError, got %v", err)
+	}
+	if commandErr.ExitCode != 37 {
+		t.Errorf("Expected exit code 37, got %d", commandErr.ExitCode)
+	}
+}
+
+func TestBuiltinBazelRunnerReturnsExitCode(t *testing.T) {
+	dir := t.TempDir()
+	fakeBazel := filepath.Join(dir, "bazel")
+	if err := ioutil.WriteFile(fakeBazel, []byte("#!/bin/sh\necho out\necho analysis failed >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	paths := &bazelPaths{
+		bazelPath:    fakeBazel,
+		buildDir:     dir,
+		outputBase:   "outputbase",
+		workspaceDir: dir,
+	}
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64}
+
+	_, _, err := runner.issueBazelCommand(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	var commandErr *BazelCommandError
+	if !errors.As(err, &commandErr) {
+		t.Fatalf("Expected a BazelCommandError, got %v", err)
+	}
+	if commandErr.ExitCode != 1 {
+		t.Errorf("Expected exit code 1, got %d", commandErr.ExitCode)
+	}
+	if g, w := commandErr.Stdout, "out\n"; g != w {
+		t.Errorf("Expected stdout %q, got %q", w, g)
+	}
+	if g, w := commandErr.Stderr, "analysis failed\n"; g != w {
+		t.Errorf("Expected stderr %q, got %q", w, g)
+	}
+
+	paths.bazelPath = filepath.Join(dir, "missing")
+	_, _, err = runner.issueBazelCommand(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if !errors.As(err, &commandErr) || commandErr.ExitCode != -1 {
+		t.Errorf("Expected a BazelCommandError with exit code -1 for a missing bazel, got %v", err)
+	}
+}
+
+// writeFakeBazel writes a fake bazel script which appends a line to an attempts file on every
+// invocation before running body, and returns the bazelPaths to invoke it and the attempts file.
+func writeFakeBazel(t *testing.T, body string) (*bazelPaths, string) {
+	dir := t.TempDir()
+	fakeBazel := filepath.Join(dir, "bazel")
+	attempts := filepath.Join(dir, "attempts")
+	script := "#!/bin/sh\necho >> " + attempts + "\n" + body
+	if err := ioutil.WriteFile(fakeBazel, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &bazelPaths{
+		bazelPath:    fakeBazel,
+		buildDir:     dir,
+		outputBase:   "outputbase",
+		workspaceDir: dir,
+	}, attempts
+}
+
+func TestTargetExists(t *testing.T) {
+	paths, _ := writeFakeBazel(t, `case "$*" in
+  *--platforms*)
+    echo "ERROR: Unrecognized option: --platforms" >&2
+    exit 2 ;;
+  *" query @sourceroot//foo:bar "*)
+    echo @sourceroot//foo:bar ;;
+  *" query @sourceroot//foo:missing "*)
+    echo "ERROR: no such target '@sourceroot//foo:missing': target 'missing' not declared in package 'foo'" >&2
+    exit 7 ;;
+  *)
+    echo "ERROR: Couldn't start the build. Unable to run tests" >&2
+    exit 37 ;;
+esac
+`)
+	bazelContext := &bazelContext{
+		bazelRunner: &builtinBazelRunner{hostOs: Linux, hostArch: X86_64},
+		paths:       paths,
+		runFlags:    defaultRunFlags(),
+	}
+
+	if exists, err := bazelContext.TargetExists("//foo:bar"); err != nil || !exists {
+		t.Errorf("Expected //foo:bar to exist, got %t (err: %v)", exists, err)
+	}
+	if exists, err := bazelContext.TargetExists("//foo:missing"); err != nil || exists {
+		t.Errorf("Expected //foo:missing not to exist, got %t (err: %v)", exists, err)
+	}
+	exists, err := bazelContext.TargetExists("//foo:broken")
+	var commandErr *BazelCommandError
+	if !errors.As(err, &commandErr) || commandErr.ExitCode != 37 {
+		t.Errorf("Expected a BazelCommandError with exit code 37 for a failed query, got %v", err)
+	}
+	if exists {
+		t.Errorf("Expected //foo:broken not to exist after a failed query")
+	}
+	if _, err := os.Stat(filepath.Join(paths.buildDir, "bazel", "WORKSPACE.bazel")); err != nil {
+		t.Errorf("Expected the workspace file to be written for the query, but got %s", err)
+	}
+}
+
+func countAttempts(t *testing.T, attempts string) int {
+	contents, err := ioutil.ReadFile(attempts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(contents), "\n")
+}
+
+func TestBuiltinBazelRunnerStreamsProgress(t *testing.T) {
+	paths, _ := writeFakeBazel(t, `echo 'Loading: 0 packages loaded' >&2
+echo 'Analyzing: 3 targets' >&2
+echo result
+`)
+	progress := &bytes.Buffer{}
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64, progress: progress}
+
+	stdout, stderr, err := runner.issueBazelCommand(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectedProgress := "Loading: 0 packages loaded\nAnalyzing: 3 targets\n"
+	if g, w := progress.String(), expectedProgress; g != w {
+		t.Errorf("Expected streamed progress %q, got %q", w, g)
+	}
+	if g, w := stderr, expectedProgress; g != w {
+		t.Errorf("Expected captured stderr %q, got %q", w, g)
+	}
+	if g, w := stdout, "result\n"; g != w {
+		t.Errorf("Expected stdout %q, got %q", w, g)
+	}
+
+	paths, _ = writeFakeBazel(t, "echo 'Loading: 0 packages loaded' >&2\necho 'analysis failed' >&2\nexit 1\n")
+	progress.Reset()
+	_, err = runner.issueBazelCommandToFile(paths, bazel.AqueryBuildRootRunName,
+		bazelCommand{command: "aquery", expression: "deps(//:buildroot)"}, filepath.Join(paths.buildDir, "out"))
+	var commandErr *BazelCommandError
+	if !errors.As(err, &commandErr) {
+		t.Fatalf("Expected a BazelCommandError, got %v", err)
+	}
+	expectedStderr := "Loading: 0 packages loaded\nanalysis failed\n"
+	if g, w := commandErr.Stderr, expectedStderr; g != w {
+		t.Errorf("Expected the error to contain stderr %q, got %q", w, g)
+	}
+	if g, w := progress.String(), expectedStderr; g != w {
+		t.Errorf("Expected streamed progress %q, got %q", w, g)
+	}
+}
+
+func TestBuiltinBazelRunnerRetriesTransientFailure(t *testing.T) {
+	paths, attempts := writeFakeBazel(t, `if [ ! -e "$0.failed" ]; then
+  touch "$0.failed"
+  echo "Server terminated abruptly (error code: 14)" >&2
+  exit 37
+fi
+echo success
+`)
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64, retries: 2}
+
+	stdout, _, err := runner.issueBazelCommand(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	if err != nil {
+		t.Fatalf("Expected the transient failure to be retried, got %s", err)
+	}
+	if g, w := stdout, "success\n"; g != w {
+		t.Errorf("Expected stdout %q, got %q", w, g)
+	}
+	if g, w := countAttempts(t, attempts), 2; g != w {
+		t.Errorf("Expected %d attempts, got %d", w, g)
+	}
+}
+
+func TestBuiltinBazelRunnerGivesUpOnPermanentTransientFailure(t *testing.T) {
+	paths, attempts := writeFakeBazel(t, "echo 'Server terminated abruptly (error code: 14)' >&2\nexit 37\n")
+	retryDelay := 50 * time.Millisecond
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64, retries: 2, retryDelay: retryDelay}
+
+	start := time.Now()
+	_, _, err := runner.issueBazelCommand(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"})
+	var commandErr *BazelCommandError
+	if !errors.As(err, &commandErr) || commandErr.ExitCode != 37 {
+		t.Fatalf("Expected a BazelCommandError with exit code 37, got %v", err)
+	}
+	if g, w := countAttempts(t, attempts), 3; g != w {
+		t.Errorf("Expected %d attempts, got %d", w, g)
+	}
+	// The delay doubles with each retry.
+	if g, w := time.Since(start), 3*retryDelay; g < w {
+		t.Errorf("Expected the retries to take at least %s, took %s", w, g)
+	}
+}
+
+func TestBuiltinBazelRunnerDoesNotRetryLockContention(t *testing.T) {
+	paths, attempts := writeFakeBazel(t, "echo 'Another command is running (pid = 1).' >&2\nexit 9\n")
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64, retries: 2}
+
+	if _, _, err := runner.issueBazelCommand(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"}); err == nil {
+		t.Fatalf("Expected the bazel command to fail")
+	}
+	if g, w := countAttempts(t, attempts), 1; g != w {
+		t.Errorf("Expected %d attempts, got %d", w, g)
+	}
+}
+
+func TestBuiltinBazelRunnerDoesNotRetryPermanentFailure(t *testing.T) {
+	paths, attempts := writeFakeBazel(t, "echo 'analysis failed' >&2\nexit 1\n")
+	runner := &builtinBazelRunner{hostOs: Linux, hostArch: X86_64, retries: 2}
+
+	if _, _, err := runner.issueBazelCommand(paths, bazel.CqueryBuildRootRunName,
+		bazelCommand{command: "cquery", expression: "deps(//:buildroot)"}); err == nil {
+		t.Fatalf("Expected the bazel command to fail")
+	}
+	if g, w := countAttempts(t, attempts), 1; g != w {
+		t.Errorf("Expected %d attempts, got %d", w, g)
+	}
+}
+
+func TestAllResultsReturnsCopy(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{
+		bazelCommand{command: "cquery", expression: "kind(rule, deps(//:buildroot))"}: "@sourceroot//foo:bar|arm64\tbar.out",
+	})
+	bazelContext.requests[CqueryKey{"//foo:bar", cquery.GetOutputFiles, Arm64}] = true
+
+	err := bazelContext.InvokeBazel()
+	if err != nil {
+		t.Fatalf("Did not expect error invoking Bazel, but got %s", err)
+	}
+
+	results := bazelContext.AllResults()
+	if w := map[string]string{"@sourceroot//foo:bar|arm64": "bar.out"}; !reflect.DeepEqual(w, results) {
+		t.Errorf("Expected results %v, got %v", w, results)
+	}
+
+	results["@sourceroot//foo:bar|arm64"] = "mutated"
+	if files, ok := bazelContext.GetOutputFiles("//foo:bar", Arm64); !ok || !reflect.DeepEqual(files, []string{"bar.out"}) {
+		t.Errorf("Expected mutating AllResults to leave results unchanged, got %q (ok: %t)", files, ok)
+	}
+}
+
+func TestInvokeBazelForRequestsLeavesQueueUnchanged(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{
+		bazelCommand{command: "cquery", expression: "kind(rule, deps(//:buildroot))"}: "@sourceroot//foo:bar|arm64\tbar.out\n" +
+			"@sourceroot//foo:baz|x86\tbaz.out",
+		bazelCommand{command: "aquery", expression: "deps(//:buildroot)"}: `
+{
+  "artifacts": [{ "id": 1, "pathFragmentId": 1 }, { "id": 2, "pathFragmentId": 2 }],
+  "actions": [{
+    "targetId": 1,
+    "actionKey": "x",
+    "mnemonic": "x",
+    "arguments": ["touch", "foo"],
+    "inputDepSetIds": [1],
+    "outputIds": [2],
+    "primaryOutputId": 2
+  }],
+  "depSetOfFiles": [{ "id": 1, "directArtifactIds": [1] }],
+  "pathFragments": [{ "id": 1, "label": "one" }, { "id": 2, "label": "two" }]
+}`,
+	})
+	queuedKey := CqueryKey{"//foo:baz", cquery.GetOutputFiles, X86}
+	bazelContext.requests[queuedKey] = true
+
+	key := CqueryKey{"//foo:bar", cquery.GetOutputFiles, Arm64}
+	results, buildStatements, err := bazelContext.InvokeBazelForRequests([]CqueryKey{key})
+	if err != nil {
+		t.Fatalf("Did not expect error invoking Bazel, but got %s", err)
+	}
+
+	if w := map[CqueryKey]string{key: "bar.out"}; !reflect.DeepEqual(w, results) {
+		t.Errorf("Expected results %v, got %v", w, results)
+	}
+	if len(buildStatements) != 1 {
+		t.Errorf("Expected 1 build statement, got %d: %v", len(buildStatements), buildStatements)
+	}
+
+	if w := map[CqueryKey]bool{queuedKey: true}; !reflect.DeepEqual(w, bazelContext.requests) {
+		t.Errorf("Expected queued requests %v to be unchanged, got %v", w, bazelContext.requests)
+	}
+	if len(bazelContext.results) != 0 {
+		t.Errorf("Expected no stored results, got %v", bazelContext.results)
+	}
+	if len(bazelContext.BuildStatementsToRegister()) != 0 {
+		t.Errorf("Expected no stored build statements, got %v", bazelContext.BuildStatementsToRegister())
+	}
+}
+
+func TestUnresolvedRequestsAfterPartialResults(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{
+		bazelCommand{command: "cquery", expression: "kind(rule, deps(//:buildroot))"}: "@sourceroot//foo:bar|arm64\tbar.out",
+	})
+	resolved := CqueryKey{Label: "//foo:bar", RequestType: cquery.GetOutputFiles, ArchType: Arm64}
+	unresolvedX86 := CqueryKey{Label: "//foo:baz", RequestType: cquery.GetOutputFiles, ArchType: X86}
+	unresolvedArm64 := CqueryKey{Label: "//foo:baz", RequestType: cquery.GetOutputFiles, ArchType: Arm64}
+	bazelContext.requests[resolved] = true
+	bazelContext.requests[unresolvedX86] = true
+	bazelContext.requests[unresolvedArm64] = true
+
+	err := bazelContext.InvokeBazel()
+	if err == nil {
+		t.Fatalf("Expected an error for the missing results")
+	}
+	if !strings.Contains(err.Error(), "@sourceroot//foo:baz|arm64, @sourceroot//foo:baz|x86") {
+		t.Errorf("Expected error to list the unresolved requests, got %s", err)
+	}
+
+	unresolved := bazelContext.UnresolvedRequests()
+	if w, g := []CqueryKey{unresolvedArm64, unresolvedX86}, unresolved; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected unresolved requests %v, got %v", w, g)
+	}
+	for _, key := range unresolved {
+		if key.Label != "//foo:baz" || key.RequestType != cquery.GetOutputFiles {
+			t.Errorf("Expected only GetOutputFiles requests of //foo:baz to be unresolved, got %v", key)
+		}
+	}
+	if files, ok := bazelContext.GetOutputFiles("//foo:bar", Arm64); !ok || !reflect.DeepEqual(files, []string{"bar.out"}) {
+		t.Errorf("Expected the partial result [bar.out] to be kept, got %q (ok: %t)", files, ok)
+	}
+}
+
+func TestInvokeBazelParsesResultsContainingFormerDelimiter(t *testing.T) {
+	// ">" is valid in target names, so neither the cquery id nor the result may be split on ">>".
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{
+		bazelCommand{command: "cquery", expression: "kind(rule, deps(//:buildroot))"}: "@sourceroot//foo:a>>b|arm64\tbazel-out/foo/a>>b.out\n" +
+			"@sourceroot//foo:c|arm64\tc>>d.out",
+	})
+	bazelContext.requests[CqueryKey{"//foo:a>>b", cquery.GetOutputFiles, Arm64}] = true
+	bazelContext.requests[CqueryKey{"//foo:c", cquery.GetOutputFiles, Arm64}] = true
+
+	if err := bazelContext.InvokeBazel(); err != nil {
+		t.Fatalf("Did not expect error invoking Bazel, but got %s", err)
+	}
+	if files, ok := bazelContext.GetOutputFiles("//foo:a>>b", Arm64); !ok || !reflect.DeepEqual(files, []string{"bazel-out/foo/a>>b.out"}) {
+		t.Errorf("Expected output files [bazel-out/foo/a>>b.out], got %q (ok: %t)", files, ok)
+	}
+	if files, ok := bazelContext.GetOutputFiles("//foo:c", Arm64); !ok || !reflect.DeepEqual(files, []string{"c>>d.out"}) {
+		t.Errorf("Expected output files [c>>d.out], got %q (ok: %t)", files, ok)
+	}
+}
+
+func TestInvokeBazelSplitCqueryMatchesSingleCquery(t *testing.T) {
+	cqueryOutput := "@sourceroot//foo:bar|arm64\tbar.out\n" +
+		"@sourceroot//foo:baz|arm64\tbaz.o|baz.out\n" +
+		"@sourceroot//foo:dep|arm64\tNONE\n" +
+		"@sourceroot//foo:qux|x86\tqux.out"
+	requests := []CqueryKey{
+		{"//foo:bar", cquery.GetOutputFiles, Arm64},
+		{"//foo:baz", cquery.GetOutputFilesAndCcObjectFiles, Arm64},
+		{"//foo:qux", cquery.GetRunfiles, X86},
+	}
+
+	results := map[bool]map[string]string{}
+	for _, splitCquery := range []bool{false, true} {
+		bazelContext, buildDir := testBazelContext(t, map[bazelCommand]string{
+			bazelCommand{command: "cquery", expression: "kind(rule, deps(//:buildroot))"}: cqueryOutput,
+		})
+		bazelContext.splitCquery = splitCquery
+		for _, key := range requests {
+			bazelContext.requests[key] = true
+		}
+		if err := bazelContext.InvokeBazel(); err != nil {
+			t.Fatalf("Did not expect error invoking Bazel with split cquery %t, but got %s", splitCquery, err)
+		}
+		results[splitCquery] = bazelContext.AllResults()
+
+		cqueries := 0
+		for _, command := range bazelContext.bazelRunner.(*mockBazelRunner).commands {
+			if command.command == "cquery" {
+				cqueries++
+			}
+		}
+		if splitCquery {
+			if cqueries != len(requests) {
+				t.Errorf("Expected a cquery per request type, got %d cqueries", cqueries)
+			}
+			for _, requestType := range []cquery.RequestType{cquery.GetOutputFiles, cquery.GetRunfiles} {
+				starlarkFile := filepath.Join(buildDir, "bazel", "buildroot."+requestType.Name()+".cquery")
+				if _, err := os.Stat(starlarkFile); err != nil {
+					t.Errorf("Expected a Starlark file for request type %s, but got %s", requestType.Name(), err)
+				}
+			}
+		} else if cqueries != 1 {
+			t.Errorf("Expected a single cquery, got %d cqueries", cqueries)
+		}
+	}
+
+	if len(results[false]) != len(requests) {
+		t.Errorf("Expected results for all requests, got %v", results[false])
+	}
+	if !reflect.DeepEqual(results[false], results[true]) {
+		t.Errorf("Expected split cquery results %v to equal single cquery results %v", results[true], results[false])
+	}
+}
+
+func TestSplitCqueryRunsCqueriesConcurrently(t *testing.T) {
+	// The fake Bazel holds a lock on its output base like a Bazel server, and each cquery only
+	// finishes once the other one has started, so the cqueries must run concurrently in separate
+	// output bases.
+	paths, _ := writeFakeBazel(t, `dir=$(dirname "$0")
+for arg in "$@"; do
+  case "$arg" in
+    --output_base=*) base=${arg#--output_base=} ;;
+  esac
+done
+if ! mkdir "$dir/lock.$base" 2>/dev/null; then
+  echo "Another command is running" >&2
+  exit 9
+fi
+touch "$dir/started.$base"
+i=0
+while [ $(ls "$dir" | grep -c '^started\.') -lt 2 ]; do
+  i=$((i+1))
+  if [ $i -gt 200 ]; then
+    echo "cqueries did not run concurrently" >&2
+    exit 1
+  fi
+  sleep 0.05
+done
+printf '@sourceroot//foo:bar|arm64\tbar.out\n@sourceroot//foo:qux|x86\tqux.out\n'
+`)
+	if err := os.MkdirAll(paths.intermediatesDir(), 0777); err != nil {
+		t.Fatal(err)
+	}
+	bazelContext := &bazelContext{
+		bazelRunner: &builtinBazelRunner{hostOs: Linux, hostArch: X86_64},
+		paths:       paths,
+		runFlags:    defaultRunFlags(),
+		splitCquery: true,
+	}
+	requests := map[CqueryKey]bool{
+		{"//foo:bar", cquery.GetOutputFiles, Arm64}: true,
+		{"//foo:qux", cquery.GetRunfiles, X86}:      true,
+	}
+
+	results, _, stderr, err := bazelContext.splitCqueryBuildRoot(requests, nil)
+	if err != nil {
+		t.Fatalf("Did not expect error from split cquery, but got %s (stderr: %s)", err, stderr)
+	}
+	expected := map[string]string{
+		"@sourceroot//foo:bar|arm64": "bar.out",
+		"@sourceroot//foo:qux|x86":   "qux.out",
+	}
+	if !reflect.DeepEqual(expected, results) {
+		t.Errorf("Expected results %v, got %v", expected, results)
+	}
+	for _, outputBase := range []string{"outputbase", "outputbase-" + cquery.GetRunfiles.Name()} {
+		if _, err := os.Stat(filepath.Join(paths.buildDir, "started."+outputBase)); err != nil {
+			t.Errorf("Expected a cquery in output base %s, but got %s", outputBase, err)
+		}
+	}
+}
+
+func TestMainFilesForMixedArchRequests(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{})
+	requests := map[CqueryKey]bool{
+		{"//foo:bar", cquery.GetOutputFiles, Arm64}:  true,
+		{"//foo:baz", cquery.GetOutputFiles, X86_64}: true,
+		{"//foo:qux", cquery.GetOutputFiles, Arm64}:  true,
+	}
+
+	mainBzl, err := bazelContext.mainBzlFileContents(requests)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, arch := range []string{"arm64", "x86_64"} {
+		transition := fmt.Sprintf(`"//command_line_option:platforms": "@sourceroot//build/bazel/platforms:android_%s"`, arch)
+		if !strings.Contains(string(mainBzl), transition) {
+			t.Errorf("Expected main.bzl to contain a transition to %q, got:\n%s", transition, mainBzl)
+		}
+		if rule := fmt.Sprintf(`"%s": _config_node_%s,`, arch, arch); !strings.Contains(string(mainBzl), rule) {
+			t.Errorf("Expected main.bzl to wire config_node for %s to %q, got:\n%s", arch, rule, mainBzl)
+		}
+	}
+	if strings.Contains(string(mainBzl), "android_x86\"") {
+		t.Errorf("Expected main.bzl to contain no transition for an arch without requests, got:\n%s", mainBzl)
+	}
+
+	buildFileContents, err := bazelContext.mainBuildFileContents(requests)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	buildFile := string(buildFileContents)
+	expectedConfigNodes := `
+config_node(name = "arm64",
+    arch = "arm64",
+    deps = ["@sourceroot//foo:bar",
+            "@sourceroot//foo:qux"],
+)
+
+config_node(name = "x86_64",
+    arch = "x86_64",
+    deps = ["@sourceroot//foo:baz"],
+)
+`
+	if !strings.Contains(buildFile, expectedConfigNodes) {
+		t.Errorf("Expected main BUILD file to contain %q, got:\n%s", expectedConfigNodes, buildFile)
+	}
+	if buildRootDeps := `deps = [":arm64",
+            ":x86_64"],`; !strings.Contains(buildFile, buildRootDeps) {
+		t.Errorf("Expected buildroot to depend on %q, got:\n%s", buildRootDeps, buildFile)
+	}
+}
+
+func TestMainBzlFileContentsRejectsArchWithoutPlatform(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{})
+	_, err := bazelContext.mainBzlFileContents(map[CqueryKey]bool{
+		{"//foo:bar", cquery.GetOutputFiles, ArchType{Name: "mips"}}: true,
+	})
+	if err == nil || !strings.Contains(err.Error(), "Unknown arch: mips") {
+		t.Errorf("Expected an error for an arch without an Android platform, got %v", err)
+	}
+}
+
+func TestArchlessRequestsAreNotGroupedUnderX86_64(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{})
+	archless := CqueryKey{"//foo:bar", cquery.GetOutputFiles, ArchType{}}
+	common := CqueryKey{"//foo:baz", cquery.GetOutputFiles, Common}
+	x86_64 := CqueryKey{"//foo:qux", cquery.GetOutputFiles, X86_64}
+	requests := map[CqueryKey]bool{archless: true, common: true, x86_64: true}
+
+	if g, w := getCqueryId(archless), "@sourceroot//foo:bar|common"; g != w {
+		t.Errorf("Expected cquery id %q, got %q", w, g)
+	}
+
+	buildFileContents, err := bazelContext.mainBuildFileContents(requests)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	buildFile := string(buildFileContents)
+	expectedConfigNodes := `
+config_node(name = "common",
+    arch = "common",
+    deps = ["@sourceroot//foo:bar",
+            "@sourceroot//foo:baz"],
+)
+
+config_node(name = "x86_64",
+    arch = "x86_64",
+    deps = ["@sourceroot//foo:qux"],
+)
+`
+	if !strings.Contains(buildFile, expectedConfigNodes) {
+		t.Errorf("Expected main BUILD file to contain %q, got:\n%s", expectedConfigNodes, buildFile)
+	}
+
+	mainBzl, err := bazelContext.mainBzlFileContents(requests)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rule := `"common": _config_node_common,`; !strings.Contains(string(mainBzl), rule) {
+		t.Errorf("Expected main.bzl to wire config_node for archless requests to %q, got:\n%s", rule, mainBzl)
+	}
+
+	cqueryFileContents, err := cqueryStarlarkFileContents(requests)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	cqueryFile := string(cqueryFileContents)
+	archlessIds := `  if get_arch(target) == "x86_64":
+    # Archless requests are built for the default platform, so a target requested both without an
+    # arch and for the arch of the default platform is a single configured target, which is
+    # formatted for both requests.
+    id_strings.append(str(target.label) + "|common")
+`
+	if !strings.Contains(cqueryFile, archlessIds) {
+		t.Errorf("Expected the cquery file to identify archless requests with %q, got:\n%s", archlessIds, cqueryFile)
+	}
+}
+
+func TestInvokeBazelResolvesArchlessAndX86_64RequestsOfALabel(t *testing.T) {
+	// A label requested both without an arch and for x86_64 is a single configured target, which
+	// the cquery formats once for each request.
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{
+		bazelCommand{command: "cquery", expression: "kind(rule, deps(//:buildroot))"}: "@sourceroot//foo:bar|x86_64\tbar.out\n" +
+			"@sourceroot//foo:bar|common\tbar.out",
+	})
+	bazelContext.requests[CqueryKey{"//foo:bar", cquery.GetOutputFiles, ArchType{}}] = true
+	bazelContext.requests[CqueryKey{"//foo:bar", cquery.GetOutputFiles, X86_64}] = true
+
+	if err := bazelContext.InvokeBazel(); err != nil {
+		t.Fatalf("Did not expect error invoking Bazel, but got %s", err)
+	}
+	for _, arch := range []ArchType{ArchType{}, X86_64} {
+		if files, ok := bazelContext.GetOutputFiles("//foo:bar", arch); !ok || !reflect.DeepEqual(files, []string{"bar.out"}) {
+			t.Errorf("Expected output files [bar.out] for arch %q, got %q (ok: %t)", arch.Name, files, ok)
+		}
+	}
+
+	cqueryFileContents, err := cqueryStarlarkFileContents(bazelContext.requests)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	cqueryFile := string(cqueryFileContents)
+	formatBoth := `  results = [format_id(id_string, target) for id_string in id_strings if is_requested(id_string)]
+  if results:
+    return "\n".join(results)
+`
+	if !strings.Contains(cqueryFile, formatBoth) {
+		t.Errorf("Expected the cquery file to format every requested id of a target with %q, got:\n%s", formatBoth, cqueryFile)
+	}
+}
+
+func TestCanonicalizeLabel(t *testing.T) {
+	testCases := []struct {
+		label    string
+		expected string
+	}{
+		{label: "//foo/bar:baz", expected: "@sourceroot//foo/bar:baz"},
+		{label: "foo/bar:baz", expected: "@sourceroot//foo/bar:baz"},
+		{label: "@bazel_tools//foo:bar", expected: "@bazel_tools//foo:bar"},
+		{label: "@sourceroot//foo:bar", expected: "@sourceroot//foo:bar"},
+	}
+	for _, tc := range testCases {
+		if g, err := canonicalizeLabel(tc.label); err != nil || g != tc.expected {
+			t.Errorf("Expected %q to be canonicalized to %q, got %q (err: %v)", tc.label, tc.expected, g, err)
+		}
+	}
+
+	if _, err := canonicalizeLabel(":foo"); err == nil || !strings.Contains(err.Error(), `relative label ":foo"`) {
+		t.Errorf("Expected an error describing the relative label, got %v", err)
+	}
+}
+
+func TestInvokeBazelRejectsRelativeLabels(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{})
+	bazelContext.requests[CqueryKey{":foo", cquery.GetOutputFiles, Arm64}] = true
+
+	if err := bazelContext.InvokeBazel(); err == nil || !strings.Contains(err.Error(), `relative label ":foo"`) {
+		t.Errorf("Expected an error describing the relative label, got %v", err)
+	}
+	runner := bazelContext.bazelRunner.(*mockBazelRunner)
+	if len(runner.commands) != 0 {
+		t.Errorf("Expected no bazel commands to be issued, got %v", runner.commands)
+	}
+}
+
+func TestQueuedRequests(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{})
+	if g := bazelContext.QueuedRequests(); len(g) != 0 {
+		t.Errorf("Expected no queued requests, got %v", g)
+	}
+
+	bazelContext.GetOutputFiles("//foo:bar", X86)
+	bazelContext.GetCcObjectFiles("//foo:bar", Arm64)
+	bazelContext.GetOutputFiles("//foo:bar", Arm64)
+	bazelContext.GetOutputFiles("//foo:baz", Arm64)
+	bazelContext.GetOutputFiles("//foo:bar", X86)
+
+	expected := []CqueryKey{
+		{"//foo:bar", cquery.GetCcObjectFiles, Arm64},
+		{"//foo:bar", cquery.GetOutputFiles, Arm64},
+		{"//foo:bar", cquery.GetOutputFiles, X86},
+		{"//foo:baz", cquery.GetOutputFiles, Arm64},
+	}
+	snapshot := bazelContext.QueuedRequests()
+	if !reflect.DeepEqual(expected, snapshot) {
+		t.Errorf("Expected queued requests %v, got %v", expected, snapshot)
+	}
+
+	snapshot[0] = CqueryKey{}
+	if g := bazelContext.QueuedRequests(); !reflect.DeepEqual(expected, g) {
+		t.Errorf("Expected the snapshot to be a copy, but queued requests changed to %v", g)
+	}
+}
+
+func TestCqueryStarlarkFileContents(t *testing.T) {
+	cqueryFileContents, err := cqueryStarlarkFileContents(map[CqueryKey]bool{
+		CqueryKey{"//foo:bar", cquery.GetContainerInfo, Arm64}: true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	contents := string(cqueryFileContents)
+
+	labelMap := `
+getContainerInfo_Labels = {
+  "@sourceroot//foo:bar|arm64" : True
+}
+`
+	if !strings.Contains(contents, labelMap) {
+		t.Errorf("Expected cquery file to contain label map %q, got:\n%s", labelMap, contents)
+	}
+	functionDef := "\ndef getContainerInfo_Fn(target):\n" + indent(cquery.GetContainerInfo.StarlarkFunctionBody())
+	if !strings.Contains(contents, functionDef) {
+		t.Errorf("Expected cquery file to contain function %q, got:\n%s", functionDef, contents)
+	}
+	switchCase := `
+  if id_string in getContainerInfo_Labels:
+    return id_string + "\t" + getContainerInfo_Fn(target)
+`
+	if !strings.Contains(contents, switchCase) {
+		t.Errorf("Expected cquery file to contain switch case %q, got:\n%s", switchCase, contents)
+	}
+}
+
+func TestPartitionBuildStatementsByConfig(t *testing.T) {
+	buildStatements := []bazel.BuildStatement{
+		{Command: "arm64 compile", OutputPaths: []string{"bazel-out/android_arm64-fastbuild/bin/foo.o"}},
+		{Command: "x86_64 compile", OutputPaths: []string{"bazel-out/android_x86_64-fastbuild/bin/foo.o"}},
+		{Command: "arm64 link", OutputPaths: []string{"bazel-out/android_arm64-fastbuild/bin/foo.so"}},
+		{Command: "symlink", OutputPaths: []string{"foo.txt"}},
+	}
+
+	configs, byConfig, err := partitionBuildStatementsByConfig(buildStatements)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if w := []string{"", "android_arm64-fastbuild", "android_x86_64-fastbuild"}; !reflect.DeepEqual(w, configs) {
+		t.Fatalf("Expected configs %q, got %q", w, configs)
+	}
+	expected := map[string][]bazel.BuildStatement{
+		"":                         {buildStatements[3]},
+		"android_arm64-fastbuild":  {buildStatements[0], buildStatements[2]},
+		"android_x86_64-fastbuild": {buildStatements[1]},
+	}
+	if !reflect.DeepEqual(expected, byConfig) {
+		t.Errorf("Expected build statements %v, got %v", expected, byConfig)
+	}
+
+	if g, w := bazelBuildStatementRuleName("android_arm64-fastbuild", 1), "bazel android_arm64-fastbuild 1"; g != w {
+		t.Errorf("Expected rule name %q, got %q", w, g)
+	}
+	if g, w := bazelBuildStatementRuleName("", 0), "bazel 0"; g != w {
+		t.Errorf("Expected rule name %q, got %q", w, g)
+	}
+
+	// A statement partitioned under x86_64 by its first output also writes an arm64 output.
+	conflicting := append(buildStatements, bazel.BuildStatement{
+		Command: "x86_64 copy",
+		OutputPaths: []string{
+			"bazel-out/android_x86_64-fastbuild/bin/bar.o",
+			"bazel-out/android_arm64-fastbuild/bin/foo.o",
+		},
+	})
+	if _, _, err := partitionBuildStatementsByConfig(conflicting); err == nil {
+		t.Errorf("Expected an error for statements of different configurations producing the same output")
+	} else if !strings.Contains(err.Error(), "bazel-out/android_arm64-fastbuild/bin/foo.o") {
+		t.Errorf("Expected the error to name the conflicting output, got %q", err)
+	}
+}
+
+func TestDedupBuildStatements(t *testing.T) {
+	buildStatements := []bazel.BuildStatement{
+		{Command: "compile", OutputPaths: []string{"bazel-out/android_arm64-fastbuild/bin/foo.o"}},
+		{Command: "link", OutputPaths: []string{"bazel-out/android_arm64-fastbuild/bin/foo.so"}},
+		{Command: "compile", OutputPaths: []string{"bazel-out/android_arm64-fastbuild/bin/foo.o"}},
+		{Command: "validate"},
+		{Command: "validate"},
+	}
+
+	deduped, err := dedupBuildStatements(buildStatements)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []bazel.BuildStatement{buildStatements[0], buildStatements[1], buildStatements[3], buildStatements[4]}
+	if !reflect.DeepEqual(expected, deduped) {
+		t.Errorf("Expected build statements %v, got %v", expected, deduped)
+	}
+
+	_, byConfig, err := partitionBuildStatementsByConfig(deduped)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if g := len(byConfig["android_arm64-fastbuild"]); g != 2 {
+		t.Errorf("Expected a single rule for the duplicated compile statement, got %d rules: %v", g, byConfig)
+	}
+
+	conflicting := append(buildStatements, bazel.BuildStatement{
+		Command:     "touch",
+		Mnemonic:    "Touch",
+		OutputPaths: []string{"bazel-out/android_arm64-fastbuild/bin/foo.so", "bar.txt"},
+	})
+	if _, err := dedupBuildStatements(conflicting); err == nil {
+		t.Errorf("Expected an error for distinct statements producing the same output")
+	} else if !strings.Contains(err.Error(), "bazel-out/android_arm64-fastbuild/bin/foo.so") {
+		t.Errorf("Expected the error to name the conflicting output, got %q", err)
+	}
+}
+
+func TestBazelBuildStatementCommand(t *testing.T) {
+	testCases := []struct {
+		description     string
+		buildStatement  bazel.BuildStatement
+		expectedCommand string
+		expectedOk      bool
+	}{
+		{
+			description: "command",
+			buildStatement: bazel.BuildStatement{
+				Command:     "clang -c foo.c -o bazel-out/bin/foo.o",
+				OutputPaths: []string{"bazel-out/bin/foo.o"},
+				Mnemonic:    "CppCompile",
+			},
+			expectedCommand: "clang -c foo.c -o bazel-out/bin/foo.o",
+			expectedOk:      true,
+		},
+		{
+			description: "symlink action",
+			buildStatement: bazel.BuildStatement{
+				InputPaths:  []string{"bazel-out/bin/foo/libfoo.so"},
+				OutputPaths: []string{"bazel-out/bin/_solib/libfoo.so"},
+				Mnemonic:    "Symlink",
+			},
+			expectedCommand: "mkdir -p bazel-out/bin/_solib && ln -sf ../foo/libfoo.so bazel-out/bin/_solib/libfoo.so",
+			expectedOk:      true,
+		},
+		{
+			description: "symlink action with several inputs",
+			buildStatement: bazel.BuildStatement{
+				InputPaths:  []string{"foo", "bar"},
+				OutputPaths: []string{"baz"},
+				Mnemonic:    "Symlink",
+			},
+			expectedOk: false,
+		},
+		{
+			description: "template action",
+			buildStatement: bazel.BuildStatement{
+				OutputPaths: []string{"bazel-out/bin/foo.sh"},
+				Mnemonic:    "TemplateExpand",
+			},
+			expectedOk: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		command, ok := bazelBuildStatementCommand(tc.buildStatement)
+		if ok != tc.expectedOk || command != tc.expectedCommand {
+			t.Errorf("%s: expected (%q, %t), got (%q, %t)", tc.description, tc.expectedCommand, tc.expectedOk, command, ok)
+		}
+	}
+}
+
+func TestRecordingBazelContext(t *testing.T) {
+	recorder := NewRecordingBazelContext(MockBazelContext{
+		AllFiles: map[string][]string{
+			"//foo:bar": []string{"bar.out"},
+		},
+	})
+
+	if files, ok := recorder.GetOutputFiles("//foo:bar", Arm64); !ok || !reflect.DeepEqual(files, []string{"bar.out"}) {
+		t.Errorf("Expected delegated result [bar.out], got %q (ok: %t)", files, ok)
+	}
+	recorder.GetOutputFilesAndCcObjectFiles("//foo:baz", X86)
+
+	expectedCalls := []RecordedBazelCall{
+		{Label: "//foo:bar", ArchType: Arm64, RequestType: cquery.GetOutputFiles},
+		{Label: "//foo:baz", ArchType: X86, RequestType: cquery.GetOutputFilesAndCcObjectFiles},
+	}
+	if calls := recorder.Calls(); !reflect.DeepEqual(expectedCalls, calls) {
+		t.Errorf("Expected calls %v, got %v", expectedCalls, calls)
+	}
+}
+
+func TestMockBazelContextGetOutputFilesAndCcObjectFiles(t *testing.T) {
+	bazelContext := MockBazelContext{
+		AllFiles: map[string][]string{
+			"//foo:legacy": []string{"legacy.out"},
+			"//foo:bar":    []string{"ignored.out"},
+		},
+		LabelToOutputFiles: map[string][]string{
+			"//foo:bar": []string{"libbar.so"},
+		},
+		LabelToCcObjects: map[string][]string{
+			"//foo:bar":     []string{"bar.o", "baz.o"},
+			"//foo:objects": []string{"objects.o"},
+		},
+	}
+
+	testCases := []struct {
+		label               string
+		expectedOutputFiles []string
+		expectedCcObjects   []string
+		expectedOk          bool
+	}{
+		{"//foo:bar", []string{"libbar.so"}, []string{"bar.o", "baz.o"}, true},
+		{"//foo:objects", nil, []string{"objects.o"}, true},
+		{"//foo:legacy", []string{"legacy.out"}, []string{"legacy.out"}, true},
+		{"//foo:missing", nil, nil, false},
+	}
+	for _, tc := range testCases {
+		outputFiles, ccObjects, ok := bazelContext.GetOutputFilesAndCcObjectFiles(tc.label, Arm64)
+		if !reflect.DeepEqual(tc.expectedOutputFiles, outputFiles) || !reflect.DeepEqual(tc.expectedCcObjects, ccObjects) || ok != tc.expectedOk {
+			t.Errorf("%s: expected (%q, %q, %t), got (%q, %q, %t)", tc.label,
+				tc.expectedOutputFiles, tc.expectedCcObjects, tc.expectedOk, outputFiles, ccObjects, ok)
+		}
+	}
+
+	if outputFiles, ok := bazelContext.GetOutputFiles("//foo:bar", Arm64); !ok || !reflect.DeepEqual(outputFiles, []string{"libbar.so"}) {
+		t.Errorf("Expected output files [libbar.so], got %q (ok: %t)", outputFiles, ok)
+	}
+	if ccObjects, ok := bazelContext.GetCcObjectFiles("//foo:bar", Arm64); !ok || !reflect.DeepEqual(ccObjects, []string{"bar.o", "baz.o"}) {
+		t.Errorf("Expected cc objects [bar.o baz.o], got %q (ok: %t)", ccObjects, ok)
+	}
+}
+
+func TestMockBazelContextGetRunfiles(t *testing.T) {
+	bazelContext := MockBazelContext{
+		AllRunfiles: map[string][]string{
+			"//tools:foo": []string{"bazel-out/k8-fastbuild/bin/tools/foo", "tools/foo_data.txt"},
+		},
+	}
+	if runfiles, ok := bazelContext.GetRunfiles("//tools:foo", X86_64); !ok ||
+		!reflect.DeepEqual(runfiles, []string{"bazel-out/k8-fastbuild/bin/tools/foo", "tools/foo_data.txt"}) {
+		t.Errorf("Expected the stubbed runfiles, got %q (ok: %t)", runfiles, ok)
+	}
+	if runfiles, ok := bazelContext.GetRunfiles("//tools:bar", X86_64); ok {
+		t.Errorf("Expected no runfiles for an unstubbed label, got %q", runfiles)
+	}
+}
+
+func TestMockBazelContextInvokeBazelForRequests(t *testing.T) {
+	bar := CqueryKey{"//foo:bar", cquery.GetOutputFiles, Arm64}
+	baz := CqueryKey{"//foo:baz", cquery.GetOutputFiles, Arm64}
+	bazelContext := MockBazelContext{
+		RequestResults:  map[CqueryKey]string{bar: "bar.out"},
+		BuildStatements: []bazel.BuildStatement{{Command: "touch bar.out"}},
+	}
+
+	results, buildStatements, err := bazelContext.InvokeBazelForRequests([]CqueryKey{bar})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if w := map[CqueryKey]string{bar: "bar.out"}; !reflect.DeepEqual(w, results) {
+		t.Errorf("Expected results %v, got %v", w, results)
+	}
+	if !reflect.DeepEqual(bazelContext.BuildStatements, buildStatements) {
+		t.Errorf("Expected build statements %v, got %v", bazelContext.BuildStatements, buildStatements)
+	}
+
+	results, _, err = bazelContext.InvokeBazelForRequests([]CqueryKey{bar, baz})
+	if err == nil || !strings.Contains(err.Error(), "//foo:baz") {
+		t.Errorf("Expected an error for the request of //foo:baz, got %v", err)
+	}
+	if w := map[CqueryKey]string{bar: "bar.out"}; !reflect.DeepEqual(w, results) {
+		t.Errorf("Expected partial results %v, got %v", w, results)
+	}
+}
+
+func TestGetApexInfo(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{})
+	bazelContext.results = map[CqueryKey]string{
+		CqueryKey{"//foo:apex", cquery.GetContainerInfo, Arm64}: "foo.apex|libfoo.so|30|lib64/libfoo.so",
+		CqueryKey{"//foo:apk", cquery.GetContainerInfo, Arm64}:  "foo.apk||NO_APEX_INFO",
+	}
+
+	apexInfo, ok, err := bazelContext.GetApexInfo("//foo:apex", Arm64)
+	if !ok || err != nil {
+		t.Fatalf("Expected ApexInfo of //foo:apex, got ok: %t, err: %s", ok, err)
+	}
+	expected := cquery.ApexInfo{MinSdkVersion: "30", PayloadFiles: []string{"lib64/libfoo.so"}}
+	if !reflect.DeepEqual(expected, apexInfo) {
+		t.Errorf("Expected ApexInfo %#v, got %#v", expected, apexInfo)
+	}
+
+	// A target without ApexInfo is an error for that label only.
+	if _, ok, err := bazelContext.GetApexInfo("//foo:apk", Arm64); !ok || err == nil {
+		t.Errorf("Expected an error for //foo:apk, which does not provide ApexInfo, got ok: %t, err: %v", ok, err)
+	}
+	if containerInfo, ok := bazelContext.GetContainerInfo("//foo:apk", Arm64); !ok || containerInfo.ContainerFile != "foo.apk" {
+		t.Errorf("Expected container file foo.apk, got %#v (ok: %t)", containerInfo, ok)
+	}
+}
+
+func TestGetExecutable(t *testing.T) {
+	bazelContext, _ := testBazelContext(t, map[bazelCommand]string{})
+	bazelContext.results = map[CqueryKey]string{
+		CqueryKey{"//foo:bin", cquery.GetExecutable, X86_64}: "bazel-out/k8-fastbuild/bin/foo/bin",
+		CqueryKey{"//foo:lib", cquery.GetExecutable, X86_64}: "",
+	}
+
+	if executable, ok, err := bazelContext.GetExecutable("//foo:bin", X86_64); !ok || err != nil || executable != "bazel-out/k8-fastbuild/bin/foo/bin" {
+		t.Errorf("Expected executable bazel-out/k8-fastbuild/bin/foo/bin, got %q (ok: %t, err: %v)", executable, ok, err)
+	}
+	// A target without an executable is an error for that label only.
+	if _, ok, err := bazelContext.GetExecutable("//foo:lib", X86_64); !ok || err == nil {
+		t.Errorf("Expected an error for //foo:lib, which has no executable, got ok: %t, err: %v", ok, err)
+	}
+}
+
+func testBazelContext(t *testing.T, bazelCommandResults map[bazelCommand]string) (*bazelContext, string) {
+	t.Helper()
+	p := bazelPaths{
+		buildDir:     t.TempDir(),
+		outputBase:   "outputbase",
+		workspaceDir: "workspace_dir",
+	}
+	aqueryCommand := bazelCommand{command: "aquery", expression: "deps(//:buildroot)"}
+	if _, exists := bazelCommandResults[aqueryCommand]; !exists {
+		bazelCommandResults[aqueryCommand] = "{}\n"
+	}
+	runner := &mockBazelRunner{bazelCommandResults: bazelCommandResults}
+	return &bazelContext{
+		bazelRunner: runner,
+		paths:       &p,
+		requests:    map[CqueryKey]bool{},
+		runFlags:    defaultRunFlags(),
+	}, p.buildDir
+}
+
+func TestQueueOutputFilesRequests(t *testing.T) {
+	labels := []string{"//foo:bar", "//foo:baz", "//foo:qux"}
+
+	batched, _ := testBazelContext(t, map[bazelCommand]string{})
+	batched.results = map[CqueryKey]string{
+		CqueryKey{"//foo:qux", cquery.GetOutputFiles, Arm64}: "qux.out",
+	}
+	batched.QueueOutputFilesRequests(labels, Arm64)
+
+	single, _ := testBazelContext(t, map[bazelCommand]string{})
+	single.results = batched.results
+	for _, label := range labels {
+		single.GetOutputFiles(label, Arm64)
+	}
+
+	if !reflect.DeepEqual(single.requests, batched.requests) {
+		t.Errorf("Expected batched requests %v to equal single requests %v", batched.requests, single.requests)
+	}
+	if _, ok := batched.requests[CqueryKey{"//foo:qux", cquery.GetOutputFiles, Arm64}]; ok {
+		t.Errorf("Expected a request with a result not to be queued again")
+	}
+}
+
+func benchmarkLabels(n int) []string {
+	labels := make([]string, n)
+	for i := range labels {
+		labels[i] = fmt.Sprintf("//foo:bar%d", i)
+	}
+	return labels
+}
+
+// BenchmarkQueueOutputFilesRequestsSingle and BenchmarkQueueOutputFilesRequestsBatched queue the
+// requests of many modules in parallel, each depending on many bazel targets, to compare the lock
+// contention of queueing the requests one at a time and in a batch.
+func BenchmarkQueueOutputFilesRequestsSingle(b *testing.B) {
+	labels := benchmarkLabels(500)
+	bazelCtx := &bazelContext{requests: map[CqueryKey]bool{}}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			for _, label := range labels {
+				bazelCtx.GetOutputFiles(label, Arm64)
+			}
+		}
+	})
+}
+
+func BenchmarkQueueOutputFilesRequestsBatched(b *testing.B) {
+	labels := benchmarkLabels(500)
+	bazelCtx := &bazelContext{requests: map[CqueryKey]bool{}}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			bazelCtx.QueueOutputFilesRequests(labels, Arm64)
+		}
+	})
+}
+
+func TestNewBazelContextValidatesPaths(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "bazel")
+	if err := ioutil.WriteFile(executable, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	nonExecutable := filepath.Join(dir, "bazel.txt")
+	if err := ioutil.WriteFile(nonExecutable, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		description    string
+		bazelPath      string
+		workspace      string
+		outputUserRoot string
+		diskCache      string
+		expectedError  string
+	}{
+		{
+			description: "valid",
+			bazelPath:   executable,
+			workspace:   dir,
+		},
+		{
+			description:   "nonexistent bazel",
+			bazelPath:     filepath.Join(dir, "missing"),
+			workspace:     dir,
+			expectedError: "BAZEL_PATH " + filepath.Join(dir, "missing") + " is not an executable file",
+		},
+		{
+			description:   "non-executable bazel",
+			bazelPath:     nonExecutable,
+			workspace:     dir,
+			expectedError: "BAZEL_PATH " + nonExecutable + " is not an executable file",
+		},
+		{
+			description:   "workspace is a file",
+			bazelPath:     executable,
+			workspace:     nonExecutable,
+			expectedError: "BAZEL_WORKSPACE " + nonExecutable + " is not a directory",
+		},
+		{
+			description:    "cache directories are created",
+			bazelPath:      executable,
+			workspace:      dir,
+			outputUserRoot: filepath.Join(dir, "cache", "root"),
+			diskCache:      filepath.Join(dir, "cache", "disk"),
+		},
+		{
+			description:   "disk cache is a file",
+			bazelPath:     executable,
+			workspace:     dir,
+			diskCache:     nonExecutable,
+			expectedError: "BAZEL_DISK_CACHE " + nonExecutable + " is not a directory and could not be created",
+		},
+		{
+			description:    "output user root below a file",
+			bazelPath:      executable,
+			workspace:      dir,
+			outputUserRoot: filepath.Join(nonExecutable, "root"),
+			expectedError:  "BAZEL_OUTPUT_USER_ROOT " + filepath.Join(nonExecutable, "root") + " is not a directory and could not be created",
+		},
+	}
+
+	for _, tc := range testCases {
+		c := &config{
+			buildDir: dir,
+			env: map[string]string{
+				"USE_BAZEL_ANALYSIS": "1",
+				"BAZEL_HOME":         "home",
+				"BAZEL_PATH":         tc.bazelPath,
+				"BAZEL_OUTPUT_BASE":  "outputbase",
+				"BAZEL_WORKSPACE":    tc.workspace,
+				"BAZEL_METRICS_DIR":  "metrics",
+
+				"BAZEL_OUTPUT_USER_ROOT": tc.outputUserRoot,
+				"BAZEL_DISK_CACHE":       tc.diskCache,
+			},
+		}
+		_, err := NewBazelContext(c)
+		if tc.expectedError == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", tc.description, err)
+			}
+			for _, cacheDir := range []string{tc.outputUserRoot, tc.diskCache} {
+				if info, err := os.Stat(cacheDir); cacheDir != "" && (err != nil || !info.IsDir()) {
+					t.Errorf("%s: expected directory %s to be created", tc.description, cacheDir)
+				}
+			}
+		} else if err == nil || err.Error() != tc.expectedError {
+			t.Errorf("%s: expected error %q, got %v", tc.description, tc.expectedError, err)
+		}
+	}
+}
+
+func TestNoopBazelContextDoesNotPanic(t *testing.T) {
+	var bazelContext BazelContext = noopBazelContext{}
+
+	bazelContext.QueueOutputFilesRequests([]string{"//foo:bar"}, Arm64)
+	if err := bazelContext.InvokeBazel(); err != nil {
+		t.Errorf("Expected InvokeBazel to succeed, got %s", err)
+	}
+	if files, ok := bazelContext.GetOutputFiles("//foo:bar", Arm64); ok || files != nil {
+		t.Errorf("Expected no output files, got %q (ok: %t)", files, ok)
+	}
+	if files, objs, ok := bazelContext.GetOutputFilesAndCcObjectFiles("//foo:bar", Arm64); ok || files != nil || objs != nil {
+		t.Errorf("Expected no output files or object files, got %q and %q (ok: %t)", files, objs, ok)
+	}
+	if objs, ok := bazelContext.GetCcObjectFiles("//foo:bar", Arm64); ok || objs != nil {
+		t.Errorf("Expected no object files, got %q (ok: %t)", objs, ok)
+	}
+	if _, ok := bazelContext.GetContainerInfo("//foo:bar", Arm64); ok {
+		t.Errorf("Expected no container info")
+	}
+}
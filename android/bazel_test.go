@@ -132,3 +132,32 @@ func TestModuleOptIn(t *testing.T) {
 		}
 	}
 }
+
+func TestBlueprintModulePositions(t *testing.T) {
+	bp := `
+cc_library {
+    name: "foo",
+}
+
+cc_defaults {
+    name: "bar",
+}
+
+cc_library {
+    name: "baz" + "_suffix",
+}
+`
+	config := TestConfig(t.TempDir(), nil, bp, nil)
+	positions, err := BlueprintModulePositions(config, "Android.bp")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	for name, expected := range map[string]string{"foo": "Android.bp:2:1", "bar": "Android.bp:6:1"} {
+		if pos, ok := positions[name]; !ok || pos.String() != expected {
+			t.Errorf("Expected position %s for module %q, got %v", expected, name, positions[name])
+		}
+	}
+	if pos, ok := positions["baz_suffix"]; ok {
+		t.Errorf("Expected no position for a module whose name is not a string literal, got %s", pos)
+	}
+}
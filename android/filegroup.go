@@ -64,6 +64,11 @@ func FilegroupBp2Build(ctx TopDownMutatorContext) {
 	}
 
 	props := bazel.BazelTargetModuleProperties{Rule_class: "filegroup"}
+	if fg.properties.Path != nil {
+		// The native filegroup rule has no equivalent of the base path stripped from the files by
+		// the modules using them.
+		props.Unconverted_properties = []string{"path"}
+	}
 
 	ctx.CreateBazelTargetModule(BazelFileGroupFactory, fg.Name(), props, attrs)
 }
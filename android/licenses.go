@@ -128,6 +128,21 @@ func makeLicensesContainer(propVals []string) licensesContainer {
 	return licensesContainer{licenses}
 }
 
+// Registers the function that adds dependencies on the licenses of modules being converted to
+// Bazel, so that they can be resolved to the labels of the applicable_licenses of their targets.
+func registerLicensesDepsMutatorBp2Build(ctx RegisterMutatorsContext) {
+	ctx.BottomUp("licenses_deps_bp2build", licensesDepsMutatorBp2Build).Parallel()
+}
+
+// Adds dependencies on the licenses of a module being converted to Bazel.
+func licensesDepsMutatorBp2Build(ctx BottomUpMutatorContext) {
+	m := ctx.Module()
+	if b, ok := m.(Bazelable); !ok || !b.ConvertWithBp2build(ctx) {
+		return
+	}
+	ctx.AddDependency(m, licensesTag, m.base().commonProperties.Licenses...)
+}
+
 // Gathers the applicable licenses into dependency references after defaults expansion.
 func licensesPropertyGatherer(ctx BottomUpMutatorContext) {
 	m, ok := ctx.Module().(Module)
@@ -513,6 +513,11 @@ type BazelTargetModule interface {
 
 	RuleClass() string
 	BzlLoadLocation() string
+	UnconvertedProperties() []string
+
+	// SourceModuleName returns the name of the Soong module this Bazel target was converted from.
+	SourceModuleName() string
+	setSourceModuleName(name string)
 }
 
 // InitBazelTargetModule is a wrapper function that decorates BazelTargetModule
@@ -527,6 +532,8 @@ func InitBazelTargetModule(module BazelTargetModule) {
 type BazelTargetModuleBase struct {
 	ModuleBase
 	Properties bazel.BazelTargetModuleProperties
+
+	sourceModuleName string
 }
 
 // bazelTargetModuleProperties getter.
@@ -549,6 +556,21 @@ func (b *BazelTargetModuleBase) BzlLoadLocation() string {
 	return b.bazelTargetModuleProperties().Bzl_load_location
 }
 
+// UnconvertedProperties returns the properties of the Soong module that the converter could not
+// convert to attributes of this Bazel target.
+func (b *BazelTargetModuleBase) UnconvertedProperties() []string {
+	return b.bazelTargetModuleProperties().Unconverted_properties
+}
+
+// SourceModuleName returns the name of the Soong module this Bazel target was converted from.
+func (b *BazelTargetModuleBase) SourceModuleName() string {
+	return b.sourceModuleName
+}
+
+func (b *BazelTargetModuleBase) setSourceModuleName(name string) {
+	b.sourceModuleName = name
+}
+
 // Qualified id for a module
 type qualifiedModuleName struct {
 	// The package (i.e. directory) in which the module is defined, without trailing /
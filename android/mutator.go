@@ -55,6 +55,8 @@ func RegisterMutatorsForBazelConversion(ctx *Context, preArchMutators, depsMutat
 	bp2buildDepsMutators = append([]RegisterMutatorFunc{
 		registerDepsMutatorBp2Build,
 		registerPathDepsMutator,
+		registerLicensesDepsMutatorBp2Build,
+		registerRequiredDepsMutatorBp2Build,
 	}, depsMutators...)
 
 	for _, f := range bp2buildDepsMutators {
@@ -277,6 +279,12 @@ type TopDownMutatorContext interface {
 	// BazelTargetModuleProperties containing additional metadata for the
 	// bp2build codegenerator.
 	CreateBazelTargetModule(ModuleFactory, string, bazel.BazelTargetModuleProperties, interface{}) BazelTargetModule
+
+	// CreateBazelAlias creates a BazelTargetModule for an alias target with the
+	// given name that refers to the actual label. This keeps references to the
+	// Soong module name working when the converted Bazel target is named
+	// differently.
+	CreateBazelAlias(name string, actual string) BazelTargetModule
 }
 
 type topDownMutatorContext struct {
@@ -532,6 +540,12 @@ func (t *topDownMutatorContext) CreateBazelTargetModule(
 			bazel.BazelTargetModuleNamePrefix,
 			name))
 	}
+	if b, ok := t.Module().(Bazelable); ok && b.ConvertWithBp2build(t) {
+		if licenses := t.Module().base().commonProperties.Licenses; len(licenses) > 0 {
+			bazelProps.Applicable_licenses = bazel.MakeLabelListAttribute(BazelLabelForModuleDeps(t, licenses))
+		}
+	}
+
 	name = bazel.BazelTargetModuleNamePrefix + name
 	nameProp := struct {
 		Name *string
@@ -541,9 +555,18 @@ func (t *topDownMutatorContext) CreateBazelTargetModule(
 
 	b := t.createModuleWithoutInheritance(factory, &nameProp, attrs).(BazelTargetModule)
 	b.SetBazelTargetModuleProperties(bazelProps)
+	b.setSourceModuleName(t.Module().base().Name())
 	return b
 }
 
+func (t *topDownMutatorContext) CreateBazelAlias(name string, actual string) BazelTargetModule {
+	attrs := &bazelAliasAttributes{
+		Actual: bazel.Label{Label: actual},
+	}
+	props := bazel.BazelTargetModuleProperties{Rule_class: "alias"}
+	return t.CreateBazelTargetModule(bazelAliasFactory, name, props, attrs)
+}
+
 func (t *topDownMutatorContext) AppendProperties(props ...interface{}) {
 	for _, p := range props {
 		err := proptools.AppendMatchingProperties(t.Module().base().customizableProperties,
@@ -390,6 +390,7 @@ type BazelConversionPathContext interface {
 	EarlyModulePathContext
 
 	GetDirectDep(name string) (blueprint.Module, blueprint.DependencyTag)
+	VisitDirectDepsBlueprint(visit func(blueprint.Module))
 	Module() Module
 	ModuleType() string
 	OtherModuleName(m blueprint.Module) string
@@ -406,6 +407,13 @@ func BazelLabelForModuleDeps(ctx BazelConversionPathContext, modules []string) b
 			module = ":" + module
 		}
 		if m, t := SrcIsModuleWithTag(module); m != "" {
+			if isDefaultsModuleDep(ctx, m) {
+				// Defaults modules are never converted to Bazel targets, so a label for one would
+				// be dangling.
+				ctx.ModuleErrorf("%q is a defaults module and cannot be used as a dependency, "+
+					"list it in the defaults property instead", m)
+				continue
+			}
 			l := getOtherModuleLabel(ctx, m, t)
 			l.Bp_text = bpText
 			labels.Includes = append(labels.Includes, l)
@@ -439,6 +447,115 @@ func BazelLabelForModuleSrcExcludes(ctx BazelConversionPathContext, paths, exclu
 	}
 	labels := expandSrcsForBazel(ctx, paths, excluded)
 	labels.Excludes = excludeLabels.Includes
+	return bazelLabelsForSubpackageFiles(ctx, labels)
+}
+
+// bazelLabelsForSubpackageFiles replaces the labels of files in subdirectories of the module's
+// directory which are separate Bazel packages, i.e. contain an Android.bp file, with full labels
+// of the files in those packages, e.g. "//a/b:c.h" instead of "b/c.h" for a module in "a". The
+// files are recorded in BazelExportedFiles, as the packages must export them.
+func bazelLabelsForSubpackageFiles(ctx BazelConversionPathContext, labels bazel.LabelList) bazel.LabelList {
+	transform := func(in []bazel.Label) []bazel.Label {
+		var out []bazel.Label
+		for _, l := range in {
+			out = append(out, bazelLabelForSubpackageFile(ctx, l))
+		}
+		return out
+	}
+	labels.Includes = transform(labels.Includes)
+	labels.Excludes = transform(labels.Excludes)
+	return labels
+}
+
+func bazelLabelForSubpackageFile(ctx BazelConversionPathContext, label bazel.Label) bazel.Label {
+	if strings.HasPrefix(label.Label, ":") || strings.HasPrefix(label.Label, "//") ||
+		strings.HasPrefix(label.Label, "@") {
+		// Not a file, or already a full label.
+		return label
+	}
+	// The closest enclosing package of the file is its package.
+	for dir := filepath.Dir(label.Label); dir != "."; dir = filepath.Dir(dir) {
+		if ExistentPathForSource(ctx, ctx.ModuleDir(), dir, "Android.bp").Valid() {
+			pkg := filepath.Join(ctx.ModuleDir(), dir)
+			file, _ := filepath.Rel(dir, label.Label)
+			recordBazelExportedFile(ctx.Config(), pkg, file)
+			label.Label = "//" + pkg + ":" + file
+			return label
+		}
+	}
+	return label
+}
+
+// BazelLabelForModuleInitRcAndVintfFragments returns bazel.LabelList with the init_rc and
+// vintf_fragments files of the module, which are installed alongside it, rooted from the module's
+// local source directory.
+func BazelLabelForModuleInitRcAndVintfFragments(ctx BazelConversionPathContext, module Module) bazel.LabelList {
+	props := module.base().commonProperties
+	files := append(CopyOf(props.Init_rc), props.Vintf_fragments...)
+	return BazelLabelForModuleSrc(ctx, files)
+}
+
+// BazelLabelForModuleRequired returns a bazel.LabelListAttribute with the modules required by the
+// module. Modules in required are needed by all variants, while modules in host_required and
+// target_required are only selected for host and device OSes respectively, so that host-only
+// modules are not pulled into device targets and vice versa. Required modules that are not
+// defined in Android.bp files are skipped.
+func BazelLabelForModuleRequired(ctx BazelConversionPathContext, module Module) bazel.LabelListAttribute {
+	props := module.base().commonProperties
+	existing := func(modules []string) []string {
+		var ret []string
+		for _, m := range modules {
+			if dep, _ := ctx.GetDirectDep(m); dep != nil {
+				ret = append(ret, m)
+			}
+		}
+		return ret
+	}
+
+	attr := bazel.MakeLabelListAttribute(BazelLabelForModuleDeps(ctx, existing(props.Required)))
+	hostRequired := BazelLabelForModuleDeps(ctx, existing(props.Host_required))
+	targetRequired := BazelLabelForModuleDeps(ctx, existing(props.Target_required))
+	for _, os := range OsTypeList {
+		switch os.Class {
+		case Host:
+			if len(hostRequired.Includes) > 0 {
+				attr.SetValueForOS(os.Name, hostRequired)
+			}
+		case Device:
+			if len(targetRequired.Includes) > 0 {
+				attr.SetValueForOS(os.Name, targetRequired)
+			}
+		}
+	}
+	return attr
+}
+
+// GlobToLabelList returns a bazel.LabelList of the files in the module's source directory matching
+// any of the glob patterns and none of the excludes, as seen by the module's filesystem. Patterns
+// and excludes are relative to the module's source directory, as are the sorted labels returned.
+// The Bp_text of each label is the first pattern matching the file. The returned LabelList is
+// empty if no file matches.
+func GlobToLabelList(ctx BazelConversionPathContext, patterns, excludes []string) bazel.LabelList {
+	expandedExcludes := make([]string, 0, len(excludes))
+	for _, e := range excludes {
+		expandedExcludes = append(expandedExcludes, pathForModuleSrc(ctx, e).String())
+	}
+
+	patternOfFile := make(map[string]string)
+	for _, p := range patterns {
+		globbedPaths := GlobFiles(ctx, pathForModuleSrc(ctx, p).String(), expandedExcludes)
+		for _, path := range PathsWithModuleSrcSubDir(ctx, globbedPaths, "") {
+			if _, ok := patternOfFile[path.Rel()]; !ok {
+				patternOfFile[path.Rel()] = p
+			}
+		}
+	}
+
+	labels := bazel.LabelList{}
+	for _, f := range SortedStringKeys(patternOfFile) {
+		label := bazel.Label{Label: f, Bp_text: patternOfFile[f]}
+		labels.Includes = append(labels.Includes, bazelLabelForSubpackageFile(ctx, label))
+	}
 	return labels
 }
 
@@ -486,7 +603,11 @@ func expandSrcsForBazel(ctx BazelConversionPathContext, paths, expandedExcludes
 // module. The label will be relative to the current directory if appropriate. The dependency must
 // already be resolved by either deps mutator or path deps mutator.
 func getOtherModuleLabel(ctx BazelConversionPathContext, dep, tag string) bazel.Label {
-	m, _ := ctx.GetDirectDep(dep)
+	m, err := bazelDirectDep(ctx, dep)
+	if err != nil {
+		ctx.ModuleErrorf("%s", err)
+		return bazel.Label{Label: dep}
+	}
 	if m == nil {
 		panic(fmt.Errorf("cannot get direct dep %s of %s", dep, ctx.Module().Name()))
 	}
@@ -501,6 +622,66 @@ func getOtherModuleLabel(ctx BazelConversionPathContext, dep, tag string) bazel.
 	}
 }
 
+// isDefaultsModuleDep returns true if the given direct dependency of the module is a defaults
+// module, e.g. a cc_defaults mistakenly listed in header_libs.
+func isDefaultsModuleDep(ctx BazelConversionPathContext, dep string) bool {
+	m, _ := bazelDirectDep(ctx, dep)
+	_, ok := m.(Defaults)
+	return ok
+}
+
+// bazelDirectDep returns the direct dependency of the module referenced by dep, which is either a
+// module name or a fully qualified "//namespace_path:name" reference, or nil if there is none.
+// Modules in different namespaces may share a name, so a module name that matches several direct
+// dependencies is ambiguous and results in an error; only a fully qualified reference can pick
+// one of them.
+func bazelDirectDep(ctx BazelConversionPathContext, dep string) (blueprint.Module, error) {
+	namespacePath, name := "", dep
+	if strings.HasPrefix(dep, "//") {
+		if i := strings.Index(dep, ":"); i >= 0 {
+			namespacePath, name = dep[2:i], dep[i+1:]
+		}
+	}
+
+	var found []blueprint.Module
+	ctx.VisitDirectDepsBlueprint(func(m blueprint.Module) {
+		depName := ctx.OtherModuleName(m)
+		if aModule, ok := m.(Module); ok {
+			depName = aModule.base().BaseModuleName()
+		}
+		if depName != name {
+			return
+		}
+		if namespacePath != "" {
+			dir := ctx.OtherModuleDir(m)
+			if dir != namespacePath && !strings.HasPrefix(dir, namespacePath+"/") {
+				return
+			}
+		}
+		for _, f := range found {
+			if f == m {
+				// The same module can be a dependency with multiple tags.
+				return
+			}
+		}
+		found = append(found, m)
+	})
+
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return found[0], nil
+	default:
+		var dirs []string
+		for _, m := range found {
+			dirs = append(dirs, ctx.OtherModuleDir(m))
+		}
+		return nil, fmt.Errorf("%q is ambiguous, it matches dependencies in %q; "+
+			"use a fully qualified \"//namespace_path:%s\" reference instead", dep, dirs, name)
+	}
+}
+
 func bazelModuleLabel(ctx BazelConversionPathContext, module blueprint.Module, tag string) string {
 	// TODO(b/165114590): Convert tag (":name{.tag}") to corresponding Bazel implicit output targets.
 	b, ok := module.(Bazelable)
@@ -2161,6 +2342,12 @@ func WriteFileToOutputDir(path WritablePath, data []byte, perm os.FileMode) erro
 	return ioutil.WriteFile(absolutePath(path.String()), data, perm)
 }
 
+// CreateFileInOutputDir creates or truncates the file at path so that it can be written to
+// incrementally.
+func CreateFileInOutputDir(path WritablePath, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(absolutePath(path.String()), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
+
 func RemoveAllOutputDir(path WritablePath) error {
 	return os.RemoveAll(absolutePath(path.String()))
 }
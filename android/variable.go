@@ -91,6 +91,7 @@ type variableProperties struct {
 		// as possible.
 		Debuggable struct {
 			Cflags          []string
+			Srcs            []string `android:"path"`
 			Cppflags        []string
 			Init_rc         []string
 			Required        []string
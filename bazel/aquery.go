@@ -17,6 +17,7 @@ package bazel
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"path/filepath"
 	"strings"
 
@@ -85,14 +86,30 @@ type BuildStatement struct {
 // to a ninja file) to correspond one-to-one with the given action graph json proto (from a bazel
 // aquery invocation).
 func AqueryBuildStatements(aqueryJsonProto []byte) ([]BuildStatement, error) {
-	buildStatements := []BuildStatement{}
-
 	var aqueryResult actionGraphContainer
 	err := json.Unmarshal(aqueryJsonProto, &aqueryResult)
 
 	if err != nil {
 		return nil, err
 	}
+	return buildStatementsFromActionGraph(aqueryResult)
+}
+
+// AqueryBuildStatementsFromReader is like AqueryBuildStatements, but decodes the action graph json
+// proto from the given reader, such as a file containing the output of a bazel aquery invocation.
+// This avoids holding the entire raw aquery output in memory for very large action graphs.
+func AqueryBuildStatementsFromReader(r io.Reader) ([]BuildStatement, error) {
+	var aqueryResult actionGraphContainer
+	err := json.NewDecoder(r).Decode(&aqueryResult)
+
+	if err != nil {
+		return nil, err
+	}
+	return buildStatementsFromActionGraph(aqueryResult)
+}
+
+func buildStatementsFromActionGraph(aqueryResult actionGraphContainer) ([]BuildStatement, error) {
+	buildStatements := []BuildStatement{}
 
 	pathFragments := map[int]pathFragment{}
 	for _, pathFragment := range aqueryResult.PathFragments {
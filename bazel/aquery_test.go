@@ -17,6 +17,7 @@ package bazel
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -448,6 +449,56 @@ func TestDepfiles(t *testing.T) {
 	}
 }
 
+func TestAqueryBuildStatementsFromReader(t *testing.T) {
+	const inputString = `
+{
+  "artifacts": [{
+    "id": 1,
+    "pathFragmentId": 1
+  }, {
+    "id": 2,
+    "pathFragmentId": 2
+  }],
+  "actions": [{
+    "targetId": 1,
+    "actionKey": "x",
+    "mnemonic": "x",
+    "arguments": ["touch", "foo"],
+    "inputDepSetIds": [1],
+    "outputIds": [2],
+    "primaryOutputId": 2
+  }],
+  "depSetOfFiles": [{
+    "id": 1,
+    "directArtifactIds": [1]
+  }],
+  "pathFragments": [{
+    "id": 1,
+    "label": "one"
+  }, {
+    "id": 2,
+    "label": "two"
+  }]
+}`
+
+	actual, err := AqueryBuildStatementsFromReader(strings.NewReader(inputString))
+	if err != nil {
+		t.Fatalf("Unexpected error %q", err)
+	}
+	expected := []BuildStatement{
+		BuildStatement{
+			Command:     "touch foo",
+			OutputPaths: []string{"two"},
+			InputPaths:  []string{"one"},
+			Mnemonic:    "x",
+		},
+	}
+	assertBuildStatements(t, expected, actual)
+
+	_, err = AqueryBuildStatementsFromReader(strings.NewReader("{"))
+	assertError(t, err, "unexpected EOF")
+}
+
 func TestMultipleDepfiles(t *testing.T) {
 	const inputString = `
 {
@@ -16,6 +16,9 @@ const (
 	// Perform cquery of the Bazel build root and its dependencies.
 	CqueryBuildRootRunName = RunName("cquery-buildroot")
 
+	// Perform a query of a single label to check whether the target exists.
+	QueryTargetExistsRunName = RunName("query-target-exists")
+
 	// Run bazel as a ninja executer
 	BazelNinjaExecRunName = RunName("bazel-ninja-exec")
 )
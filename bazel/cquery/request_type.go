@@ -7,6 +7,12 @@ import (
 var (
 	GetOutputFiles                 RequestType = &getOutputFilesRequestType{}
 	GetOutputFilesAndCcObjectFiles RequestType = &getOutputFilesAndCcObjectFilesType{}
+	GetCcObjectFiles               RequestType = &getCcObjectFilesType{}
+	GetContainerInfo               RequestType = &getContainerInfoType{}
+	GetCcIncludes                  RequestType = &getCcIncludesType{}
+	GetRunfiles                    RequestType = &getRunfilesType{}
+	GetGeneratedSources            RequestType = &getGeneratedSourcesType{}
+	GetExecutable                  RequestType = &getExecutableType{}
 )
 
 type GetOutputFilesAndCcObjectFiles_Result struct {
@@ -14,9 +20,42 @@ type GetOutputFilesAndCcObjectFiles_Result struct {
 	CcObjectFiles []string
 }
 
+// GetContainerInfo_Result is the result of a GetContainerInfo request, which applies to any
+// container target (APEX, APK, ...).
+type GetContainerInfo_Result struct {
+	// The container (e.g. APEX or APK) file built by the target, or empty if the target builds no
+	// files.
+	ContainerFile string
+	// The files bundled in the container.
+	Contents []string
+	// The fields of the ApexInfo provider of the target, or nil if the target is not an APEX.
+	ApexInfo *ApexInfo
+}
+
+type GetCcIncludes_Result struct {
+	// The include directories exported by the target and its dependencies, passed with -I.
+	Includes []string
+	// The system include directories exported by the target and its dependencies, passed with
+	// -isystem.
+	SystemIncludes []string
+}
+
+type ApexInfo struct {
+	// The minimum SDK version supported by the APEX, or empty if it is unset.
+	MinSdkVersion string
+	// The execroot-relative paths of the files in the payload of the APEX.
+	PayloadFiles []string
+}
+
 var RequestTypes []RequestType = []RequestType{
 	GetOutputFiles,
 	GetOutputFilesAndCcObjectFiles,
+	GetCcObjectFiles,
+	GetContainerInfo,
+	GetCcIncludes,
+	GetRunfiles,
+	GetGeneratedSources,
+	GetExecutable,
 }
 
 type RequestType interface {
@@ -87,3 +126,171 @@ func (g getOutputFilesAndCcObjectFilesType) ParseResult(rawString string) interf
 	ccObjects = strings.Split(ccObjectsString, ", ")
 	return GetOutputFilesAndCcObjectFiles_Result{outputFiles, ccObjects}
 }
+
+type getCcObjectFilesType struct{}
+
+func (g getCcObjectFilesType) Name() string {
+	return "getCcObjectFiles"
+}
+
+func (g getCcObjectFilesType) StarlarkFunctionBody() string {
+	return `
+ccObjectFiles = []
+linker_inputs = providers(target)["CcInfo"].linking_context.linker_inputs.to_list()
+
+for linker_input in linker_inputs:
+  for library in linker_input.libraries:
+    for object in library.objects:
+      ccObjectFiles += [object.path]
+return ', '.join(ccObjectFiles)`
+}
+
+// ParseResult returns the object files of the target as a []string, which is empty if the target
+// has no object files.
+func (g getCcObjectFilesType) ParseResult(rawString string) interface{} {
+	if rawString == "" {
+		return []string{}
+	}
+	return strings.Split(rawString, ", ")
+}
+
+type getContainerInfoType struct{}
+
+func (g getContainerInfoType) Name() string {
+	return "getContainerInfo"
+}
+
+func (g getContainerInfoType) StarlarkFunctionBody() string {
+	return `
+files = target.files.to_list()
+containerFile = files[0].path if files else ""
+
+contents = []
+containerInfo = providers(target).get("//build/bazel/rules:container.bzl%ContainerInfo")
+if containerInfo:
+  contents = [f.path for f in containerInfo.contents.to_list()]
+
+apex = "` + noApexInfo + `"
+apexInfo = providers(target).get("//build/bazel/rules:apex.bzl%ApexInfo")
+if apexInfo:
+  minSdkVersion = apexInfo.min_sdk_version or ""
+  payloadFiles = [f.path for f in apexInfo.payload_files.to_list()]
+  apex = minSdkVersion + "|" + ', '.join(payloadFiles)
+return containerFile + "|" + ', '.join(contents) + "|" + apex`
+}
+
+// noApexInfo is returned by the Starlark function of GetContainerInfo in place of the ApexInfo
+// fields of a target which does not provide ApexInfo.
+const noApexInfo = "NO_APEX_INFO"
+
+// ParseResult returns the container information of the target as a GetContainerInfo_Result. The
+// ApexInfo fields are only set if the target provides ApexInfo, with an empty slice of payload
+// files if the APEX has no payload.
+func (g getContainerInfoType) ParseResult(rawString string) interface{} {
+	splitString := strings.SplitN(rawString, "|", 4)
+	result := GetContainerInfo_Result{ContainerFile: splitString[0]}
+	if len(splitString) > 1 && splitString[1] != "" {
+		result.Contents = strings.Split(splitString[1], ", ")
+	}
+	if len(splitString) > 2 && splitString[2] != noApexInfo {
+		apexInfo := &ApexInfo{MinSdkVersion: splitString[2], PayloadFiles: []string{}}
+		if len(splitString) > 3 && splitString[3] != "" {
+			apexInfo.PayloadFiles = strings.Split(splitString[3], ", ")
+		}
+		result.ApexInfo = apexInfo
+	}
+	return result
+}
+
+type getCcIncludesType struct{}
+
+func (g getCcIncludesType) Name() string {
+	return "getCcIncludes"
+}
+
+func (g getCcIncludesType) StarlarkFunctionBody() string {
+	return `
+compilation_context = providers(target)["CcInfo"].compilation_context
+includes = compilation_context.includes.to_list()
+system_includes = compilation_context.system_includes.to_list()
+return ', '.join(includes) + "|" + ', '.join(system_includes)`
+}
+
+// ParseResult returns the include directories of the target as a GetCcIncludes_Result, with empty
+// slices if the target exports no include directories of a kind.
+func (g getCcIncludesType) ParseResult(rawString string) interface{} {
+	result := GetCcIncludes_Result{Includes: []string{}, SystemIncludes: []string{}}
+	splitString := strings.SplitN(rawString, "|", 2)
+	if splitString[0] != "" {
+		result.Includes = strings.Split(splitString[0], ", ")
+	}
+	if len(splitString) > 1 && splitString[1] != "" {
+		result.SystemIncludes = strings.Split(splitString[1], ", ")
+	}
+	return result
+}
+
+type getRunfilesType struct{}
+
+func (g getRunfilesType) Name() string {
+	return "getRunfiles"
+}
+
+func (g getRunfilesType) StarlarkFunctionBody() string {
+	return "return ', '.join([f.path for f in target[DefaultInfo].default_runfiles.files.to_list()])"
+}
+
+// ParseResult returns the execroot-relative paths of the runfiles of the target as a []string,
+// which is empty if the target has no runfiles.
+func (g getRunfilesType) ParseResult(rawString string) interface{} {
+	if rawString == "" {
+		return []string{}
+	}
+	return strings.Split(rawString, ", ")
+}
+
+type getGeneratedSourcesType struct{}
+
+func (g getGeneratedSourcesType) Name() string {
+	return "getGeneratedSources"
+}
+
+func (g getGeneratedSourcesType) StarlarkFunctionBody() string {
+	return "return ', '.join([f.path for f in target[DefaultInfo].files.to_list() if not f.is_source])"
+}
+
+// ParseResult returns the execroot-relative paths of the files generated by the target as a
+// []string, which is empty if the target generates no files. Checked-in files of the source tree,
+// which are not under bazel-out, are excluded.
+func (g getGeneratedSourcesType) ParseResult(rawString string) interface{} {
+	ret := []string{}
+	if rawString == "" {
+		return ret
+	}
+	for _, path := range strings.Split(rawString, ", ") {
+		if strings.HasPrefix(path, "bazel-out/") {
+			ret = append(ret, path)
+		}
+	}
+	return ret
+}
+
+type getExecutableType struct{}
+
+func (g getExecutableType) Name() string {
+	return "getExecutable"
+}
+
+func (g getExecutableType) StarlarkFunctionBody() string {
+	return `
+executable = target[DefaultInfo].files_to_run.executable
+if not executable:
+  return ""
+return executable.path`
+}
+
+// ParseResult returns the execroot-relative path of the executable of the target as a string,
+// which is empty if the target has no executable.
+func (g getExecutableType) ParseResult(rawString string) interface{} {
+	return rawString
+}
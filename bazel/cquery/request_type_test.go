@@ -0,0 +1,250 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cquery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetContainerInfoParseResults(t *testing.T) {
+	testCases := []struct {
+		description    string
+		input          string
+		expectedOutput GetContainerInfo_Result
+	}{
+		{
+			description: "container with contents",
+			input:       "bazel-out/foo.apex|bazel-out/lib64/libfoo.so, bazel-out/bin/foo",
+			expectedOutput: GetContainerInfo_Result{
+				ContainerFile: "bazel-out/foo.apex",
+				Contents:      []string{"bazel-out/lib64/libfoo.so", "bazel-out/bin/foo"},
+			},
+		},
+		{
+			description: "container without contents",
+			input:       "bazel-out/foo.apk|",
+			expectedOutput: GetContainerInfo_Result{
+				ContainerFile: "bazel-out/foo.apk",
+			},
+		},
+		{
+			description:    "container without files",
+			input:          "|",
+			expectedOutput: GetContainerInfo_Result{},
+		},
+		{
+			description: "container which is not an apex",
+			input:       "bazel-out/foo.apk||NO_APEX_INFO",
+			expectedOutput: GetContainerInfo_Result{
+				ContainerFile: "bazel-out/foo.apk",
+			},
+		},
+		{
+			description: "apex with min_sdk_version and payload",
+			input:       "bazel-out/foo.apex|bazel-out/lib64/libfoo.so|30|bazel-out/arm64/bin/lib64/libfoo.so, bazel-out/arm64/bin/bin/foo",
+			expectedOutput: GetContainerInfo_Result{
+				ContainerFile: "bazel-out/foo.apex",
+				Contents:      []string{"bazel-out/lib64/libfoo.so"},
+				ApexInfo: &ApexInfo{
+					MinSdkVersion: "30",
+					PayloadFiles:  []string{"bazel-out/arm64/bin/lib64/libfoo.so", "bazel-out/arm64/bin/bin/foo"},
+				},
+			},
+		},
+		{
+			description: "apex without min_sdk_version",
+			input:       "bazel-out/foo.apex|||bazel-out/arm64/bin/etc/foo.txt",
+			expectedOutput: GetContainerInfo_Result{
+				ContainerFile: "bazel-out/foo.apex",
+				ApexInfo: &ApexInfo{
+					PayloadFiles: []string{"bazel-out/arm64/bin/etc/foo.txt"},
+				},
+			},
+		},
+		{
+			description: "apex without payload",
+			input:       "bazel-out/foo.apex||current|",
+			expectedOutput: GetContainerInfo_Result{
+				ContainerFile: "bazel-out/foo.apex",
+				ApexInfo: &ApexInfo{
+					MinSdkVersion: "current",
+					PayloadFiles:  []string{},
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		actualOutput := GetContainerInfo.ParseResult(tc.input)
+		if !reflect.DeepEqual(tc.expectedOutput, actualOutput) {
+			t.Errorf("%s: expected %#v, got %#v", tc.description, tc.expectedOutput, actualOutput)
+		}
+	}
+}
+
+func TestGetCcObjectFilesParseResults(t *testing.T) {
+	testCases := []struct {
+		description    string
+		input          string
+		expectedOutput []string
+	}{
+		{
+			description:    "no object files",
+			input:          "",
+			expectedOutput: []string{},
+		},
+		{
+			description:    "multiple object files",
+			input:          "bazel-out/foo/a.o, bazel-out/foo/b.o",
+			expectedOutput: []string{"bazel-out/foo/a.o", "bazel-out/foo/b.o"},
+		},
+	}
+	for _, tc := range testCases {
+		actualOutput := GetCcObjectFiles.ParseResult(tc.input)
+		if !reflect.DeepEqual(tc.expectedOutput, actualOutput) {
+			t.Errorf("%s: expected %#v, got %#v", tc.description, tc.expectedOutput, actualOutput)
+		}
+	}
+}
+
+func TestGetCcIncludesParseResults(t *testing.T) {
+	testCases := []struct {
+		description    string
+		input          string
+		expectedOutput GetCcIncludes_Result
+	}{
+		{
+			description: "includes and system includes",
+			input:       "foo/include, bazel-out/android_arm64-fastbuild/bin/foo/include|bionic/libc/include",
+			expectedOutput: GetCcIncludes_Result{
+				Includes:       []string{"foo/include", "bazel-out/android_arm64-fastbuild/bin/foo/include"},
+				SystemIncludes: []string{"bionic/libc/include"},
+			},
+		},
+		{
+			description: "only system includes",
+			input:       "|bionic/libc/include, bionic/libc/kernel/uapi",
+			expectedOutput: GetCcIncludes_Result{
+				Includes:       []string{},
+				SystemIncludes: []string{"bionic/libc/include", "bionic/libc/kernel/uapi"},
+			},
+		},
+		{
+			description: "no includes",
+			input:       "|",
+			expectedOutput: GetCcIncludes_Result{
+				Includes:       []string{},
+				SystemIncludes: []string{},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		actualOutput := GetCcIncludes.ParseResult(tc.input)
+		if !reflect.DeepEqual(tc.expectedOutput, actualOutput) {
+			t.Errorf("%s: expected %#v, got %#v", tc.description, tc.expectedOutput, actualOutput)
+		}
+	}
+}
+
+func TestGetRunfilesParseResults(t *testing.T) {
+	testCases := []struct {
+		description    string
+		input          string
+		expectedOutput []string
+	}{
+		{
+			description:    "no runfiles",
+			input:          "",
+			expectedOutput: []string{},
+		},
+		{
+			description: "multiple runfiles",
+			input:       "bazel-out/k8-fastbuild/bin/tools/foo, tools/foo_data.txt",
+			expectedOutput: []string{
+				"bazel-out/k8-fastbuild/bin/tools/foo",
+				"tools/foo_data.txt",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		actualOutput := GetRunfiles.ParseResult(tc.input)
+		if !reflect.DeepEqual(tc.expectedOutput, actualOutput) {
+			t.Errorf("%s: expected %#v, got %#v", tc.description, tc.expectedOutput, actualOutput)
+		}
+	}
+}
+
+func TestGetGeneratedSourcesParseResults(t *testing.T) {
+	testCases := []struct {
+		description    string
+		input          string
+		expectedOutput []string
+	}{
+		{
+			description:    "no files",
+			input:          "",
+			expectedOutput: []string{},
+		},
+		{
+			description: "generated and source files",
+			input:       "bazel-out/k8-fastbuild/bin/gen/Foo.java, gen/Bar.java, bazel-out/k8-fastbuild/bin/gen/foo.cpp",
+			expectedOutput: []string{
+				"bazel-out/k8-fastbuild/bin/gen/Foo.java",
+				"bazel-out/k8-fastbuild/bin/gen/foo.cpp",
+			},
+		},
+		{
+			description:    "only source files",
+			input:          "gen/Bar.java",
+			expectedOutput: []string{},
+		},
+	}
+	for _, tc := range testCases {
+		actualOutput := GetGeneratedSources.ParseResult(tc.input)
+		if !reflect.DeepEqual(tc.expectedOutput, actualOutput) {
+			t.Errorf("%s: expected %#v, got %#v", tc.description, tc.expectedOutput, actualOutput)
+		}
+	}
+}
+
+func TestGetExecutableParseResults(t *testing.T) {
+	testCases := []struct {
+		description    string
+		input          string
+		expectedOutput string
+	}{
+		{
+			description:    "host binary",
+			input:          "bazel-out/k8-fastbuild/bin/tools/foo",
+			expectedOutput: "bazel-out/k8-fastbuild/bin/tools/foo",
+		},
+		{
+			description:    "executable in the source tree",
+			input:          "tools/foo.sh",
+			expectedOutput: "tools/foo.sh",
+		},
+		{
+			description:    "target without executable",
+			input:          "",
+			expectedOutput: "",
+		},
+	}
+	for _, tc := range testCases {
+		actualOutput := GetExecutable.ParseResult(tc.input)
+		if !reflect.DeepEqual(tc.expectedOutput, actualOutput) {
+			t.Errorf("%s: expected %#v, got %#v", tc.description, tc.expectedOutput, actualOutput)
+		}
+	}
+}
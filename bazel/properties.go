@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strings"
+	"sync"
 )
 
 // BazelTargetModuleProperties contain properties and metadata used for
@@ -28,11 +30,25 @@ type BazelTargetModuleProperties struct {
 
 	// The target label for the bzl file containing the definition of the rule class.
 	Bzl_load_location string `blueprint:"mutated"`
+
+	// The properties of the Soong module that were set but could not be converted to attributes
+	// of this target, e.g. "product_variables.platform_sdk_version.cflags".
+	Unconverted_properties []string `blueprint:"mutated"`
+
+	// The labels of the license targets applicable to this target, converted from the licenses
+	// property of the Soong module. Set automatically when the target is created.
+	Applicable_licenses LabelListAttribute
+
+	// The tags of this target, set by the converter, e.g. ["manual"] for targets that are known
+	// not to build and should be skipped by wildcard target patterns.
+	Tags StringListAttribute
 }
 
 const BazelTargetModuleNamePrefix = "__bp2build__"
 
-var productVariableSubstitutionPattern = regexp.MustCompile("%(d|s)")
+// Matches string substitution formatting, along with escaped percent signs which are not
+// substitutions.
+var productVariableSubstitutionPattern = regexp.MustCompile("%(%|d|s)")
 
 // Label is used to represent a Bazel compatible Label. Also stores the original bp text to support
 // string replacement.
@@ -53,18 +69,44 @@ func (ll *LabelList) Append(other LabelList) {
 		ll.Includes = append(ll.Includes, other.Includes...)
 	}
 	if len(ll.Excludes) > 0 || len(other.Excludes) > 0 {
-		ll.Excludes = append(other.Excludes, other.Excludes...)
+		ll.Excludes = append(ll.Excludes, other.Excludes...)
 	}
 }
 
-func UniqueBazelLabels(originalLabels []Label) []Label {
-	uniqueLabelsSet := make(map[Label]bool)
-	for _, l := range originalLabels {
-		uniqueLabelsSet[l] = true
+// Subtract returns the labels of ll that are not in other, matched by their Label string. The
+// Excludes of the result are the union of the Excludes of ll and other.
+func (ll LabelList) Subtract(other LabelList) LabelList {
+	otherIncludes := make(map[string]bool, len(other.Includes))
+	for _, l := range other.Includes {
+		otherIncludes[l.Label] = true
 	}
+	var result LabelList
+	for _, l := range ll.Includes {
+		if !otherIncludes[l.Label] {
+			result.Includes = append(result.Includes, l)
+		}
+	}
+	excludes := make(map[string]bool, len(ll.Excludes)+len(other.Excludes))
+	for _, l := range append(append([]Label(nil), ll.Excludes...), other.Excludes...) {
+		if !excludes[l.Label] {
+			excludes[l.Label] = true
+			result.Excludes = append(result.Excludes, l)
+		}
+	}
+	return result
+}
+
+// UniqueBazelLabels returns the given labels without duplicates, sorted by Label. Labels are
+// duplicates if their Label strings are equal, even if they were written differently in the
+// Android.bp file; the Bp_text of the first of them is kept.
+func UniqueBazelLabels(originalLabels []Label) []Label {
+	seenLabels := make(map[string]bool)
 	var uniqueLabels []Label
-	for l, _ := range uniqueLabelsSet {
-		uniqueLabels = append(uniqueLabels, l)
+	for _, l := range originalLabels {
+		if !seenLabels[l.Label] {
+			seenLabels[l.Label] = true
+			uniqueLabels = append(uniqueLabels, l)
+		}
 	}
 	sort.SliceStable(uniqueLabels, func(i, j int) bool {
 		return uniqueLabels[i].Label < uniqueLabels[j].Label
@@ -86,6 +128,14 @@ const (
 	ARCH_X86    = "x86"
 	ARCH_X86_64 = "x86_64"
 
+	// Architectures with a Bazel constraint value but no ArchType in arch.go yet. No Soong
+	// properties set values for them, so they only select the default value.
+	ARCH_RISCV64 = "riscv64"
+
+	// The arch-agnostic bucket of configurable values, which apply to every
+	// architecture and so are never generated as a select branch.
+	ARCH_COMMON = "common"
+
 	// OsType names in arch.go
 	OS_ANDROID      = "android"
 	OS_DARWIN       = "darwin"
@@ -100,7 +150,12 @@ var (
 	// constraint value equivalent. is actually android.ArchTypeList, but the
 	// android package depends on the bazel package, so a cyclic dependency
 	// prevents using that here.
-	selectableArchs = []string{ARCH_X86, ARCH_X86_64, ARCH_ARM, ARCH_ARM64}
+	allSelectableArchs = []string{ARCH_X86, ARCH_X86_64, ARCH_ARM, ARCH_ARM64, ARCH_RISCV64}
+
+	// The architectures currently considered for configurable attribute values, a subset of
+	// allSelectableArchs. See SetSelectableArchs.
+	selectableArchs     = allSelectableArchs
+	selectableArchsLock sync.RWMutex
 
 	// Likewise, this is the list of target operating systems.
 	selectableTargetOs = []string{
@@ -115,10 +170,11 @@ var (
 	// A map of architectures to the Bazel label of the constraint_value
 	// for the @platforms//cpu:cpu constraint_setting
 	PlatformArchMap = map[string]string{
-		ARCH_ARM:    "//build/bazel/platforms/arch:arm",
-		ARCH_ARM64:  "//build/bazel/platforms/arch:arm64",
-		ARCH_X86:    "//build/bazel/platforms/arch:x86",
-		ARCH_X86_64: "//build/bazel/platforms/arch:x86_64",
+		ARCH_ARM:     "//build/bazel/platforms/arch:arm",
+		ARCH_ARM64:   "//build/bazel/platforms/arch:arm64",
+		ARCH_RISCV64: "//build/bazel/platforms/arch:riscv64",
+		ARCH_X86:     "//build/bazel/platforms/arch:x86",
+		ARCH_X86_64:  "//build/bazel/platforms/arch:x86_64",
 	}
 
 	// A map of target operating systems to the Bazel label of the
@@ -133,14 +189,85 @@ var (
 	}
 )
 
+// ArchFeatureConfigSetting returns the label of the config_setting matching targets of the given
+// architecture that support an arch feature, e.g. //build/bazel/platforms/arch/variants:x86_avx2.
+func ArchFeatureConfigSetting(arch, feature string) string {
+	return fmt.Sprintf("//build/bazel/platforms/arch/variants:%s_%s", arch, feature)
+}
+
+// ProductVariableConfigSetting returns the label of the config_setting matching builds in which the
+// given product variable is set, e.g. //build/bazel/product_variables:debuggable.
+func ProductVariableConfigSetting(productVariable string) string {
+	return "//build/bazel/product_variables:" + strings.ToLower(productVariable)
+}
+
+// MinSdkVersionConfigSetting returns the label of the config_setting matching targets built with a
+// min_sdk_version of at least the given API level, e.g.
+// //build/bazel/rules/apex:min_sdk_version_at_least_30.
+func MinSdkVersionConfigSetting(apiLevel int) string {
+	return fmt.Sprintf("//build/bazel/rules/apex:min_sdk_version_at_least_%d", apiLevel)
+}
+
+const (
+	// The partition images a module can be built for. Modules are built for the core image unless
+	// they are made available to other images, e.g. with vendor_available.
+	IMAGE_CORE           = "core"
+	IMAGE_VENDOR         = "vendor"
+	IMAGE_PRODUCT        = "product"
+	IMAGE_RAMDISK        = "ramdisk"
+	IMAGE_VENDOR_RAMDISK = "vendor_ramdisk"
+	IMAGE_RECOVERY       = "recovery"
+
+	// The package of the constraint_setting selecting the image a target is built for, and of its
+	// constraint_values. Unlike the arch and os constraints, these are generated by bp2build.
+	ImageConstraintPackage = "build/bazel/platforms/image"
+)
+
+// Images is the list of partition images, each with a constraint_value in ImageConstraintPackage.
+var Images = []string{
+	IMAGE_CORE,
+	IMAGE_VENDOR,
+	IMAGE_PRODUCT,
+	IMAGE_RAMDISK,
+	IMAGE_VENDOR_RAMDISK,
+	IMAGE_RECOVERY,
+}
+
+// ImageConstraintValue returns the label of the constraint_value of an image, e.g.
+// //build/bazel/platforms/image:vendor.
+func ImageConstraintValue(image string) string {
+	return "//" + ImageConstraintPackage + ":" + image
+}
+
+// PlatformLabel returns the label of the Bazel platform for the given target operating system
+// and architecture, e.g. //build/bazel/platforms:android_arm64. An error is returned if either
+// the os or the arch has no Bazel constraint value equivalent.
+func PlatformLabel(os, arch string) (string, error) {
+	osConstraint, ok := PlatformOsMap[os]
+	if !ok {
+		return "", fmt.Errorf("Unknown os: %s", os)
+	}
+	if _, ok := PlatformArchMap[arch]; !ok {
+		return "", fmt.Errorf("Unknown arch: %s", arch)
+	}
+	// The platform is named after the os constraint value, e.g. linux rather than linux_glibc.
+	osName := osConstraint[strings.LastIndex(osConstraint, ":")+1:]
+	return fmt.Sprintf("//build/bazel/platforms:%s_%s", osName, arch), nil
+}
+
 // Arch-specific label_list typed Bazel attribute values. This should correspond
 // to the types of architectures supported for compilation in arch.go.
 type labelListArchValues struct {
-	X86    LabelList
-	X86_64 LabelList
-	Arm    LabelList
-	Arm64  LabelList
-	Common LabelList
+	X86     LabelList
+	X86_64  LabelList
+	Arm     LabelList
+	Arm64   LabelList
+	Riscv64 LabelList
+	Common  LabelList
+
+	// The value for architectures without an arch-specific value, i.e. the
+	// //conditions:default branch of the arch select.
+	ConditionsDefault LabelList
 }
 
 type labelListOsValues struct {
@@ -150,6 +277,10 @@ type labelListOsValues struct {
 	Linux       LabelList
 	LinuxBionic LabelList
 	Windows     LabelList
+
+	// The value for operating systems without an os-specific value, i.e. the
+	// //conditions:default branch of the os select.
+	ConditionsDefault LabelList
 }
 
 // LabelListAttribute is used to represent a list of Bazel labels as an
@@ -167,6 +298,30 @@ type LabelListAttribute struct {
 	// are generated in a select statement and appended to the non-os specific
 	// label list Value.
 	OsValues labelListOsValues
+
+	// The attribute label list values specific to a combination of arch and
+	// os, keyed by the label of the platform of the combination. Optional. If
+	// used, these are generated in a single select statement, as a target is
+	// built for one platform at a time, and appended to the label list Value.
+	ArchOsValues map[string]LabelList
+
+	// The arch feature-specific attribute label list values, keyed by the
+	// config_setting of the feature. Optional. If used, each of these is
+	// generated in its own select statement, as multiple features may be
+	// supported at once, and appended to the label list Value.
+	ArchFeatureValues map[string]LabelList
+
+	// The product variable-specific attribute label list values, keyed by the
+	// config_setting of the product variable. Optional. If used, each of these
+	// is generated in its own select statement, as multiple product variables
+	// may be set at once, and appended to the label list Value.
+	ProductVariableValues map[string]LabelList
+
+	// The min_sdk_version-specific attribute label list values, keyed by the
+	// config_setting of the minimum API level they apply to. Optional. If used,
+	// each of these is generated in its own select statement, as the API level
+	// ranges overlap, and appended to the label list Value.
+	MinSdkVersionValues map[string]LabelList
 }
 
 // MakeLabelListAttribute initializes a LabelListAttribute with the non-arch specific value.
@@ -174,10 +329,36 @@ func MakeLabelListAttribute(value LabelList) LabelListAttribute {
 	return LabelListAttribute{Value: UniqueBazelLabelList(value)}
 }
 
+// SelectableArchs returns the architectures currently considered for configurable attribute
+// values.
+func SelectableArchs() []string {
+	selectableArchsLock.RLock()
+	defer selectableArchsLock.RUnlock()
+	return append([]string(nil), selectableArchs...)
+}
+
+// SetSelectableArchs restricts the architectures considered for configurable attribute values,
+// e.g. for products which only build a subset of them. Values for other architectures are ignored
+// and no select branches are generated for them. A nil archs restores the default of all
+// architectures.
+func SetSelectableArchs(archs []string) {
+	if archs == nil {
+		archs = allSelectableArchs
+	}
+	for _, arch := range archs {
+		if _, ok := PlatformArchMap[arch]; !ok {
+			panic(fmt.Errorf("Unknown arch: %s", arch))
+		}
+	}
+	selectableArchsLock.Lock()
+	defer selectableArchsLock.Unlock()
+	selectableArchs = append([]string(nil), archs...)
+}
+
 // HasArchSpecificValues returns true if the attribute contains
 // architecture-specific label_list values.
 func (attrs *LabelListAttribute) HasConfigurableValues() bool {
-	for _, arch := range selectableArchs {
+	for _, arch := range SelectableArchs() {
 		if len(attrs.GetValueForArch(arch).Includes) > 0 {
 			return true
 		}
@@ -188,15 +369,38 @@ func (attrs *LabelListAttribute) HasConfigurableValues() bool {
 			return true
 		}
 	}
-	return false
+	for _, value := range attrs.ArchOsValues {
+		if len(value.Includes) > 0 {
+			return true
+		}
+	}
+	for _, value := range attrs.ArchFeatureValues {
+		if len(value.Includes) > 0 {
+			return true
+		}
+	}
+	for _, value := range attrs.ProductVariableValues {
+		if len(value.Includes) > 0 {
+			return true
+		}
+	}
+	for _, value := range attrs.MinSdkVersionValues {
+		if len(value.Includes) > 0 {
+			return true
+		}
+	}
+	return len(attrs.ArchValues.ConditionsDefault.Includes) > 0 ||
+		len(attrs.OsValues.ConditionsDefault.Includes) > 0
 }
 
 func (attrs *LabelListAttribute) archValuePtrs() map[string]*LabelList {
 	return map[string]*LabelList{
-		ARCH_X86:    &attrs.ArchValues.X86,
-		ARCH_X86_64: &attrs.ArchValues.X86_64,
-		ARCH_ARM:    &attrs.ArchValues.Arm,
-		ARCH_ARM64:  &attrs.ArchValues.Arm64,
+		ARCH_X86:     &attrs.ArchValues.X86,
+		ARCH_X86_64:  &attrs.ArchValues.X86_64,
+		ARCH_ARM:     &attrs.ArchValues.Arm,
+		ARCH_ARM64:   &attrs.ArchValues.Arm64,
+		ARCH_RISCV64: &attrs.ArchValues.Riscv64,
+		ARCH_COMMON:  &attrs.ArchValues.Common,
 	}
 }
 
@@ -218,6 +422,154 @@ func (attrs *LabelListAttribute) SetValueForArch(arch string, value LabelList) {
 	*v = value
 }
 
+// FactorCommonArchValues moves the labels included in the values of all the selectable
+// architectures the attribute has values for into the common arch value, leaving only the
+// differences in the arch-specific values. For example, device modules built for both arm and
+// arm64 often share most of their arm and arm64 values; the shared labels are then no longer
+// repeated in both branches of the generated arch select. Architectures without values, e.g.
+// those the module is never built for, are ignored. Labels missing from the value of any of the
+// other architectures are left as they are.
+func (attrs *LabelListAttribute) FactorCommonArchValues() {
+	var archs []string
+	for _, arch := range SelectableArchs() {
+		if len(attrs.GetValueForArch(arch).Includes) > 0 {
+			archs = append(archs, arch)
+		}
+	}
+	if len(archs) < 2 {
+		return
+	}
+
+	isCommon := map[string]bool{}
+	var common []Label
+	for _, label := range attrs.GetValueForArch(archs[0]).Includes {
+		inAllArchs := true
+		for _, arch := range archs[1:] {
+			if !labelListIncludes(attrs.GetValueForArch(arch), label) {
+				inAllArchs = false
+				break
+			}
+		}
+		if inAllArchs && !isCommon[label.Label] {
+			isCommon[label.Label] = true
+			common = append(common, label)
+		}
+	}
+	if len(common) == 0 {
+		return
+	}
+
+	for _, arch := range archs {
+		value := attrs.GetValueForArch(arch)
+		var remaining []Label
+		for _, label := range value.Includes {
+			if !isCommon[label.Label] {
+				remaining = append(remaining, label)
+			}
+		}
+		value.Includes = remaining
+		attrs.SetValueForArch(arch, value)
+	}
+	commonValue := attrs.GetValueForArch(ARCH_COMMON)
+	commonValue.Includes = append(append([]Label(nil), commonValue.Includes...), common...)
+	attrs.SetValueForArch(ARCH_COMMON, commonValue)
+}
+
+// labelListIncludes returns true if the includes of ll contain a label with the same label string
+// as label.
+func labelListIncludes(ll LabelList, label Label) bool {
+	for _, l := range ll.Includes {
+		if l.Label == label.Label {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveExcludes applies the excludes of the arch-specific and os-specific values to the labels
+// of the base value and the common arch value, which apply to every architecture and operating
+// system. The branches of a select can only add labels to the base value, so the labels excluded
+// by any architecture are moved from the base value into the values of the other architectures
+// with labels of their own and into the //conditions:default branch. Architectures excluding them
+// keep the remaining moved labels, and get an explicit value even if it is empty so that they
+// don't fall through to the //conditions:default branch. The same is then done for the os-specific
+// values. Labels moved into the arch-specific values can't also be excluded by an os.
+func (attrs *LabelListAttribute) ResolveExcludes() {
+	bases := []*[]Label{&attrs.Value.Includes, &attrs.ArchValues.Common.Includes}
+
+	var archValues []*LabelList
+	for _, arch := range SelectableArchs() {
+		archValues = append(archValues, attrs.archValuePtrs()[arch])
+	}
+	resolveExcludes(bases, archValues, &attrs.ArchValues.ConditionsDefault)
+
+	var osValues []*LabelList
+	for _, os := range selectableTargetOs {
+		osValues = append(osValues, attrs.osValuePtrs()[os])
+	}
+	resolveExcludes(bases, osValues, &attrs.OsValues.ConditionsDefault)
+}
+
+// resolveExcludes moves the labels of bases excluded by any of values into the values including
+// labels or excluding any of them, and into conditionsDefault. New slices are assigned rather than
+// modifying existing ones, which may be shared with copies of the attribute.
+func resolveExcludes(bases []*[]Label, values []*LabelList, conditionsDefault *LabelList) {
+	excluded := map[string]bool{}
+	for _, value := range values {
+		for _, l := range value.Excludes {
+			excluded[l.Label] = true
+		}
+	}
+	if len(excluded) == 0 {
+		return
+	}
+
+	var moved []Label
+	for _, base := range bases {
+		var kept []Label
+		for _, l := range *base {
+			if excluded[l.Label] {
+				moved = append(moved, l)
+			} else {
+				kept = append(kept, l)
+			}
+		}
+		*base = kept
+	}
+	if len(moved) == 0 {
+		return
+	}
+
+	for _, value := range values {
+		var remaining []Label
+		excludesMoved := false
+		for _, l := range moved {
+			if labelListExcludes(*value, l) {
+				excludesMoved = true
+			} else {
+				remaining = append(remaining, l)
+			}
+		}
+		if len(value.Includes) == 0 && !excludesMoved {
+			// The //conditions:default branch applies.
+			continue
+		}
+		value.Includes = append(append([]Label{}, value.Includes...), remaining...)
+	}
+	conditionsDefault.Includes = append(append([]Label(nil), conditionsDefault.Includes...), moved...)
+}
+
+// labelListExcludes returns true if the excludes of ll contain a label with the same label string
+// as label.
+func labelListExcludes(ll LabelList, label Label) bool {
+	for _, l := range ll.Excludes {
+		if l.Label == label.Label {
+			return true
+		}
+	}
+	return false
+}
+
 func (attrs *LabelListAttribute) osValuePtrs() map[string]*LabelList {
 	return map[string]*LabelList{
 		OS_ANDROID:      &attrs.OsValues.Android,
@@ -247,6 +599,160 @@ func (attrs *LabelListAttribute) SetValueForOS(os string, value LabelList) {
 	*v = value
 }
 
+// MapLabels returns a copy of the attribute with f applied to each label included or excluded by
+// its base value and by every configurable value.
+func (attrs LabelListAttribute) MapLabels(f func(Label) Label) LabelListAttribute {
+	mapLabelList := func(ll LabelList) LabelList {
+		var ret LabelList
+		for _, l := range ll.Includes {
+			ret.Includes = append(ret.Includes, f(l))
+		}
+		for _, l := range ll.Excludes {
+			ret.Excludes = append(ret.Excludes, f(l))
+		}
+		return ret
+	}
+	mapLabelLists := func(values map[string]LabelList) map[string]LabelList {
+		if values == nil {
+			return nil
+		}
+		ret := make(map[string]LabelList, len(values))
+		for k, v := range values {
+			ret[k] = mapLabelList(v)
+		}
+		return ret
+	}
+
+	ret := attrs
+	ret.Value = mapLabelList(attrs.Value)
+	for _, v := range ret.archValuePtrs() {
+		*v = mapLabelList(*v)
+	}
+	ret.ArchValues.ConditionsDefault = mapLabelList(attrs.ArchValues.ConditionsDefault)
+	for _, v := range ret.osValuePtrs() {
+		*v = mapLabelList(*v)
+	}
+	ret.OsValues.ConditionsDefault = mapLabelList(attrs.OsValues.ConditionsDefault)
+	ret.ArchOsValues = mapLabelLists(attrs.ArchOsValues)
+	ret.ArchFeatureValues = mapLabelLists(attrs.ArchFeatureValues)
+	ret.ProductVariableValues = mapLabelLists(attrs.ProductVariableValues)
+	ret.MinSdkVersionValues = mapLabelLists(attrs.MinSdkVersionValues)
+	return ret
+}
+
+// AllLabels returns the sorted, de-duplicated union of the labels included in the base value and
+// in every configurable value of the attribute.
+func (attrs LabelListAttribute) AllLabels() []Label {
+	labels := append([]Label(nil), attrs.Value.Includes...)
+	for _, v := range attrs.archValuePtrs() {
+		labels = append(labels, v.Includes...)
+	}
+	for _, v := range attrs.osValuePtrs() {
+		labels = append(labels, v.Includes...)
+	}
+	for _, v := range attrs.ArchOsValues {
+		labels = append(labels, v.Includes...)
+	}
+	for _, v := range attrs.ArchFeatureValues {
+		labels = append(labels, v.Includes...)
+	}
+	for _, v := range attrs.ProductVariableValues {
+		labels = append(labels, v.Includes...)
+	}
+	for _, v := range attrs.MinSdkVersionValues {
+		labels = append(labels, v.Includes...)
+	}
+	labels = append(labels, attrs.ArchValues.ConditionsDefault.Includes...)
+	labels = append(labels, attrs.OsValues.ConditionsDefault.Includes...)
+	return UniqueBazelLabels(labels)
+}
+
+// GetValueForArchOS returns the label_list attribute value for the combination of an architecture
+// and an OS target.
+func (attrs *LabelListAttribute) GetValueForArchOS(arch, os string) LabelList {
+	platform, err := PlatformLabel(os, arch)
+	if err != nil {
+		panic(err)
+	}
+	return attrs.ArchOsValues[platform]
+}
+
+// SetValueForArchOS sets the label_list attribute value for the combination of an architecture and
+// an OS target, e.g. for arm64 Android only.
+func (attrs *LabelListAttribute) SetValueForArchOS(arch, os string, value LabelList) {
+	platform, err := PlatformLabel(os, arch)
+	if err != nil {
+		panic(err)
+	}
+	if attrs.ArchOsValues == nil {
+		attrs.ArchOsValues = map[string]LabelList{}
+	}
+	attrs.ArchOsValues[platform] = value
+}
+
+// GetValueForArchFeature returns the label_list attribute value for an arch feature.
+func (attrs *LabelListAttribute) GetValueForArchFeature(arch, feature string) LabelList {
+	return attrs.ArchFeatureValues[ArchFeatureConfigSetting(arch, feature)]
+}
+
+// SetValueForArchFeature sets the label_list attribute value for an arch feature.
+func (attrs *LabelListAttribute) SetValueForArchFeature(arch, feature string, value LabelList) {
+	if _, ok := PlatformArchMap[arch]; !ok {
+		panic(fmt.Errorf("Unknown arch: %s", arch))
+	}
+	if attrs.ArchFeatureValues == nil {
+		attrs.ArchFeatureValues = map[string]LabelList{}
+	}
+	attrs.ArchFeatureValues[ArchFeatureConfigSetting(arch, feature)] = value
+}
+
+// BoolAttribute corresponds to the bool Bazel attribute type. Unlike a plain bool, it
+// distinguishes an unset value, which is omitted from the generated target, from false.
+type BoolAttribute struct {
+	// The value of the bool attribute, or nil if it is unset.
+	Value *bool
+}
+
+// GetValueForProductVariable returns the label_list attribute value for builds in which the given
+// product variable is set.
+func (attrs *LabelListAttribute) GetValueForProductVariable(productVariable string) LabelList {
+	return attrs.ProductVariableValues[ProductVariableConfigSetting(productVariable)]
+}
+
+// SetValueForProductVariable sets the label_list attribute value for builds in which the given
+// product variable is set, e.g. for product_variables.debuggable.srcs.
+func (attrs *LabelListAttribute) SetValueForProductVariable(productVariable string, value LabelList) {
+	if attrs.ProductVariableValues == nil {
+		attrs.ProductVariableValues = map[string]LabelList{}
+	}
+	attrs.ProductVariableValues[ProductVariableConfigSetting(productVariable)] = value
+}
+
+// GetValueForMinSdkVersion returns the label_list attribute value for builds with a
+// min_sdk_version of at least the given API level.
+func (attrs *LabelListAttribute) GetValueForMinSdkVersion(apiLevel int) LabelList {
+	return attrs.MinSdkVersionValues[MinSdkVersionConfigSetting(apiLevel)]
+}
+
+// SetValueForMinSdkVersion sets the label_list attribute value for builds with a min_sdk_version
+// of at least the given API level.
+func (attrs *LabelListAttribute) SetValueForMinSdkVersion(apiLevel int, value LabelList) {
+	if attrs.MinSdkVersionValues == nil {
+		attrs.MinSdkVersionValues = map[string]LabelList{}
+	}
+	attrs.MinSdkVersionValues[MinSdkVersionConfigSetting(apiLevel)] = value
+}
+
+// BoolAttributeFromProp converts a tri-state Soong bool property to a BoolAttribute, preserving
+// whether the property was set.
+func BoolAttributeFromProp(prop *bool) BoolAttribute {
+	if prop == nil {
+		return BoolAttribute{}
+	}
+	value := *prop
+	return BoolAttribute{Value: &value}
+}
+
 // StringListAttribute corresponds to the string_list Bazel attribute type with
 // support for additional metadata, like configurations.
 type StringListAttribute struct {
@@ -255,35 +761,61 @@ type StringListAttribute struct {
 
 	// Optional additive set of list values to the base value.
 	ArchValues stringListArchValues
+
+	// The os-specific attribute string list values. Optional. If used, these
+	// are generated in a select statement and appended to the non-os specific
+	// string list Value.
+	OsValues stringListOsValues
 }
 
 // Arch-specific string_list typed Bazel attribute values. This should correspond
 // to the types of architectures supported for compilation in arch.go.
 type stringListArchValues struct {
-	X86    []string
-	X86_64 []string
-	Arm    []string
-	Arm64  []string
-	Common []string
+	X86     []string
+	X86_64  []string
+	Arm     []string
+	Arm64   []string
+	Riscv64 []string
+	Common  []string
+
+	// The value for architectures without an arch-specific value, i.e. the
+	// //conditions:default branch of the arch select.
+	ConditionsDefault []string
+}
+
+type stringListOsValues struct {
+	Android     []string
+	Darwin      []string
+	Fuchsia     []string
+	Linux       []string
+	LinuxBionic []string
+	Windows     []string
 }
 
 // HasConfigurableValues returns true if the attribute contains
-// architecture-specific string_list values.
+// architecture-specific or os-specific string_list values.
 func (attrs *StringListAttribute) HasConfigurableValues() bool {
-	for _, arch := range selectableArchs {
+	for _, arch := range SelectableArchs() {
 		if len(attrs.GetValueForArch(arch)) > 0 {
 			return true
 		}
 	}
-	return false
+	for _, os := range selectableTargetOs {
+		if len(attrs.GetValueForOS(os)) > 0 {
+			return true
+		}
+	}
+	return len(attrs.ArchValues.ConditionsDefault) > 0
 }
 
 func (attrs *StringListAttribute) archValuePtrs() map[string]*[]string {
 	return map[string]*[]string{
-		ARCH_X86:    &attrs.ArchValues.X86,
-		ARCH_X86_64: &attrs.ArchValues.X86_64,
-		ARCH_ARM:    &attrs.ArchValues.Arm,
-		ARCH_ARM64:  &attrs.ArchValues.Arm64,
+		ARCH_X86:     &attrs.ArchValues.X86,
+		ARCH_X86_64:  &attrs.ArchValues.X86_64,
+		ARCH_ARM:     &attrs.ArchValues.Arm,
+		ARCH_ARM64:   &attrs.ArchValues.Arm64,
+		ARCH_RISCV64: &attrs.ArchValues.Riscv64,
+		ARCH_COMMON:  &attrs.ArchValues.Common,
 	}
 }
 
@@ -305,6 +837,268 @@ func (attrs *StringListAttribute) SetValueForArch(arch string, value []string) {
 	*v = value
 }
 
+func (attrs *StringListAttribute) osValuePtrs() map[string]*[]string {
+	return map[string]*[]string{
+		OS_ANDROID:      &attrs.OsValues.Android,
+		OS_DARWIN:       &attrs.OsValues.Darwin,
+		OS_FUCHSIA:      &attrs.OsValues.Fuchsia,
+		OS_LINUX:        &attrs.OsValues.Linux,
+		OS_LINUX_BIONIC: &attrs.OsValues.LinuxBionic,
+		OS_WINDOWS:      &attrs.OsValues.Windows,
+	}
+}
+
+// GetValueForOS returns the string_list attribute value for an OS target.
+func (attrs *StringListAttribute) GetValueForOS(os string) []string {
+	var v *[]string
+	if v = attrs.osValuePtrs()[os]; v == nil {
+		panic(fmt.Errorf("Unknown os: %s", os))
+	}
+	return *v
+}
+
+// SetValueForOS sets the string_list attribute value for an OS target.
+func (attrs *StringListAttribute) SetValueForOS(os string, value []string) {
+	var v *[]string
+	if v = attrs.osValuePtrs()[os]; v == nil {
+		panic(fmt.Errorf("Unknown os: %s", os))
+	}
+	*v = value
+}
+
+// Append appends the base value and the configurable values of other to those of the attribute.
+func (attrs *StringListAttribute) Append(other StringListAttribute) {
+	attrs.Value = append(attrs.Value, other.Value...)
+	otherArchValues := other.archValuePtrs()
+	for arch, value := range attrs.archValuePtrs() {
+		*value = append(*value, *otherArchValues[arch]...)
+	}
+	otherOsValues := other.osValuePtrs()
+	for os, value := range attrs.osValuePtrs() {
+		*value = append(*value, *otherOsValues[os]...)
+	}
+	attrs.ArchValues.ConditionsDefault = append(attrs.ArchValues.ConditionsDefault, other.ArchValues.ConditionsDefault...)
+}
+
+// StringAttribute corresponds to the string Bazel attribute type with
+// support for additional metadata, like configurations.
+type StringAttribute struct {
+	// The base value of the string attribute.
+	Value string
+
+	// The arch-specific attribute string values. Optional. If used, the attribute
+	// is generated as a select statement with the base Value as the default.
+	ArchValues stringArchValues
+
+	// The os-specific attribute string values. Optional. If used, the attribute
+	// is generated as a select statement with the base Value as the default.
+	OsValues stringOsValues
+}
+
+// Arch-specific string typed Bazel attribute values. This should correspond
+// to the types of architectures supported for compilation in arch.go.
+type stringArchValues struct {
+	X86     string
+	X86_64  string
+	Arm     string
+	Arm64   string
+	Riscv64 string
+	Common  string
+}
+
+type stringOsValues struct {
+	Android     string
+	Darwin      string
+	Fuchsia     string
+	Linux       string
+	LinuxBionic string
+	Windows     string
+}
+
+// HasConfigurableValues returns true if the attribute contains
+// architecture-specific or os-specific string values.
+func (attrs *StringAttribute) HasConfigurableValues() bool {
+	for _, arch := range SelectableArchs() {
+		if attrs.GetValueForArch(arch) != "" {
+			return true
+		}
+	}
+	for _, os := range selectableTargetOs {
+		if attrs.GetValueForOS(os) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (attrs *StringAttribute) archValuePtrs() map[string]*string {
+	return map[string]*string{
+		ARCH_X86:     &attrs.ArchValues.X86,
+		ARCH_X86_64:  &attrs.ArchValues.X86_64,
+		ARCH_ARM:     &attrs.ArchValues.Arm,
+		ARCH_ARM64:   &attrs.ArchValues.Arm64,
+		ARCH_RISCV64: &attrs.ArchValues.Riscv64,
+		ARCH_COMMON:  &attrs.ArchValues.Common,
+	}
+}
+
+// GetValueForArch returns the string attribute value for an architecture.
+func (attrs *StringAttribute) GetValueForArch(arch string) string {
+	var v *string
+	if v = attrs.archValuePtrs()[arch]; v == nil {
+		panic(fmt.Errorf("Unknown arch: %s", arch))
+	}
+	return *v
+}
+
+// SetValueForArch sets the string attribute value for an architecture.
+func (attrs *StringAttribute) SetValueForArch(arch string, value string) {
+	var v *string
+	if v = attrs.archValuePtrs()[arch]; v == nil {
+		panic(fmt.Errorf("Unknown arch: %s", arch))
+	}
+	*v = value
+}
+
+func (attrs *StringAttribute) osValuePtrs() map[string]*string {
+	return map[string]*string{
+		OS_ANDROID:      &attrs.OsValues.Android,
+		OS_DARWIN:       &attrs.OsValues.Darwin,
+		OS_FUCHSIA:      &attrs.OsValues.Fuchsia,
+		OS_LINUX:        &attrs.OsValues.Linux,
+		OS_LINUX_BIONIC: &attrs.OsValues.LinuxBionic,
+		OS_WINDOWS:      &attrs.OsValues.Windows,
+	}
+}
+
+// GetValueForOS returns the string attribute value for an OS target.
+func (attrs *StringAttribute) GetValueForOS(os string) string {
+	var v *string
+	if v = attrs.osValuePtrs()[os]; v == nil {
+		panic(fmt.Errorf("Unknown os: %s", os))
+	}
+	return *v
+}
+
+// SetValueForOS sets the string attribute value for an OS target.
+func (attrs *StringAttribute) SetValueForOS(os string, value string) {
+	var v *string
+	if v = attrs.osValuePtrs()[os]; v == nil {
+		panic(fmt.Errorf("Unknown os: %s", os))
+	}
+	*v = value
+}
+
+// LabelAttribute is used to represent a single Bazel label as an attribute, with
+// support for additional metadata, like configurations. A Label with an empty
+// label string is unset.
+type LabelAttribute struct {
+	// The base value of the label attribute.
+	Value Label
+
+	// The arch-specific attribute label values. Optional. If used, the attribute
+	// is generated as a select statement with the base Value as the default.
+	ArchValues labelArchValues
+
+	// The os-specific attribute label values. Optional. If used, the attribute
+	// is generated as a select statement with the base Value as the default.
+	OsValues labelOsValues
+}
+
+// Arch-specific label typed Bazel attribute values. This should correspond
+// to the types of architectures supported for compilation in arch.go.
+type labelArchValues struct {
+	X86     Label
+	X86_64  Label
+	Arm     Label
+	Arm64   Label
+	Riscv64 Label
+	Common  Label
+}
+
+type labelOsValues struct {
+	Android     Label
+	Darwin      Label
+	Fuchsia     Label
+	Linux       Label
+	LinuxBionic Label
+	Windows     Label
+}
+
+// HasConfigurableValues returns true if the attribute contains
+// architecture-specific or os-specific label values.
+func (attrs *LabelAttribute) HasConfigurableValues() bool {
+	for _, arch := range SelectableArchs() {
+		if attrs.GetValueForArch(arch).Label != "" {
+			return true
+		}
+	}
+	for _, os := range selectableTargetOs {
+		if attrs.GetValueForOS(os).Label != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (attrs *LabelAttribute) archValuePtrs() map[string]*Label {
+	return map[string]*Label{
+		ARCH_X86:     &attrs.ArchValues.X86,
+		ARCH_X86_64:  &attrs.ArchValues.X86_64,
+		ARCH_ARM:     &attrs.ArchValues.Arm,
+		ARCH_ARM64:   &attrs.ArchValues.Arm64,
+		ARCH_RISCV64: &attrs.ArchValues.Riscv64,
+		ARCH_COMMON:  &attrs.ArchValues.Common,
+	}
+}
+
+// GetValueForArch returns the label attribute value for an architecture.
+func (attrs *LabelAttribute) GetValueForArch(arch string) Label {
+	var v *Label
+	if v = attrs.archValuePtrs()[arch]; v == nil {
+		panic(fmt.Errorf("Unknown arch: %s", arch))
+	}
+	return *v
+}
+
+// SetValueForArch sets the label attribute value for an architecture.
+func (attrs *LabelAttribute) SetValueForArch(arch string, value Label) {
+	var v *Label
+	if v = attrs.archValuePtrs()[arch]; v == nil {
+		panic(fmt.Errorf("Unknown arch: %s", arch))
+	}
+	*v = value
+}
+
+func (attrs *LabelAttribute) osValuePtrs() map[string]*Label {
+	return map[string]*Label{
+		OS_ANDROID:      &attrs.OsValues.Android,
+		OS_DARWIN:       &attrs.OsValues.Darwin,
+		OS_FUCHSIA:      &attrs.OsValues.Fuchsia,
+		OS_LINUX:        &attrs.OsValues.Linux,
+		OS_LINUX_BIONIC: &attrs.OsValues.LinuxBionic,
+		OS_WINDOWS:      &attrs.OsValues.Windows,
+	}
+}
+
+// GetValueForOS returns the label attribute value for an OS target.
+func (attrs *LabelAttribute) GetValueForOS(os string) Label {
+	var v *Label
+	if v = attrs.osValuePtrs()[os]; v == nil {
+		panic(fmt.Errorf("Unknown os: %s", os))
+	}
+	return *v
+}
+
+// SetValueForOS sets the label attribute value for an OS target.
+func (attrs *LabelAttribute) SetValueForOS(os string, value Label) {
+	var v *Label
+	if v = attrs.osValuePtrs()[os]; v == nil {
+		panic(fmt.Errorf("Unknown os: %s", os))
+	}
+	*v = value
+}
+
 // TryVariableSubstitution, replace string substitution formatting within each string in slice with
 // Starlark string.format compatible tag for productVariable.
 func TryVariableSubstitutions(slice []string, productVariable string) ([]string, bool) {
@@ -319,8 +1113,47 @@ func TryVariableSubstitutions(slice []string, productVariable string) ([]string,
 }
 
 // TryVariableSubstitution, replace string substitution formatting within s with Starlark
-// string.format compatible tag for productVariable.
+// string.format compatible tag for productVariable. An escaped "%%" is a literal "%" rather than a
+// substitution.
 func TryVariableSubstitution(s string, productVariable string) (string, bool) {
-	sub := productVariableSubstitutionPattern.ReplaceAllString(s, "{"+productVariable+"}")
-	return sub, s != sub
+	sub, substitutions := substituteProductVariables(s, func(int) string { return productVariable })
+	if substitutions == 0 {
+		return s, false
+	}
+	return sub, true
+}
+
+// TryVariableSubstitutionList, replace each string substitution formatting within s with the
+// Starlark string.format compatible tag for the product variable at the same position in
+// productVariables, e.g. "-DFOO=%s -DBAR=%d" with ["foo", "bar"] becomes
+// "-DFOO={foo} -DBAR={bar}". The variables are given as a list rather than a map, as they are
+// matched to the substitutions by position. If the number of substitutions does not match the
+// number of variables, s is returned unchanged.
+func TryVariableSubstitutionList(s string, productVariables []string) (string, bool) {
+	sub, substitutions := substituteProductVariables(s, func(i int) string {
+		if i < len(productVariables) {
+			return productVariables[i]
+		}
+		return ""
+	})
+	if substitutions == 0 || substitutions != len(productVariables) {
+		return s, false
+	}
+	return sub, true
+}
+
+// substituteProductVariables replaces the i-th string substitution formatting within s with the
+// Starlark string.format compatible tag for productVariable(i), and each escaped "%%" with "%".
+// Returns the resulting string and the number of substitutions.
+func substituteProductVariables(s string, productVariable func(i int) string) (string, int) {
+	substitutions := 0
+	sub := productVariableSubstitutionPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if match == "%%" {
+			return "%"
+		}
+		tag := "{" + productVariable(substitutions) + "}"
+		substitutions++
+		return tag
+	})
+	return sub, substitutions
 }
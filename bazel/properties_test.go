@@ -37,6 +37,17 @@ func TestUniqueBazelLabels(t *testing.T) {
 				{Label: "c"},
 			},
 		},
+		{
+			originalLabels: []Label{
+				{Label: ":libfoo", Bp_text: "libfoo"},
+				{Label: ":libbar", Bp_text: "libbar"},
+				{Label: ":libfoo", Bp_text: ":libfoo"},
+			},
+			expectedUniqueLabels: []Label{
+				{Label: ":libbar", Bp_text: "libbar"},
+				{Label: ":libfoo", Bp_text: "libfoo"},
+			},
+		},
 	}
 	for _, tc := range testCases {
 		actualUniqueLabels := UniqueBazelLabels(tc.originalLabels)
@@ -87,3 +98,642 @@ func TestUniqueBazelLabelList(t *testing.T) {
 		}
 	}
 }
+
+func TestLabelListAppend(t *testing.T) {
+	testCases := []struct {
+		description string
+		labelList   LabelList
+		other       LabelList
+		expected    LabelList
+	}{
+		{
+			description: "both empty",
+		},
+		{
+			description: "empty receiver",
+			other: LabelList{
+				Includes: []Label{{Label: "a"}},
+				Excludes: []Label{{Label: "x"}},
+			},
+			expected: LabelList{
+				Includes: []Label{{Label: "a"}},
+				Excludes: []Label{{Label: "x"}},
+			},
+		},
+		{
+			description: "empty other",
+			labelList: LabelList{
+				Includes: []Label{{Label: "a"}},
+				Excludes: []Label{{Label: "x"}},
+			},
+			expected: LabelList{
+				Includes: []Label{{Label: "a"}},
+				Excludes: []Label{{Label: "x"}},
+			},
+		},
+		{
+			description: "both non-empty",
+			labelList: LabelList{
+				Includes: []Label{{Label: "a"}},
+				Excludes: []Label{{Label: "x"}, {Label: "y"}},
+			},
+			other: LabelList{
+				Includes: []Label{{Label: "b"}, {Label: "c"}},
+				Excludes: []Label{{Label: "z"}},
+			},
+			expected: LabelList{
+				Includes: []Label{{Label: "a"}, {Label: "b"}, {Label: "c"}},
+				Excludes: []Label{{Label: "x"}, {Label: "y"}, {Label: "z"}},
+			},
+		},
+		{
+			description: "only excludes",
+			labelList: LabelList{
+				Excludes: []Label{{Label: "x"}},
+			},
+			other: LabelList{
+				Excludes: []Label{{Label: "y"}},
+			},
+			expected: LabelList{
+				Excludes: []Label{{Label: "x"}, {Label: "y"}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		actual := tc.labelList
+		actual.Append(tc.other)
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("%s: expected %v, got %v", tc.description, tc.expected, actual)
+		}
+	}
+}
+
+func TestLabelListSubtract(t *testing.T) {
+	testCases := []struct {
+		description string
+		labelList   LabelList
+		other       LabelList
+		expected    LabelList
+	}{
+		{
+			description: "both empty",
+		},
+		{
+			description: "empty other",
+			labelList: LabelList{
+				Includes: []Label{{Label: "a"}, {Label: "b"}},
+				Excludes: []Label{{Label: "x"}},
+			},
+			expected: LabelList{
+				Includes: []Label{{Label: "a"}, {Label: "b"}},
+				Excludes: []Label{{Label: "x"}},
+			},
+		},
+		{
+			description: "empty receiver",
+			other: LabelList{
+				Includes: []Label{{Label: "a"}},
+				Excludes: []Label{{Label: "x"}},
+			},
+			expected: LabelList{
+				Excludes: []Label{{Label: "x"}},
+			},
+		},
+		{
+			description: "disjoint",
+			labelList: LabelList{
+				Includes: []Label{{Label: "a"}, {Label: "b"}},
+			},
+			other: LabelList{
+				Includes: []Label{{Label: "c"}},
+			},
+			expected: LabelList{
+				Includes: []Label{{Label: "a"}, {Label: "b"}},
+			},
+		},
+		{
+			description: "overlapping",
+			labelList: LabelList{
+				Includes: []Label{{Label: "a"}, {Label: "b", Bp_text: "b"}, {Label: "c"}},
+				Excludes: []Label{{Label: "x"}, {Label: "y"}},
+			},
+			other: LabelList{
+				Includes: []Label{{Label: "b"}, {Label: "d"}},
+				Excludes: []Label{{Label: "y"}, {Label: "z"}},
+			},
+			expected: LabelList{
+				Includes: []Label{{Label: "a"}, {Label: "c"}},
+				Excludes: []Label{{Label: "x"}, {Label: "y"}, {Label: "z"}},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		actual := tc.labelList.Subtract(tc.other)
+		if !reflect.DeepEqual(tc.expected, actual) {
+			t.Errorf("%s: expected %v, got %v", tc.description, tc.expected, actual)
+		}
+	}
+}
+
+func TestPlatformLabel(t *testing.T) {
+	testCases := []struct {
+		os            string
+		arch          string
+		expectedLabel string
+		expectedErr   string
+	}{
+		{
+			os:            OS_ANDROID,
+			arch:          ARCH_ARM64,
+			expectedLabel: "//build/bazel/platforms:android_arm64",
+		},
+		{
+			os:            OS_ANDROID,
+			arch:          ARCH_RISCV64,
+			expectedLabel: "//build/bazel/platforms:android_riscv64",
+		},
+		{
+			os:            OS_LINUX,
+			arch:          ARCH_X86_64,
+			expectedLabel: "//build/bazel/platforms:linux_x86_64",
+		},
+		{
+			os:            OS_LINUX_BIONIC,
+			arch:          ARCH_X86,
+			expectedLabel: "//build/bazel/platforms:linux_bionic_x86",
+		},
+		{
+			os:          "plan9",
+			arch:        ARCH_ARM,
+			expectedErr: "Unknown os: plan9",
+		},
+		{
+			os:          OS_ANDROID,
+			arch:        "mips",
+			expectedErr: "Unknown arch: mips",
+		},
+	}
+	for _, tc := range testCases {
+		label, err := PlatformLabel(tc.os, tc.arch)
+		if tc.expectedErr != "" {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("Expected error %q for (%s, %s), got %v", tc.expectedErr, tc.os, tc.arch, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for (%s, %s): %s", tc.os, tc.arch, err)
+		} else if label != tc.expectedLabel {
+			t.Errorf("Expected %q for (%s, %s), got %q", tc.expectedLabel, tc.os, tc.arch, label)
+		}
+	}
+}
+
+func TestBoolAttributeFromProp(t *testing.T) {
+	trueValue := true
+	falseValue := false
+	testCases := []struct {
+		prop     *bool
+		expected *bool
+	}{
+		{prop: nil, expected: nil},
+		{prop: &trueValue, expected: &trueValue},
+		{prop: &falseValue, expected: &falseValue},
+	}
+	for _, tc := range testCases {
+		attr := BoolAttributeFromProp(tc.prop)
+		if !reflect.DeepEqual(tc.expected, attr.Value) {
+			t.Errorf("Expected %v, got %v", tc.expected, attr.Value)
+		}
+		if tc.prop != nil && attr.Value == tc.prop {
+			t.Errorf("Expected BoolAttributeFromProp to copy the property value")
+		}
+	}
+}
+
+func TestLabelListAttributeAllLabels(t *testing.T) {
+	attr := MakeLabelListAttribute(LabelList{Includes: []Label{{Label: ":b"}, {Label: ":a"}}})
+	attr.SetValueForArch(ARCH_ARM, LabelList{Includes: []Label{{Label: ":arm"}, {Label: ":a"}}})
+	attr.SetValueForArch(ARCH_X86, LabelList{Includes: []Label{{Label: ":x86"}}})
+	attr.SetValueForOS(OS_ANDROID, LabelList{Includes: []Label{{Label: ":android"}, {Label: ":arm"}}})
+
+	expected := []Label{
+		{Label: ":a"},
+		{Label: ":android"},
+		{Label: ":arm"},
+		{Label: ":b"},
+		{Label: ":x86"},
+	}
+	if actual := attr.AllLabels(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
+
+func TestLabelListAttributeMapLabels(t *testing.T) {
+	attr := MakeLabelListAttribute(LabelList{Includes: []Label{{Label: ":a"}}, Excludes: []Label{{Label: ":b"}}})
+	attr.SetValueForArch(ARCH_ARM, LabelList{Includes: []Label{{Label: ":arm"}}})
+	attr.SetValueForOS(OS_ANDROID, LabelList{Includes: []Label{{Label: ":android"}}})
+	attr.SetValueForArchOS(ARCH_ARM64, OS_ANDROID, LabelList{Includes: []Label{{Label: ":android_arm64"}}})
+
+	mapped := attr.MapLabels(func(l Label) Label {
+		return Label{Label: l.Label + "_vendor"}
+	})
+	if expected, actual := (LabelList{
+		Includes: []Label{{Label: ":a_vendor"}},
+		Excludes: []Label{{Label: ":b_vendor"}},
+	}), mapped.Value; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected base value %v, got %v", expected, actual)
+	}
+	if expected, actual := []Label{{Label: ":arm_vendor"}}, mapped.GetValueForArch(ARCH_ARM).Includes; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected arm value %v, got %v", expected, actual)
+	}
+	if expected, actual := []Label{{Label: ":android_vendor"}}, mapped.GetValueForOS(OS_ANDROID).Includes; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected android value %v, got %v", expected, actual)
+	}
+	if expected, actual := []Label{{Label: ":android_arm64_vendor"}}, mapped.GetValueForArchOS(ARCH_ARM64, OS_ANDROID).Includes; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected android_arm64 value %v, got %v", expected, actual)
+	}
+	if expected, actual := []Label{{Label: ":arm"}}, attr.GetValueForArch(ARCH_ARM).Includes; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected the original attribute to be unchanged, got arm value %v", actual)
+	}
+}
+
+func TestLabelAttributeHasConfigurableValues(t *testing.T) {
+	var attr LabelAttribute
+	if attr.HasConfigurableValues() {
+		t.Errorf("Expected an unset label attribute to have no configurable values")
+	}
+	attr.Value = Label{Label: ":base"}
+	if attr.HasConfigurableValues() {
+		t.Errorf("Expected a label attribute with only a base value to have no configurable values")
+	}
+	attr.SetValueForArch(ARCH_ARM, Label{Label: ":arm"})
+	if !attr.HasConfigurableValues() {
+		t.Errorf("Expected a label attribute with an arch value to have configurable values")
+	}
+	if g, w := attr.GetValueForArch(ARCH_ARM), (Label{Label: ":arm"}); g != w {
+		t.Errorf("Expected arm value %v, got %v", w, g)
+	}
+}
+
+func TestStringListAttributeHasConfigurableOsValues(t *testing.T) {
+	attr := StringListAttribute{Value: []string{"-base"}}
+	if attr.HasConfigurableValues() {
+		t.Errorf("Expected a string list attribute with only a base value to have no configurable values")
+	}
+	attr.SetValueForOS(OS_DARWIN, []string{"-darwin"})
+	if !attr.HasConfigurableValues() {
+		t.Errorf("Expected a string list attribute with an os value to have configurable values")
+	}
+	if g, w := attr.GetValueForOS(OS_DARWIN), []string{"-darwin"}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected darwin value %q, got %q", w, g)
+	}
+}
+
+func TestStringListAttributeAppend(t *testing.T) {
+	attr := StringListAttribute{Value: []string{"-a"}}
+	attr.SetValueForArch(ARCH_ARM, []string{"-arm"})
+
+	other := StringListAttribute{Value: []string{"-b"}}
+	other.SetValueForArch(ARCH_ARM, []string{"-other_arm"})
+	other.SetValueForOS(OS_ANDROID, []string{"-android"})
+	other.ArchValues.ConditionsDefault = []string{"-default"}
+
+	attr.Append(other)
+	if g, w := attr.Value, []string{"-a", "-b"}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected value %q, got %q", w, g)
+	}
+	if g, w := attr.GetValueForArch(ARCH_ARM), []string{"-arm", "-other_arm"}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected arm value %q, got %q", w, g)
+	}
+	if g, w := attr.GetValueForOS(OS_ANDROID), []string{"-android"}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected android value %q, got %q", w, g)
+	}
+	if g, w := attr.ArchValues.ConditionsDefault, []string{"-default"}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected default value %q, got %q", w, g)
+	}
+}
+
+func TestCommonArchValues(t *testing.T) {
+	var labels LabelListAttribute
+	labels.SetValueForArch(ARCH_COMMON, LabelList{Includes: []Label{{Label: ":common"}}})
+	if g, w := labels.GetValueForArch(ARCH_COMMON), (LabelList{Includes: []Label{{Label: ":common"}}}); !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected common label list %v, got %v", w, g)
+	}
+	if labels.HasConfigurableValues() {
+		t.Errorf("Expected common label list values not to be configurable")
+	}
+	if g, w := labels.AllLabels(), []Label{{Label: ":common"}}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected all labels %v, got %v", w, g)
+	}
+
+	var flags StringListAttribute
+	flags.SetValueForArch(ARCH_COMMON, []string{"-common"})
+	if g, w := flags.GetValueForArch(ARCH_COMMON), []string{"-common"}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected common string list %q, got %q", w, g)
+	}
+	if flags.HasConfigurableValues() {
+		t.Errorf("Expected common string list values not to be configurable")
+	}
+}
+
+func TestSelectableArchsAreCopied(t *testing.T) {
+	archs := []string{ARCH_ARM, ARCH_ARM64}
+	SetSelectableArchs(archs)
+	defer SetSelectableArchs(nil)
+
+	archs[0] = ARCH_X86
+	got := SelectableArchs()
+	if expected := []string{ARCH_ARM, ARCH_ARM64}; !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected selectable archs %v, got %v", expected, got)
+	}
+	got[0] = ARCH_X86
+	if g := SelectableArchs()[0]; g != ARCH_ARM {
+		t.Errorf("Expected first selectable arch %q, got %q", ARCH_ARM, g)
+	}
+}
+
+func TestFactorCommonArchValues(t *testing.T) {
+	labels := func(names ...string) LabelList {
+		var ll LabelList
+		for _, name := range names {
+			ll.Includes = append(ll.Includes, Label{Label: name})
+		}
+		return ll
+	}
+
+	SetSelectableArchs([]string{ARCH_ARM, ARCH_ARM64})
+	defer SetSelectableArchs(nil)
+
+	testCases := []struct {
+		description    string
+		arm, arm64     LabelList
+		expectedCommon LabelList
+		expectedArm    LabelList
+		expectedArm64  LabelList
+	}{
+		{
+			description:    "identical",
+			arm:            labels(":a", ":b"),
+			arm64:          labels(":a", ":b"),
+			expectedCommon: labels(":a", ":b"),
+		},
+		{
+			description:    "divergent",
+			arm:            labels(":a", ":arm", ":b"),
+			arm64:          labels(":b", ":arm64", ":a"),
+			expectedCommon: labels(":a", ":b"),
+			expectedArm:    labels(":arm"),
+			expectedArm64:  labels(":arm64"),
+		},
+		{
+			description:   "disjoint",
+			arm:           labels(":arm"),
+			arm64:         labels(":arm64"),
+			expectedArm:   labels(":arm"),
+			expectedArm64: labels(":arm64"),
+		},
+	}
+	for _, tc := range testCases {
+		var attr LabelListAttribute
+		attr.SetValueForArch(ARCH_ARM, tc.arm)
+		attr.SetValueForArch(ARCH_ARM64, tc.arm64)
+		attr.FactorCommonArchValues()
+		if g, w := attr.GetValueForArch(ARCH_COMMON), tc.expectedCommon; !reflect.DeepEqual(w, g) {
+			t.Errorf("%s: expected common value %v, got %v", tc.description, w, g)
+		}
+		if g, w := attr.GetValueForArch(ARCH_ARM), tc.expectedArm; !reflect.DeepEqual(w, g) {
+			t.Errorf("%s: expected arm value %v, got %v", tc.description, w, g)
+		}
+		if g, w := attr.GetValueForArch(ARCH_ARM64), tc.expectedArm64; !reflect.DeepEqual(w, g) {
+			t.Errorf("%s: expected arm64 value %v, got %v", tc.description, w, g)
+		}
+	}
+}
+
+func TestFactorCommonArchValuesKeepsValuesMissingFromAnArch(t *testing.T) {
+	var attr LabelListAttribute
+	shared := LabelList{Includes: []Label{{Label: ":shared"}}}
+	attr.SetValueForArch(ARCH_ARM, shared)
+	attr.SetValueForArch(ARCH_ARM64, shared)
+	attr.SetValueForArch(ARCH_X86, LabelList{Includes: []Label{{Label: ":x86"}}})
+
+	// The x86 values don't include :shared, so it must not be moved to the common value, which
+	// applies to x86 too.
+	attr.FactorCommonArchValues()
+	if g := attr.GetValueForArch(ARCH_COMMON); len(g.Includes) != 0 {
+		t.Errorf("Expected no common value, got %v", g)
+	}
+	if g := attr.GetValueForArch(ARCH_ARM); !reflect.DeepEqual(shared, g) {
+		t.Errorf("Expected arm value %v, got %v", shared, g)
+	}
+}
+
+func TestFactorCommonArchValuesIgnoresArchsWithoutValues(t *testing.T) {
+	var attr LabelListAttribute
+	attr.SetValueForArch(ARCH_ARM, LabelList{Includes: []Label{{Label: ":shared"}, {Label: ":arm"}}})
+	attr.SetValueForArch(ARCH_ARM64, LabelList{Includes: []Label{{Label: ":shared"}}})
+
+	// None of x86, x86_64 and riscv64 have values, so only the arm and arm64 values are compared.
+	attr.FactorCommonArchValues()
+	if g, w := attr.GetValueForArch(ARCH_COMMON), (LabelList{Includes: []Label{{Label: ":shared"}}}); !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected common value %v, got %v", w, g)
+	}
+	if g, w := attr.GetValueForArch(ARCH_ARM), (LabelList{Includes: []Label{{Label: ":arm"}}}); !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected arm value %v, got %v", w, g)
+	}
+	if g := attr.GetValueForArch(ARCH_ARM64); len(g.Includes) != 0 {
+		t.Errorf("Expected no arm64 value, got %v", g)
+	}
+}
+
+func TestResolveExcludes(t *testing.T) {
+	SetSelectableArchs([]string{ARCH_ARM, ARCH_ARM64, ARCH_X86})
+	defer SetSelectableArchs(nil)
+
+	attr := MakeLabelListAttribute(LabelList{Includes: []Label{{Label: "a.c"}, {Label: "b.c"}, {Label: "c.c"}}})
+	attr.SetValueForArch(ARCH_ARM, LabelList{
+		Includes: []Label{{Label: "arm.c"}},
+		Excludes: []Label{{Label: "b.c"}},
+	})
+	attr.SetValueForArch(ARCH_ARM64, LabelList{
+		Includes: []Label{{Label: "arm64.c"}},
+	})
+	attr.SetValueForArch(ARCH_X86, LabelList{
+		Excludes: []Label{{Label: "c.c"}},
+	})
+	original := attr.Value.Includes
+
+	attr.ResolveExcludes()
+
+	if w, g := []Label{{Label: "a.c"}}, attr.Value.Includes; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected base value %v, got %v", w, g)
+	}
+	if w, g := []Label{{Label: "arm.c"}, {Label: "c.c"}}, attr.GetValueForArch(ARCH_ARM).Includes; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected arm value %v, got %v", w, g)
+	}
+	if w, g := []Label{{Label: "arm64.c"}, {Label: "b.c"}, {Label: "c.c"}}, attr.GetValueForArch(ARCH_ARM64).Includes; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected arm64 value %v, got %v", w, g)
+	}
+	if w, g := []Label{{Label: "b.c"}}, attr.GetValueForArch(ARCH_X86).Includes; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected x86 value %v, got %v", w, g)
+	}
+	if w, g := []Label{{Label: "b.c"}, {Label: "c.c"}}, attr.ArchValues.ConditionsDefault.Includes; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected //conditions:default value %v, got %v", w, g)
+	}
+	if w := []Label{{Label: "a.c"}, {Label: "b.c"}, {Label: "c.c"}}; !reflect.DeepEqual(w, original) {
+		t.Errorf("Expected the original base value to be unmodified, got %v", original)
+	}
+}
+
+func TestResolveExcludesWithoutExcludedBaseLabels(t *testing.T) {
+	attr := MakeLabelListAttribute(LabelList{Includes: []Label{{Label: "a.c"}}})
+	arm := LabelList{
+		Includes: []Label{{Label: "arm.c"}},
+		Excludes: []Label{{Label: "arm_only.c"}},
+	}
+	attr.SetValueForArch(ARCH_ARM, arm)
+
+	attr.ResolveExcludes()
+
+	if w, g := []Label{{Label: "a.c"}}, attr.Value.Includes; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected base value %v, got %v", w, g)
+	}
+	if g := attr.GetValueForArch(ARCH_ARM); !reflect.DeepEqual(arm, g) {
+		t.Errorf("Expected arm value %v, got %v", arm, g)
+	}
+	if g := attr.ArchValues.ConditionsDefault; len(g.Includes) != 0 {
+		t.Errorf("Expected no //conditions:default value, got %v", g)
+	}
+}
+
+func TestTryVariableSubstitutionList(t *testing.T) {
+	testCases := []struct {
+		description      string
+		input            string
+		productVariables []string
+		expectedOutput   string
+		expectedChanged  bool
+	}{
+		{
+			description:     "no substitutions",
+			input:           "-DFOO",
+			expectedOutput:  "-DFOO",
+			expectedChanged: false,
+		},
+		{
+			description:      "one substitution",
+			input:            "-DFOO=%d",
+			productVariables: []string{"foo"},
+			expectedOutput:   "-DFOO={foo}",
+			expectedChanged:  true,
+		},
+		{
+			description:      "multiple substitutions",
+			input:            "-DFOO=%s -DBAR=%d",
+			productVariables: []string{"foo", "bar"},
+			expectedOutput:   "-DFOO={foo} -DBAR={bar}",
+			expectedChanged:  true,
+		},
+		{
+			description:      "fewer variables than substitutions",
+			input:            "-DFOO=%s -DBAR=%d",
+			productVariables: []string{"foo"},
+			expectedOutput:   "-DFOO=%s -DBAR=%d",
+			expectedChanged:  false,
+		},
+		{
+			description:      "more variables than substitutions",
+			input:            "-DFOO",
+			productVariables: []string{"foo"},
+			expectedOutput:   "-DFOO",
+			expectedChanged:  false,
+		},
+	}
+	for _, tc := range testCases {
+		output, changed := TryVariableSubstitutionList(tc.input, tc.productVariables)
+		if output != tc.expectedOutput || changed != tc.expectedChanged {
+			t.Errorf("%s: expected (%q, %t), got (%q, %t)", tc.description, tc.expectedOutput, tc.expectedChanged, output, changed)
+		}
+	}
+}
+
+func TestTryVariableSubstitutionEscapedPercent(t *testing.T) {
+	testCases := []struct {
+		description     string
+		input           string
+		expectedOutput  string
+		expectedChanged bool
+	}{
+		{
+			description:     "escaped percent only",
+			input:           "-DPERCENT=100%%",
+			expectedOutput:  "-DPERCENT=100%%",
+			expectedChanged: false,
+		},
+		{
+			description:     "escaped specifier",
+			input:           "-DFORMAT=%%s",
+			expectedOutput:  "-DFORMAT=%%s",
+			expectedChanged: false,
+		},
+		{
+			description:     "real and escaped specifiers",
+			input:           "-DFOO=%s -DFORMAT=%%d",
+			expectedOutput:  "-DFOO={foo} -DFORMAT=%d",
+			expectedChanged: true,
+		},
+		{
+			description:     "escaped percent before a real specifier",
+			input:           "-DFOO=%%%d",
+			expectedOutput:  "-DFOO=%{foo}",
+			expectedChanged: true,
+		},
+	}
+	for _, tc := range testCases {
+		output, changed := TryVariableSubstitution(tc.input, "foo")
+		if output != tc.expectedOutput || changed != tc.expectedChanged {
+			t.Errorf("%s: expected (%q, %t), got (%q, %t)", tc.description, tc.expectedOutput, tc.expectedChanged, output, changed)
+		}
+	}
+
+	output, changed := TryVariableSubstitutionList("-DFOO=%s -DFORMAT=%%s -DBAR=%d", []string{"foo", "bar"})
+	if w := "-DFOO={foo} -DFORMAT=%s -DBAR={bar}"; output != w || !changed {
+		t.Errorf("expected (%q, true), got (%q, %t)", w, output, changed)
+	}
+}
+
+func TestLabelListAttributeArchOsValues(t *testing.T) {
+	var attr LabelListAttribute
+	attr.SetValueForArchOS(ARCH_ARM64, OS_ANDROID, LabelList{Includes: []Label{{Label: ":android_arm64"}}})
+	if !attr.HasConfigurableValues() {
+		t.Errorf("Expected a label list attribute with an arch and os value to have configurable values")
+	}
+	if g, w := attr.ArchOsValues, map[string]LabelList{
+		"//build/bazel/platforms:android_arm64": {Includes: []Label{{Label: ":android_arm64"}}},
+	}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected arch and os values %v, got %v", w, g)
+	}
+	if g, w := attr.GetValueForArchOS(ARCH_ARM64, OS_ANDROID), (LabelList{Includes: []Label{{Label: ":android_arm64"}}}); !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected android_arm64 value %v, got %v", w, g)
+	}
+	if g, w := attr.AllLabels(), []Label{{Label: ":android_arm64"}}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected all labels %v, got %v", w, g)
+	}
+}
+
+func TestLabelListAttributeProductVariableValues(t *testing.T) {
+	var attr LabelListAttribute
+	attr.SetValueForProductVariable("Debuggable", LabelList{Includes: []Label{{Label: "debug.c"}}})
+	if !attr.HasConfigurableValues() {
+		t.Errorf("Expected a label list attribute with a product variable value to have configurable values")
+	}
+	if g, w := attr.ProductVariableValues, map[string]LabelList{
+		"//build/bazel/product_variables:debuggable": {Includes: []Label{{Label: "debug.c"}}},
+	}; !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected product variable values %v, got %v", w, g)
+	}
+	if g, w := attr.GetValueForProductVariable("Debuggable"), (LabelList{Includes: []Label{{Label: "debug.c"}}}); !reflect.DeepEqual(w, g) {
+		t.Errorf("Expected debuggable value %v, got %v", w, g)
+	}
+}
@@ -16,6 +16,8 @@ package bp2build
 
 import (
 	"android/soong/android"
+	"android/soong/bazel"
+	"bufio"
 	"fmt"
 	"os"
 	"strings"
@@ -23,20 +25,26 @@ import (
 
 // Codegen is the backend of bp2build. The code generator is responsible for
 // writing .bzl files that are equivalent to Android.bp files that are capable
-// of being built with Bazel.
-func Codegen(ctx *CodegenContext) CodegenMetrics {
+// of being built with Bazel. An error is returned if the conversion fails or
+// the generated files can't be written.
+func Codegen(ctx *CodegenContext) (CodegenMetrics, error) {
 	outputDir := android.PathForOutput(ctx, "bp2build")
 	android.RemoveAllOutputDir(outputDir)
 
-	buildToTargets, metrics := GenerateBazelTargets(ctx)
-
-	filesToWrite := CreateBazelFiles(nil, buildToTargets, ctx.mode)
+	buildToTargets, metrics, err := GenerateBazelTargets(ctx)
+	if err != nil {
+		return metrics, err
+	}
+	buildToTargets[bazel.ImageConstraintPackage] = append(buildToTargets[bazel.ImageConstraintPackage],
+		imageConstraintTargets()...)
 
+	// BUILD files are streamed to disk target by target rather than created in memory with
+	// CreateBazelFiles, as the BUILD files of some packages are very large.
 	generatedBuildFiles := []string{}
-	for _, f := range filesToWrite {
-		p := getOrCreateOutputDir(outputDir, ctx, f.Dir).Join(ctx, f.Basename)
-		if err := writeFile(ctx, p, f.Contents); err != nil {
-			fmt.Errorf("Failed to write %q (dir %q) due to %q", f.Basename, f.Dir, err)
+	for _, dir := range android.SortedStringKeys(buildToTargets) {
+		p := getOrCreateOutputDir(outputDir, ctx, dir).Join(ctx, GeneratedBuildFileName)
+		if err := streamBuildFile(p, buildToTargets[dir], ctx.mode); err != nil {
+			return metrics, fmt.Errorf("Failed to write %q (dir %q) due to %q", GeneratedBuildFileName, dir, err)
 		}
 		// if these generated files are modified, regenerate on next run.
 		generatedBuildFiles = append(generatedBuildFiles, p.String())
@@ -45,10 +53,35 @@ func Codegen(ctx *CodegenContext) CodegenMetrics {
 	// The MANIFEST file contains the full list of files generated by bp2build, excluding itself.
 	// Its purpose is for downstream tools to understand the set of files converted by bp2build.
 	manifestFile := outputDir.Join(ctx, "MANIFEST")
-	writeFile(ctx, manifestFile, strings.Join(generatedBuildFiles, "\n"))
+	if err := writeFile(ctx, manifestFile, strings.Join(generatedBuildFiles, "\n")); err != nil {
+		return metrics, fmt.Errorf("Failed to write %q due to %q", manifestFile, err)
+	}
 	generatedBuildFiles = append(generatedBuildFiles, manifestFile.String())
 
-	return metrics
+	return metrics, nil
+}
+
+// imageConstraintTargets returns the constraint_setting selecting the partition image a target is
+// built for, and a constraint_value for each image. The image variant targets of modules are
+// compatible with the constraint_value of their image.
+func imageConstraintTargets() BazelTargets {
+	targets := BazelTargets{{
+		name: "image",
+		content: fmt.Sprintf(`constraint_setting(
+    name = "image",
+    default_constraint_value = ":%s",
+)`, bazel.IMAGE_CORE),
+	}}
+	for _, image := range bazel.Images {
+		targets = append(targets, BazelTarget{
+			name: image,
+			content: fmt.Sprintf(`constraint_value(
+    name = "%s",
+    constraint_setting = ":image",
+)`, image),
+		})
+	}
+	return targets
 }
 
 // Get the output directory and create it if it doesn't exist.
@@ -63,3 +96,22 @@ func writeFile(ctx android.PathContext, pathToFile android.OutputPath, content s
 	// in the source tree.
 	return android.WriteFileToOutputDir(pathToFile, []byte(content), 0644)
 }
+
+// streamBuildFile writes the BUILD file for targets to pathToFile without holding its full
+// contents in memory.
+func streamBuildFile(pathToFile android.OutputPath, targets BazelTargets, mode CodegenMode) error {
+	f, err := android.CreateFileInOutputDir(pathToFile, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := writeBuildFile(w, targets, mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
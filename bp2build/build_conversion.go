@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"text/scanner"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/proptools"
@@ -34,6 +35,10 @@ type BazelTarget struct {
 	content         string
 	ruleClass       string
 	bzlLoadLocation string
+
+	// Whether this is the definition of a variable holding an attribute value hoisted out of the
+	// targets of the package, which must precede the targets using it.
+	hoisted bool
 }
 
 // IsLoadedFromStarlark determines if the BazelTarget's rule class is loaded from a .bzl file,
@@ -59,6 +64,29 @@ func (targets BazelTargets) String() string {
 	return res
 }
 
+// Merge returns the targets of both BazelTargets, with the targets of other that are identical to
+// a target of the same name in targets included only once. Returns an error if both define a
+// target of the same name differently.
+func (targets BazelTargets) Merge(other BazelTargets) (BazelTargets, error) {
+	merged := append(BazelTargets(nil), targets...)
+	byName := make(map[string]BazelTarget, len(targets))
+	for _, target := range targets {
+		byName[target.name] = target
+	}
+	for _, target := range other {
+		if existing, exists := byName[target.name]; exists {
+			if existing != target {
+				return nil, fmt.Errorf("conflicting definitions of Bazel target %q:\n%s\n%s",
+					target.name, existing.content, target.content)
+			}
+			continue
+		}
+		byName[target.name] = target
+		merged = append(merged, target)
+	}
+	return merged, nil
+}
+
 // LoadStatements return the string representation of the sorted and deduplicated
 // Starlark rule load statements needed by a group of BazelTargets.
 func (targets BazelTargets) LoadStatements() string {
@@ -93,6 +121,7 @@ type bpToBuildContext interface {
 	ModuleDir(module blueprint.Module) string
 	ModuleSubDir(module blueprint.Module) string
 	ModuleType(module blueprint.Module) string
+	BlueprintFile(module blueprint.Module) string
 
 	VisitAllModules(visit func(blueprint.Module))
 	VisitDirectDeps(module blueprint.Module, visit func(blueprint.Module))
@@ -103,12 +132,67 @@ type CodegenContext struct {
 	context        android.Context
 	mode           CodegenMode
 	additionalDeps []string
+
+	// Whether a module property that could not be converted fails the conversion, instead of
+	// being dropped from the generated target.
+	strict bool
+
+	// Whether each generated target is preceded by a comment with the position of the module it
+	// was generated from in its Android.bp file.
+	annotateSourcePositions bool
+
+	// The string used for each level of indentation in generated targets, or "" for the default of
+	// four spaces.
+	indent string
+
+	// Whether list attribute values shared by many generated targets of a package are factored
+	// out into variables.
+	hoistCommonAttributes bool
+
+	// Whether generated targets are formatted the way buildifier formats them.
+	buildifierFormatting bool
+
+	// The positions of the module definitions of each Android.bp file read to annotate targets
+	// with their source positions, keyed by Android.bp file and then by module name.
+	blueprintModulePositions map[string]map[string]scanner.Position
 }
 
 func (c *CodegenContext) Mode() CodegenMode {
 	return c.mode
 }
 
+// SetStrict sets whether conversion fails on any property a converter could not convert, rather
+// than silently dropping it from the generated BUILD files.
+func (c *CodegenContext) SetStrict(strict bool) {
+	c.strict = strict
+}
+
+// SetIndentation sets the indentation of generated targets to width repetitions of unit per
+// level, e.g. ("\t", 1) or (" ", 2), to match the formatter configuration of the workspace.
+func (c *CodegenContext) SetIndentation(unit string, width int) {
+	c.indent = strings.Repeat(unit, width)
+}
+
+// SetHoistCommonAttributes sets whether list attribute values shared by at least
+// minTargetsForHoisting generated targets of a package, e.g. a common set of deps, are factored out
+// into a variable defined at the top of the BUILD file.
+func (c *CodegenContext) SetHoistCommonAttributes(hoist bool) {
+	c.hoistCommonAttributes = hoist
+}
+
+// SetBuildifierFormatting sets whether generated targets are formatted the way buildifier formats
+// BUILD files, e.g. ordering their attributes by buildifier's priorities rather than
+// alphabetically, so that running buildifier over the generated files does not change them.
+func (c *CodegenContext) SetBuildifierFormatting(buildifier bool) {
+	c.buildifierFormatting = buildifier
+}
+
+// SetAnnotateSourcePositions sets whether each generated target is annotated with a comment
+// linking it back to the position of its module in its Android.bp file.
+func (c *CodegenContext) SetAnnotateSourcePositions(annotate bool) {
+	c.annotateSourcePositions = annotate
+}
+
 // CodegenMode is an enum to differentiate code-generation modes.
 type CodegenMode int
 
@@ -166,19 +250,23 @@ func NewCodegenContext(config android.Config, context android.Context, mode Code
 
 // props is an unsorted map. This function ensures that
 // the generated attributes are sorted to ensure determinism.
-func propsToAttributes(props map[string]string) string {
+func propsToAttributes(props map[string]string, shouldGenerate func(prop string) bool) string {
 	var attributes string
 	for _, propName := range android.SortedStringKeys(props) {
-		if shouldGenerateAttribute(propName) {
+		if shouldGenerate(propName) {
 			attributes += fmt.Sprintf("    %s = %s,\n", propName, props[propName])
 		}
 	}
 	return attributes
 }
 
-func GenerateBazelTargets(ctx *CodegenContext) (map[string]BazelTargets, CodegenMetrics) {
+// GenerateBazelTargets returns the Bazel targets of each package, along with metrics of the
+// conversion. An error is returned if any of the modules could not be converted.
+func GenerateBazelTargets(ctx *CodegenContext) (map[string]BazelTargets, CodegenMetrics, error) {
 	buildFileToTargets := make(map[string]BazelTargets)
 	buildFileToAppend := make(map[string]bool)
+	deferredTargets := make(map[string][]deferredBazelTarget)
+	var conversionErr error
 
 	// Simple metrics tracking for bp2build
 	metrics := CodegenMetrics{
@@ -187,6 +275,9 @@ func GenerateBazelTargets(ctx *CodegenContext) (map[string]BazelTargets, Codegen
 
 	bpCtx := ctx.Context()
 	bpCtx.VisitAllModules(func(m blueprint.Module) {
+		if conversionErr != nil {
+			return
+		}
 		dir := bpCtx.ModuleDir(m)
 		var t BazelTarget
 
@@ -204,14 +295,37 @@ func GenerateBazelTargets(ctx *CodegenContext) (map[string]BazelTargets, Codegen
 				var err error
 				t, err = getHandcraftedBuildContent(ctx, b, pathToBuildFile)
 				if err != nil {
-					panic(fmt.Errorf("Error converting %s: %s", bpCtx.ModuleName(m), err))
+					conversionErr = fmt.Errorf("Error converting %s: %s", bpCtx.ModuleName(m), err)
+					return
 				}
 				// TODO(b/181575318): currently we append the whole BUILD file, let's change that to do
 				// something more targeted based on the rule type and target
 				buildFileToAppend[pathToBuildFile] = true
 			} else if btm, ok := m.(android.BazelTargetModule); ok {
-				t = generateBazelTarget(bpCtx, m, btm)
+				if unconverted := btm.UnconvertedProperties(); len(unconverted) > 0 {
+					if ctx.strict {
+						conversionErr = fmt.Errorf("Error converting %s: could not convert properties %q",
+							bpCtx.ModuleName(m), unconverted)
+						return
+					}
+					metrics.unconvertedPropertyCount += len(unconverted)
+				}
+				if ctx.hoistCommonAttributes {
+					deferredTargets[dir] = append(deferredTargets[dir], deferredBazelTarget{m, btm})
+					metrics.RuleClassCount[btm.RuleClass()] += 1
+					return
+				}
+				var err error
+				t, err = generateBazelTarget(bpCtx, m, btm, nil)
+				if err != nil {
+					conversionErr = fmt.Errorf("Error converting %s: %s", bpCtx.ModuleName(m), err)
+					return
+				}
 				metrics.RuleClassCount[t.ruleClass] += 1
+				if err := ctx.formatGeneratedTarget(bpCtx, m, &t); err != nil {
+					conversionErr = fmt.Errorf("Error converting %s: %s", bpCtx.ModuleName(m), err)
+					return
+				}
 			} else {
 				metrics.TotalModuleCount += 1
 				return
@@ -224,14 +338,225 @@ func GenerateBazelTargets(ctx *CodegenContext) (map[string]BazelTargets, Codegen
 				return
 			}
 			t = generateSoongModuleTarget(bpCtx, m)
+			if err := ctx.formatGeneratedTarget(bpCtx, m, &t); err != nil {
+				conversionErr = fmt.Errorf("Error converting %s: %s", bpCtx.ModuleName(m), err)
+				return
+			}
 		default:
 			panic(fmt.Errorf("Unknown code-generation mode: %s", ctx.Mode()))
 		}
 
 		buildFileToTargets[dir] = append(buildFileToTargets[dir], t)
 	})
+	if conversionErr != nil {
+		return nil, metrics, conversionErr
+	}
+
+	if ctx.Mode() == Bp2Build {
+		for pkg, files := range android.BazelExportedFiles(ctx.Config()) {
+			buildFileToTargets[pkg] = append(buildFileToTargets[pkg], exportsFilesTarget(files))
+		}
+	}
+
+	for _, dir := range android.SortedStringKeys(deferredTargets) {
+		targets, err := ctx.generateHoistedBazelTargets(deferredTargets[dir])
+		if err != nil {
+			return nil, metrics, err
+		}
+		buildFileToTargets[dir] = append(buildFileToTargets[dir], targets...)
+	}
+
+	// Sort the targets of each package so that the generated BUILD files do not depend on the order
+	// in which modules are visited.
+	for _, targets := range buildFileToTargets {
+		sortBazelTargets(targets)
+	}
+
+	return buildFileToTargets, metrics, nil
+}
+
+// exportsFilesTarget returns an exports_files call exporting the given files of a package, so
+// that targets of other packages can reference them.
+func exportsFilesTarget(files []string) BazelTarget {
+	list, err := prettyPrint(reflect.ValueOf(files), 0)
+	if err != nil {
+		panic(err)
+	}
+	return BazelTarget{
+		ruleClass: "exports_files",
+		content:   fmt.Sprintf("exports_files(%s)", list),
+	}
+}
+
+// The minimum number of generated targets of a package which must share the value of a list
+// attribute for the value to be hoisted into a variable.
+const minTargetsForHoisting = 3
+
+// A module whose Bazel target is generated once the attribute values it shares with the other
+// targets of its package are known.
+type deferredBazelTarget struct {
+	module blueprint.Module
+	btm    android.BazelTargetModule
+}
+
+// generateHoistedBazelTargets generates the targets of the deferred modules of a package, with the
+// list attribute values shared by at least minTargetsForHoisting of them factored out into
+// variables, e.g. _COMMON_DEPS_0. The definitions of the variables are returned along with the
+// targets using them.
+func (ctx *CodegenContext) generateHoistedBazelTargets(deferred []deferredBazelTarget) (BazelTargets, error) {
+	bpCtx := ctx.Context()
+	targetAttrs := make([]map[string]reflect.Value, len(deferred))
+	for i, d := range deferred {
+		targetAttrs[i] = bp2BuildAttributeValues(d.module)
+	}
+	variables, targetVariables, err := hoistCommonAttributes(targetAttrs)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make(BazelTargets, 0, len(variables)+len(deferred))
+	for _, variable := range variables {
+		if ctx.indent != "" {
+			variable.content = reindent(variable.content, ctx.indent)
+		}
+		targets = append(targets, variable)
+	}
+	for i, d := range deferred {
+		t, err := generateBazelTarget(bpCtx, d.module, d.btm, targetVariables[i])
+		if err == nil {
+			err = ctx.formatGeneratedTarget(bpCtx, d.module, &t)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error converting %s: %s", bpCtx.ModuleName(d.module), err)
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// hoistCommonAttributes returns the definitions of variables holding the plain list attribute
+// values shared by at least minTargetsForHoisting of the targets with the given attribute values,
+// along with the variable to use for each attribute of each target, keyed by attribute name.
+// Attributes with configurable values are left as they are.
+func hoistCommonAttributes(targetAttrs []map[string]reflect.Value) (BazelTargets, []map[string]string, error) {
+	type attributeValue struct {
+		name  string
+		value string
+	}
+	var valueOrder []attributeValue
+	targetsByValue := map[attributeValue][]int{}
+	for i, attrs := range targetAttrs {
+		for _, name := range android.SortedStringKeys(attrs) {
+			list, ok := plainListValue(attrs[name])
+			if !ok || !shouldGenerateBp2BuildAttribute(name) {
+				continue
+			}
+			value, err := prettyPrint(list, 0)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error while parsing property: %q. %s", name, err)
+			}
+			key := attributeValue{name, value}
+			if _, exists := targetsByValue[key]; !exists {
+				valueOrder = append(valueOrder, key)
+			}
+			targetsByValue[key] = append(targetsByValue[key], i)
+		}
+	}
+
+	var variables BazelTargets
+	targetVariables := make([]map[string]string, len(targetAttrs))
+	countByAttribute := map[string]int{}
+	for _, key := range valueOrder {
+		targets := targetsByValue[key]
+		if len(targets) < minTargetsForHoisting {
+			continue
+		}
+		variable := fmt.Sprintf("_COMMON_%s_%d", strings.ToUpper(key.name), countByAttribute[key.name])
+		countByAttribute[key.name] += 1
+		variables = append(variables, BazelTarget{
+			name:    variable,
+			content: variable + " = " + key.value,
+			hoisted: true,
+		})
+		for _, i := range targets {
+			if targetVariables[i] == nil {
+				targetVariables[i] = map[string]string{}
+			}
+			targetVariables[i][key.name] = variable
+		}
+	}
+	return variables, targetVariables, nil
+}
 
-	return buildFileToTargets, metrics
+// plainListValue returns the list value of an attribute which is a non-empty list without any
+// configurable values, and whether it is one.
+func plainListValue(value reflect.Value) (reflect.Value, bool) {
+	value = reflect.Indirect(value)
+	if !value.IsValid() {
+		return value, false
+	}
+	switch attr := value.Interface().(type) {
+	case bazel.LabelListAttribute:
+		// Excluded labels are moved into selects when the attribute is printed.
+		attr.ResolveExcludes()
+		if attr.HasConfigurableValues() {
+			return value, false
+		}
+		value = reflect.ValueOf(append(append([]bazel.Label(nil), attr.Value.Includes...),
+			attr.GetValueForArch(bazel.ARCH_COMMON).Includes...))
+	case bazel.StringListAttribute:
+		if attr.HasConfigurableValues() {
+			return value, false
+		}
+		value = reflect.ValueOf(append(android.CopyOf(attr.Value), attr.GetValueForArch(bazel.ARCH_COMMON)...))
+	}
+	return value, value.Kind() == reflect.Slice && value.Len() > 0
+}
+
+// formatGeneratedTarget applies the formatting options of the context to a target generated from
+// the module m. Handcrafted targets are left as they are.
+func (ctx *CodegenContext) formatGeneratedTarget(bpCtx bpToBuildContext, m blueprint.Module, t *BazelTarget) error {
+	if ctx.buildifierFormatting {
+		t.content = formatLikeBuildifier(t.content)
+	}
+	if ctx.indent != "" {
+		t.content = reindent(t.content, ctx.indent)
+	}
+	if ctx.annotateSourcePositions {
+		comment, err := ctx.sourcePositionComment(bpCtx, m)
+		if err != nil {
+			return err
+		}
+		t.content = comment + t.content
+	}
+	return nil
+}
+
+// sourcePositionComment returns a comment line containing the position of the definition of the
+// module a target was generated from in its Android.bp file, e.g. "# Android.bp:12:1", or "" if
+// the module has no definition of its own, e.g. because it was created by a load hook.
+func (ctx *CodegenContext) sourcePositionComment(bpCtx bpToBuildContext, m blueprint.Module) (string, error) {
+	name := bpCtx.ModuleName(m)
+	if btm, ok := m.(android.BazelTargetModule); ok {
+		name = btm.SourceModuleName()
+	}
+	blueprintFile := bpCtx.BlueprintFile(m)
+	positions, ok := ctx.blueprintModulePositions[blueprintFile]
+	if !ok {
+		var err error
+		positions, err = android.BlueprintModulePositions(ctx.config, blueprintFile)
+		if err != nil {
+			return "", err
+		}
+		if ctx.blueprintModulePositions == nil {
+			ctx.blueprintModulePositions = make(map[string]map[string]scanner.Position)
+		}
+		ctx.blueprintModulePositions[blueprintFile] = positions
+	}
+	if pos, ok := positions[name]; ok {
+		return fmt.Sprintf("# %s\n", pos), nil
+	}
+	return "", nil
 }
 
 func getBazelPackagePath(b android.Bazelable) string {
@@ -256,19 +581,28 @@ func getHandcraftedBuildContent(ctx *CodegenContext, b android.Bazelable, pathTo
 	}, nil
 }
 
-func generateBazelTarget(ctx bpToBuildContext, m blueprint.Module, btm android.BazelTargetModule) BazelTarget {
+// generateBazelTarget generates the Bazel target of m, with the attributes named by the keys of
+// variables set to the variables holding their values instead.
+func generateBazelTarget(ctx bpToBuildContext, m blueprint.Module, btm android.BazelTargetModule, variables map[string]string) (BazelTarget, error) {
 	ruleClass := btm.RuleClass()
 	bzlLoadLocation := btm.BzlLoadLocation()
+	targetName := targetNameForBp2Build(ctx, m)
+	if ruleClass == "" {
+		// Rendering the target would produce malformed BUILD content like `(name = "foo")`.
+		return BazelTarget{}, fmt.Errorf("Bazel target %q has an empty rule class", targetName)
+	}
 
 	// extract the bazel attributes from the module.
 	props := getBuildProperties(ctx, m)
 
 	delete(props.Attrs, "bp2build_available")
+	for name, variable := range variables {
+		props.Attrs[name] = variable
+	}
 
 	// Return the Bazel target with rule class and attributes, ready to be
 	// code-generated.
-	attributes := propsToAttributes(props.Attrs)
-	targetName := targetNameForBp2Build(ctx, m)
+	attributes := propsToAttributes(props.Attrs, shouldGenerateBp2BuildAttribute)
 	return BazelTarget{
 		name:            targetName,
 		ruleClass:       ruleClass,
@@ -279,7 +613,7 @@ func generateBazelTarget(ctx bpToBuildContext, m blueprint.Module, btm android.B
 			targetName,
 			attributes,
 		),
-	}
+	}, nil
 }
 
 // Convert a module and its deps and props into a Bazel macro/rule
@@ -296,7 +630,7 @@ func generateSoongModuleTarget(ctx bpToBuildContext, m blueprint.Module) BazelTa
 			depLabels[qualifiedTargetLabel(ctx, depModule)] = true
 		})
 	}
-	attributes := propsToAttributes(props.Attrs)
+	attributes := propsToAttributes(props.Attrs, shouldGenerateAttribute)
 
 	depLabelList := "[\n"
 	for depLabel, _ := range depLabels {
@@ -337,27 +671,44 @@ func ExtractModuleProperties(aModule android.Module) map[string]string {
 
 	// Iterate over this android.Module's property structs.
 	for _, properties := range aModule.GetProperties() {
-		propertiesValue := reflect.ValueOf(properties)
-		// Check that propertiesValue is a pointer to the Properties struct, like
-		// *cc.BaseLinkerProperties or *java.CompilerProperties.
-		//
-		// propertiesValue can also be type-asserted to the structs to
-		// manipulate internal props, if needed.
-		if isStructPtr(propertiesValue.Type()) {
-			structValue := propertiesValue.Elem()
-			for k, v := range extractStructProperties(structValue, 0) {
+		for k, v := range extractStructProperties(propertiesStruct(properties), 0) {
+			ret[k] = v
+		}
+	}
+
+	return ret
+}
+
+// bp2BuildAttributeValues returns the values of the properties of m, keyed by the property name,
+// before they are pretty-printed into the attributes of its Bazel target.
+func bp2BuildAttributeValues(m blueprint.Module) map[string]reflect.Value {
+	ret := map[string]reflect.Value{}
+	if aModule, ok := m.(android.Module); ok {
+		for _, properties := range aModule.GetProperties() {
+			for k, v := range structPropertyValues(propertiesStruct(properties)) {
 				ret[k] = v
 			}
-		} else {
-			panic(fmt.Errorf(
-				"properties must be a pointer to a struct, got %T",
-				propertiesValue.Interface()))
 		}
 	}
-
 	return ret
 }
 
+// propertiesStruct returns the struct pointed to by a property struct pointer of a module.
+func propertiesStruct(properties interface{}) reflect.Value {
+	propertiesValue := reflect.ValueOf(properties)
+	// Check that propertiesValue is a pointer to the Properties struct, like
+	// *cc.BaseLinkerProperties or *java.CompilerProperties.
+	//
+	// propertiesValue can also be type-asserted to the structs to
+	// manipulate internal props, if needed.
+	if !isStructPtr(propertiesValue.Type()) {
+		panic(fmt.Errorf(
+			"properties must be a pointer to a struct, got %T",
+			propertiesValue.Interface()))
+	}
+	return propertiesValue.Elem()
+}
+
 func isStructPtr(t reflect.Type) bool {
 	return t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct
 }
@@ -421,6 +772,12 @@ func prettyPrint(propertyValue reflect.Value, indent int) (string, error) {
 			return fmt.Sprintf("%q", label.Label), nil
 		} else if stringList, ok := propertyValue.Interface().(bazel.StringListAttribute); ok {
 			return prettyPrintStringListAttribute(stringList, indent)
+		} else if str, ok := propertyValue.Interface().(bazel.StringAttribute); ok {
+			return prettyPrintStringAttribute(str, indent)
+		} else if label, ok := propertyValue.Interface().(bazel.LabelAttribute); ok {
+			return prettyPrintLabelAttribute(label, indent)
+		} else if boolAttr, ok := propertyValue.Interface().(bazel.BoolAttribute); ok {
+			return strings.Title(fmt.Sprintf("%v", *boolAttr.Value)), nil
 		}
 
 		ret = "{\n"
@@ -448,11 +805,32 @@ func prettyPrint(propertyValue reflect.Value, indent int) (string, error) {
 // since property structs can be nested. In Starlark, nested structs are represented as nested
 // dicts: https://docs.bazel.build/skylark/lib/dict.html
 func extractStructProperties(structValue reflect.Value, indent int) map[string]string {
+	ret := map[string]string{}
+	for propertyName, fieldValue := range structPropertyValues(structValue) {
+		prettyPrintedValue, err := prettyPrint(fieldValue, indent+1)
+		if err != nil {
+			panic(
+				fmt.Errorf(
+					"Error while parsing property: %q. %s",
+					propertyName,
+					err))
+		}
+		if prettyPrintedValue != "" {
+			ret[propertyName] = prettyPrintedValue
+		}
+	}
+
+	return ret
+}
+
+// structPropertyValues returns the values of the fields of a reflected property struct value which
+// are set, keyed by property name.
+func structPropertyValues(structValue reflect.Value) map[string]reflect.Value {
 	if structValue.Kind() != reflect.Struct {
 		panic(fmt.Errorf("Expected a reflect.Struct type, but got %s", structValue.Kind()))
 	}
 
-	ret := map[string]string{}
+	ret := map[string]reflect.Value{}
 	structType := structValue.Type()
 	for i := 0; i < structValue.NumField(); i++ {
 		field := structType.Field(i)
@@ -466,20 +844,8 @@ func extractStructProperties(structValue reflect.Value, indent int) map[string]s
 			continue
 		}
 
-		propertyName := proptools.PropertyNameForField(field.Name)
-		prettyPrintedValue, err := prettyPrint(fieldValue, indent+1)
-		if err != nil {
-			panic(
-				fmt.Errorf(
-					"Error while parsing property: %q. %s",
-					propertyName,
-					err))
-		}
-		if prettyPrintedValue != "" {
-			ret[propertyName] = prettyPrintedValue
-		}
+		ret[proptools.PropertyNameForField(field.Name)] = fieldValue
 	}
-
 	return ret
 }
 
@@ -494,6 +860,12 @@ func isZero(value reflect.Value) bool {
 		}
 		return valueIsZero
 	case reflect.Struct:
+		if value.CanInterface() {
+			if boolAttr, ok := value.Interface().(bazel.BoolAttribute); ok {
+				// An explicit false is still set, so must not be omitted.
+				return boolAttr.Value == nil
+			}
+		}
 		valueIsZero := true
 		for i := 0; i < value.NumField(); i++ {
 			if value.Field(i).CanSet() {
@@ -526,6 +898,50 @@ func escapeString(s string) string {
 	return strings.ReplaceAll(s, "\"", "\\\"")
 }
 
+// reindent replaces each level of the default indentation at the start of the lines of content
+// with the given indent. Lines continuing a multi-line string literal are left as they are, as
+// their leading whitespace is part of the string.
+func reindent(content string, indent string) string {
+	defaultIndent := makeIndent(1)
+	lines := strings.Split(content, "\n")
+	quote := ""
+	for i, line := range lines {
+		if quote == "" {
+			trimmed := strings.TrimLeft(line, " ")
+			levels := (len(line) - len(trimmed)) / len(defaultIndent)
+			lines[i] = strings.Repeat(indent, levels) + line[levels*len(defaultIndent):]
+		}
+		quote = openStringLiteral(line, quote)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// openStringLiteral returns the quote delimiting the string literal still open at the end of line,
+// or "" if there is none, given the quote of the string literal open at its start.
+func openStringLiteral(line string, quote string) string {
+	for i := 0; i < len(line); i++ {
+		if quote == "" {
+			switch {
+			case line[i] == '#':
+				// The rest of the line is a comment.
+				return ""
+			case strings.HasPrefix(line[i:], `"""`) || strings.HasPrefix(line[i:], "'''"):
+				quote = line[i : i+3]
+				i += 2
+			case line[i] == '"' || line[i] == '\'':
+				quote = line[i : i+1]
+			}
+		} else if line[i] == '\\' {
+			// Skip the escaped character.
+			i++
+		} else if strings.HasPrefix(line[i:], quote) {
+			i += len(quote) - 1
+			quote = ""
+		}
+	}
+	return quote
+}
+
 func makeIndent(indent int) string {
 	if indent < 0 {
 		panic(fmt.Errorf("indent column cannot be less than 0, but got %d", indent))
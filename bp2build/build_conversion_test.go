@@ -16,9 +16,13 @@ package bp2build
 
 import (
 	"android/soong/android"
+	"android/soong/bazel"
 	"android/soong/genrule"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/google/blueprint"
 )
 
 func TestGenerateSoongModuleTargets(t *testing.T) {
@@ -222,79 +226,234 @@ func TestGenerateSoongModuleTargets(t *testing.T) {
 
 func TestGenerateBazelTargetModules(t *testing.T) {
 	testCases := []struct {
-		bp                  string
-		expectedBazelTarget string
+		description          string
+		bp                   string
+		bp2buildMutator      bp2buildMutator
+		annotatePositions    bool
+		indentUnit           string
+		indentWidth          int
+		expectedBazelTargets []string
 	}{
 		{
+			description: "string and string list properties",
 			bp: `custom {
 	name: "foo",
     string_list_prop: ["a", "b"],
     string_prop: "a",
     bazel_module: { bp2build_available: true },
 }`,
-			expectedBazelTarget: `custom(
+			expectedBazelTargets: []string{`custom(
     name = "foo",
     string_list_prop = [
         "a",
         "b",
     ],
     string_prop = "a",
-)`,
+)`},
 		},
 		{
+			description: "control characters",
 			bp: `custom {
 	name: "control_characters",
     string_list_prop: ["\t", "\n"],
     string_prop: "a\t\n\r",
     bazel_module: { bp2build_available: true },
 }`,
-			expectedBazelTarget: `custom(
+			expectedBazelTargets: []string{`custom(
     name = "control_characters",
     string_list_prop = [
         "\t",
         "\n",
     ],
     string_prop = "a\t\n\r",
-)`,
+)`},
 		},
-	}
+		{
+			description: "alias of a renamed target",
+			bp: `custom {
+    name: "foo",
+    string_prop: "a",
+    bazel_module: { bp2build_available: true },
+}`,
+			bp2buildMutator: func(ctx android.TopDownMutatorContext) {
+				if m, ok := ctx.Module().(*customModule); ok && m.ConvertWithBp2build(ctx) {
+					attrs := &customBazelModuleAttributes{
+						String_prop: m.props.String_prop,
+					}
+					props := bazel.BazelTargetModuleProperties{Rule_class: "custom"}
+					ctx.CreateBazelTargetModule(customBazelModuleFactory, m.Name()+"_renamed", props, attrs)
+					ctx.CreateBazelAlias(m.Name(), ":"+m.Name()+"_renamed")
+				}
+			},
+			expectedBazelTargets: []string{`custom(
+    name = "foo_renamed",
+    string_prop = "a",
+)`, `alias(
+    name = "foo",
+    actual = ":foo_renamed",
+)`},
+		},
+		{
+			description: "source position annotation",
+			bp: `
+custom {
+    name: "foo",
+    string_prop: "a",
+    bazel_module: { bp2build_available: true },
+}
 
-	dir := "."
-	for _, testCase := range testCases {
-		config := android.TestConfig(buildDir, nil, testCase.bp, nil)
-		ctx := android.NewTestContext(config)
+custom {
+    name: "bar",
+    string_prop: "b",
+    bazel_module: { bp2build_available: true },
+}`,
+			annotatePositions: true,
+			expectedBazelTargets: []string{`# Android.bp:8:1
+custom(
+    name = "bar",
+    string_prop = "b",
+)`, `# Android.bp:2:1
+custom(
+    name = "foo",
+    string_prop = "a",
+)`},
+		},
+		{
+			description: "licenses",
+			bp: `license {
+    name: "my_license",
+}
 
-		ctx.RegisterModuleType("custom", customModuleFactory)
-		ctx.RegisterBp2BuildMutator("custom", customBp2BuildMutator)
-		ctx.RegisterForBazelConversion()
+custom {
+    name: "foo",
+    string_prop: "a",
+    licenses: ["my_license"],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`custom(
+    name = "foo",
+    applicable_licenses = [
+        ":my_license",
+    ],
+    string_prop = "a",
+)`},
+		},
+		{
+			description: "tags",
+			bp: `custom {
+    name: "foo",
+    string_prop: "a",
+    bazel_module: { bp2build_available: true },
+}`,
+			bp2buildMutator: func(ctx android.TopDownMutatorContext) {
+				if m, ok := ctx.Module().(*customModule); ok && m.ConvertWithBp2build(ctx) {
+					attrs := &customBazelModuleAttributes{
+						String_prop: m.props.String_prop,
+					}
+					props := bazel.BazelTargetModuleProperties{
+						Rule_class: "custom",
+						Tags:       bazel.StringListAttribute{Value: []string{"manual", "no-ci"}},
+					}
+					ctx.CreateBazelTargetModule(customBazelModuleFactory, m.Name(), props, attrs)
+				}
+			},
+			expectedBazelTargets: []string{`custom(
+    name = "foo",
+    string_prop = "a",
+    tags = [
+        "manual",
+        "no-ci",
+    ],
+)`},
+		},
+		{
+			description: "tab indentation",
+			bp: `custom {
+    name: "foo",
+    string_list_prop: ["a", "b"],
+    bazel_module: { bp2build_available: true },
+}`,
+			indentUnit:  "\t",
+			indentWidth: 1,
+			expectedBazelTargets: []string{"custom(\n" +
+				"\tname = \"foo\",\n" +
+				"\tstring_list_prop = [\n" +
+				"\t\t\"a\",\n" +
+				"\t\t\"b\",\n" +
+				"\t],\n" +
+				")"},
+		},
+		{
+			description: "two space indentation",
+			bp: `custom {
+    name: "foo",
+    string_list_prop: ["a", "b"],
+    bazel_module: { bp2build_available: true },
+}`,
+			indentUnit:  " ",
+			indentWidth: 2,
+			expectedBazelTargets: []string{`custom(
+  name = "foo",
+  string_list_prop = [
+    "a",
+    "b",
+  ],
+)`},
+		},
+	}
 
-		_, errs := ctx.ParseFileList(dir, []string{"Android.bp"})
-		if Errored(t, "", errs) {
-			continue
+	for _, testCase := range testCases {
+		mutator := testCase.bp2buildMutator
+		if mutator == nil {
+			mutator = customBp2BuildMutator
 		}
-		_, errs = ctx.ResolveDependencies(config)
-		if Errored(t, "", errs) {
+		codegenCtx := runBp2BuildTestCase(t, testCase.bp, nil, func(ctx *android.TestContext) {
+			ctx.RegisterModuleType("license", android.LicenseFactory)
+			ctx.RegisterModuleType("custom", customModuleFactory)
+			ctx.RegisterBp2BuildMutator("custom", mutator)
+		})
+		codegenCtx.SetAnnotateSourcePositions(testCase.annotatePositions)
+		codegenCtx.SetIndentation(testCase.indentUnit, testCase.indentWidth)
+		bazelTargets := generateBazelTargetsForDir(codegenCtx, ".")
+
+		if actualCount, expectedCount := len(bazelTargets), len(testCase.expectedBazelTargets); actualCount != expectedCount {
+			t.Errorf("%s: Expected %d bazel targets, got %d", testCase.description, expectedCount, actualCount)
 			continue
 		}
-
-		codegenCtx := NewCodegenContext(config, *ctx.Context, Bp2Build)
-		bazelTargets := generateBazelTargetsForDir(codegenCtx, dir)
-
-		if actualCount, expectedCount := len(bazelTargets), 1; actualCount != expectedCount {
-			t.Errorf("Expected %d bazel target, got %d", expectedCount, actualCount)
-		} else {
-			actualBazelTarget := bazelTargets[0]
-			if actualBazelTarget.content != testCase.expectedBazelTarget {
+		for i, target := range bazelTargets {
+			if w, g := testCase.expectedBazelTargets[i], target.content; w != g {
 				t.Errorf(
-					"Expected generated Bazel target to be '%s', got '%s'",
-					testCase.expectedBazelTarget,
-					actualBazelTarget.content,
+					"%s: Expected generated Bazel target to be '%s', got '%s'",
+					testCase.description,
+					w,
+					g,
 				)
 			}
 		}
 	}
 }
 
+func TestBazelTargetsMerge(t *testing.T) {
+	foo := BazelTarget{name: "foo", ruleClass: "custom", content: "custom(\n    name = \"foo\",\n)"}
+	bar := BazelTarget{name: "bar", ruleClass: "custom", content: "custom(\n    name = \"bar\",\n)"}
+	baz := BazelTarget{name: "baz", ruleClass: "custom", content: "custom(\n    name = \"baz\",\n)"}
+
+	merged, err := BazelTargets{foo, bar}.Merge(BazelTargets{bar, baz})
+	if err != nil {
+		t.Fatalf("Unexpected error merging targets: %s", err)
+	}
+	if expected := (BazelTargets{foo, bar, baz}); !reflect.DeepEqual(expected, merged) {
+		t.Errorf("Expected merged targets %v, got %v", expected, merged)
+	}
+
+	conflictingFoo := BazelTarget{name: "foo", ruleClass: "other", content: "other(\n    name = \"foo\",\n)"}
+	if _, err := (BazelTargets{foo}).Merge(BazelTargets{conflictingFoo}); err == nil {
+		t.Errorf("Expected an error merging conflicting definitions of foo")
+	} else if !strings.Contains(err.Error(), `conflicting definitions of Bazel target "foo"`) {
+		t.Errorf("Unexpected error merging conflicting definitions of foo: %s", err)
+	}
+}
+
 func TestLoadStatements(t *testing.T) {
 	testCases := []struct {
 		bazelTargets           BazelTargets
@@ -382,6 +541,43 @@ load("//build/bazel/rules:java.bzl", "java_binary")`,
 			expectedLoadStatements: `load("//build/bazel/rules:cc.bzl", "cc_binary")
 load("//build/bazel/rules:java.bzl", "java_binary")`,
 		},
+		{
+			bazelTargets: BazelTargets{
+				BazelTarget{
+					name:            "foo",
+					ruleClass:       "custom_rule",
+					bzlLoadLocation: "//build/bazel/rules:custom.bzl",
+				},
+				BazelTarget{
+					name:      "bar",
+					ruleClass: "filegroup",
+				},
+				BazelTarget{
+					name:            "baz",
+					ruleClass:       "custom_rule",
+					bzlLoadLocation: "//build/bazel/rules:custom.bzl",
+				},
+				BazelTarget{
+					name:            "qux",
+					ruleClass:       "custom_rule",
+					bzlLoadLocation: "//build/bazel/rules:custom.bzl",
+				},
+			},
+			expectedLoadStatements: `load("//build/bazel/rules:custom.bzl", "custom_rule")`,
+		},
+		{
+			bazelTargets: BazelTargets{
+				BazelTarget{
+					name:      "foo",
+					ruleClass: "filegroup",
+				},
+				BazelTarget{
+					name:      "bar",
+					ruleClass: "genrule",
+				},
+			},
+			expectedLoadStatements: "",
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -394,6 +590,147 @@ load("//build/bazel/rules:java.bzl", "java_binary")`,
 
 }
 
+func TestGenerateBazelTargetModulesEmptyRuleClass(t *testing.T) {
+	bp := `custom {
+    name: "foo",
+    bazel_module: { bp2build_available: true },
+}`
+	codegenCtx := runBp2BuildTestCase(t, bp, nil, func(ctx *android.TestContext) {
+		ctx.RegisterModuleType("custom", customModuleFactory)
+		ctx.RegisterBp2BuildMutator("custom", func(ctx android.TopDownMutatorContext) {
+			if m, ok := ctx.Module().(*customModule); ok && m.ConvertWithBp2build(ctx) {
+				ctx.CreateBazelTargetModule(customBazelModuleFactory, m.Name(),
+					bazel.BazelTargetModuleProperties{}, &customBazelModuleAttributes{})
+			}
+		})
+	})
+
+	bpCtx := codegenCtx.Context()
+	var err error
+	converted := false
+	bpCtx.VisitAllModules(func(m blueprint.Module) {
+		if btm, ok := m.(android.BazelTargetModule); ok {
+			converted = true
+			_, err = generateBazelTarget(bpCtx, m, btm, nil)
+		}
+	})
+	if !converted {
+		t.Fatalf("Expected a Bazel target module to be created")
+	}
+	if w := `Bazel target "foo" has an empty rule class`; err == nil || err.Error() != w {
+		t.Errorf("Expected error %q, got %v", w, err)
+	}
+
+	// The error is returned to the caller of GenerateBazelTargets.
+	if _, _, err := GenerateBazelTargets(codegenCtx); err == nil ||
+		err.Error() != `Error converting foo: Bazel target "foo" has an empty rule class` {
+		t.Errorf("Expected GenerateBazelTargets to fail converting foo, got %v", err)
+	}
+}
+
+func TestFilegroupBp2BuildStrictPath(t *testing.T) {
+	bp := `filegroup {
+    name: "fg_foo",
+    srcs: ["data/a.txt"],
+    path: "data",
+    bazel_module: { bp2build_available: true },
+}`
+	codegenCtx := runBp2BuildTestCase(t, bp, nil, func(ctx *android.TestContext) {
+		ctx.RegisterModuleType("filegroup", android.FileGroupFactory)
+		ctx.RegisterBp2BuildMutator("filegroup", android.FilegroupBp2Build)
+	})
+	codegenCtx.SetStrict(true)
+	expectedErr := `Error converting fg_foo: could not convert properties ["path"]`
+	if _, _, err := GenerateBazelTargets(codegenCtx); err == nil || err.Error() != expectedErr {
+		t.Errorf("Expected strict conversion of a filegroup with a path to fail with %q, got %v", expectedErr, err)
+	}
+}
+
+func TestGlobToLabelList(t *testing.T) {
+	bp := `custom {
+    name: "foo",
+    bazel_module: { bp2build_available: true },
+}`
+	fs := map[string][]byte{
+		"foo.h":                nil,
+		"bar.h":                nil,
+		"foo.cc":               nil,
+		"include/baz.h":        nil,
+		"include/excluded.h":   nil,
+		"include/nested/qux.h": nil,
+		"private/private.h":    nil,
+	}
+	var labels, emptyLabels bazel.LabelList
+	codegenCtx := runBp2BuildTestCase(t, bp, fs, func(ctx *android.TestContext) {
+		ctx.RegisterModuleType("custom", customModuleFactory)
+		ctx.RegisterBp2BuildMutator("custom", func(ctx android.TopDownMutatorContext) {
+			if m, ok := ctx.Module().(*customModule); ok && m.ConvertWithBp2build(ctx) {
+				labels = android.GlobToLabelList(ctx, []string{"*.h", "include/**/*.h"},
+					[]string{"include/excluded.h", "private/*.h"})
+				emptyLabels = android.GlobToLabelList(ctx, []string{"*.c", "private/*.h"}, []string{"private/*.h"})
+				attrs := &customBazelModuleAttributes{}
+				for _, l := range labels.Includes {
+					attrs.String_list_prop = append(attrs.String_list_prop, l.Label)
+				}
+				props := bazel.BazelTargetModuleProperties{Rule_class: "custom"}
+				ctx.CreateBazelTargetModule(customBazelModuleFactory, m.Name(), props, attrs)
+			}
+		})
+	})
+	bazelTargets := generateBazelTargetsForDir(codegenCtx, ".")
+	if actualCount, expectedCount := len(bazelTargets), 1; actualCount != expectedCount {
+		t.Fatalf("Expected %d bazel target, got %d", expectedCount, actualCount)
+	}
+	expected := `custom(
+    name = "foo",
+    string_list_prop = [
+        "bar.h",
+        "foo.h",
+        "include/baz.h",
+        "include/nested/qux.h",
+    ],
+)`
+	if actual := bazelTargets[0].content; actual != expected {
+		t.Errorf("Expected generated Bazel target to be '%s', got '%s'", expected, actual)
+	}
+
+	expectedBpText := []string{"*.h", "*.h", "include/**/*.h", "include/**/*.h"}
+	for i, l := range labels.Includes {
+		if i < len(expectedBpText) && l.Bp_text != expectedBpText[i] {
+			t.Errorf("Expected %s to have the Bp_text of its pattern %q, got %q", l.Label, expectedBpText[i], l.Bp_text)
+		}
+	}
+	if len(emptyLabels.Includes) != 0 || len(emptyLabels.Excludes) != 0 {
+		t.Errorf("Expected no labels for patterns without matches, got %v", emptyLabels)
+	}
+}
+
+func TestReindentKeepsStringLiterals(t *testing.T) {
+	content := `genrule(
+    name = "foo",
+    cmd = """
+    echo "a"
+        echo "b"
+""",
+    srcs = [
+        "a.txt",
+    ],
+)`
+	expected := `genrule(
+  name = "foo",
+  cmd = """
+    echo "a"
+        echo "b"
+""",
+  srcs = [
+    "a.txt",
+  ],
+)`
+	if actual := reindent(content, "  "); actual != expected {
+		t.Errorf("Expected reindented target to be '%s', got '%s'", expected, actual)
+	}
+}
+
 func TestGenerateBazelTargetModules_OneToMany_LoadedFromStarlark(t *testing.T) {
 	testCases := []struct {
 		bp                       string
@@ -540,6 +877,33 @@ genrule {
 )`,
 			},
 		},
+		{
+			description:                        "filegroup with multiple files and a path",
+			moduleTypeUnderTest:                "filegroup",
+			moduleTypeUnderTestFactory:         android.FileGroupFactory,
+			moduleTypeUnderTestBp2BuildMutator: android.FilegroupBp2Build,
+			bp: `filegroup {
+    name: "fg_foo",
+    srcs: ["data/*.txt", "data/nested/*.json"],
+    path: "data",
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`filegroup(
+    name = "fg_foo",
+    srcs = [
+        "data/a.txt",
+        "data/b.txt",
+        "data/nested/c.json",
+    ],
+)`,
+			},
+			fs: map[string]string{
+				"data/a.txt":         "",
+				"data/b.txt":         "",
+				"data/nested/c.json": "",
+				"data/nested/d.txt":  "",
+			},
+		},
 		{
 			description:                        "filegroup with excludes srcs",
 			moduleTypeUnderTest:                "filegroup",
@@ -738,6 +1102,75 @@ genrule {
     srcs = [
         "foo_tool.in",
     ],
+)`,
+			},
+		},
+		{
+			description:                        "genrule using $(location) with tools and tool_files",
+			moduleTypeUnderTest:                "genrule",
+			moduleTypeUnderTestFactory:         genrule.GenRuleFactory,
+			moduleTypeUnderTestBp2BuildMutator: genrule.GenruleBp2Build,
+			depsMutators:                       []android.RegisterMutatorFunc{genrule.RegisterGenruleBp2BuildDeps},
+			bp: `genrule {
+    name: "foo.tool",
+    out: ["foo_tool.out"],
+    srcs: ["foo_tool.in"],
+    cmd: "cp $(in) $(out)",
+    bazel_module: { bp2build_available: true },
+}
+
+genrule {
+    name: "bar.tool",
+    out: ["bar_tool.out"],
+    srcs: ["bar_tool.in"],
+    cmd: "cp $(in) $(out)",
+    bazel_module: { bp2build_available: true },
+}
+
+genrule {
+    name: "foo",
+    out: ["foo.h", "foo.cc"],
+    srcs: ["foo.in"],
+    tools: [":foo.tool", ":bar.tool"],
+    tool_files: ["a_script.sh"],
+    cmd: "$(location) --script $(location a_script.sh) $(in) $(out)",
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`genrule(
+    name = "bar.tool",
+    cmd = "cp $(SRCS) $(OUTS)",
+    outs = [
+        "bar_tool.out",
+    ],
+    srcs = [
+        "bar_tool.in",
+    ],
+)`,
+				`genrule(
+    name = "foo",
+    cmd = "$(location :foo.tool) --script $(location a_script.sh) $(SRCS) $(OUTS)",
+    outs = [
+        "foo.h",
+        "foo.cc",
+    ],
+    srcs = [
+        "foo.in",
+    ],
+    tools = [
+        ":bar.tool",
+        ":foo.tool",
+        "a_script.sh",
+    ],
+)`,
+				`genrule(
+    name = "foo.tool",
+    cmd = "cp $(SRCS) $(OUTS)",
+    outs = [
+        "foo_tool.out",
+    ],
+    srcs = [
+        "foo_tool.in",
+    ],
 )`,
 			},
 		},
@@ -885,6 +1318,37 @@ genrule {
     srcs = [
         "foo.in",
     ],
+)`,
+			},
+		},
+		{
+			description:                        "genrule requiring a custom toolchain",
+			moduleTypeUnderTest:                "genrule",
+			moduleTypeUnderTestFactory:         genrule.GenRuleFactory,
+			moduleTypeUnderTestBp2BuildMutator: genrule.GenruleBp2Build,
+			depsMutators:                       []android.RegisterMutatorFunc{genrule.RegisterGenruleBp2BuildDeps},
+			bp: `genrule {
+    name: "foo",
+    out: ["foo.out"],
+    srcs: ["foo.in"],
+    cmd: "$(PROTOC) $(in) > $(out)",
+    bazel_module: {
+        bp2build_available: true,
+        toolchains: ["//build/bazel/toolchains:protoc"],
+    },
+}`,
+			expectedBazelTargets: []string{`genrule(
+    name = "foo",
+    cmd = "$(PROTOC) $(SRCS) > $(OUTS)",
+    outs = [
+        "foo.out",
+    ],
+    srcs = [
+        "foo.in",
+    ],
+    toolchains = [
+        "//build/bazel/toolchains:protoc",
+    ],
 )`,
 			},
 		},
@@ -957,6 +1421,24 @@ func Errored(t *testing.T, desc string, errs []error) bool {
 
 type bp2buildMutator = func(android.TopDownMutatorContext)
 
+// runBp2BuildTestCase parses bp, with the module types registered by registerModuleTypes, and runs
+// the bp2build mutators over it. The returned context generates the Bazel targets of the modules.
+func runBp2BuildTestCase(t *testing.T, bp string, fs map[string][]byte,
+	registerModuleTypes func(ctx *android.TestContext)) *CodegenContext {
+	t.Helper()
+	config := android.TestConfig(buildDir, nil, bp, fs)
+	ctx := android.NewTestContext(config)
+	registerModuleTypes(ctx)
+	ctx.RegisterForBazelConversion()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.ResolveDependencies(config)
+	android.FailIfErrored(t, errs)
+
+	return NewCodegenContext(config, *ctx.Context, Bp2Build)
+}
+
 func TestBp2BuildInlinesDefaults(t *testing.T) {
 	testCases := []struct {
 		moduleTypesUnderTest      map[string]android.ModuleFactory
@@ -1417,10 +1899,10 @@ filegroup {
     },
 }`,
 			expectedBazelTargets: []string{
+				`// BUILD file`,
 				`filegroup(
     name = "fg_foo",
 )`,
-				`// BUILD file`,
 			},
 			fs: map[string]string{
 				"other/BUILD.bazel": `// BUILD file`,
@@ -1445,10 +1927,10 @@ filegroup {
     },
 }`,
 			expectedBazelTargets: []string{
+				`// BUILD file`,
 				`filegroup(
     name = "fg_bar",
 )`,
-				`// BUILD file`,
 			},
 			fs: map[string]string{
 				"other/BUILD.bazel": `// BUILD file`,
@@ -1507,3 +1989,95 @@ filegroup {
 		}
 	}
 }
+
+func TestGenerateBazelTargetsSortedByName(t *testing.T) {
+	bp := `custom {
+    name: "zoo",
+    bazel_module: { bp2build_available: true },
+}
+
+custom {
+    name: "bar",
+    bazel_module: { bp2build_available: true },
+}
+
+custom {
+    name: "moo",
+    bazel_module: { bp2build_available: true },
+}`
+	codegenCtx := runBp2BuildTestCase(t, bp, nil, func(ctx *android.TestContext) {
+		ctx.RegisterModuleType("custom", customModuleFactory)
+		ctx.RegisterBp2BuildMutator("custom", customBp2BuildMutatorFromStarlark)
+	})
+	bazelTargets := generateBazelTargetsForDir(codegenCtx, ".")
+
+	expectedNames := []string{
+		"bar",
+		"bar_my_proto_library_deps",
+		"bar_proto_library_deps",
+		"moo",
+		"moo_my_proto_library_deps",
+		"moo_proto_library_deps",
+		"zoo",
+		"zoo_my_proto_library_deps",
+		"zoo_proto_library_deps",
+	}
+	var actualNames []string
+	for _, target := range bazelTargets {
+		actualNames = append(actualNames, target.name)
+	}
+	if !reflect.DeepEqual(actualNames, expectedNames) {
+		t.Errorf("Expected targets sorted by name %q, got %q", expectedNames, actualNames)
+	}
+
+	expectedLoadStatements := `load("//build/bazel/rules:proto.bzl", "my_proto_library", "proto_library")
+load("//build/bazel/rules:rules.bzl", "my_library")`
+	if actual := bazelTargets.LoadStatements(); actual != expectedLoadStatements {
+		t.Errorf("Expected each load statement once and sorted '%s', got '%s'", expectedLoadStatements, actual)
+	}
+}
+
+func TestHoistCommonAttributes(t *testing.T) {
+	commonDeps := bazel.MakeLabelListAttribute(bazel.LabelList{Includes: []bazel.Label{
+		{Label: ":libbase"},
+		{Label: ":liblog"},
+	}})
+	configurableDeps := commonDeps
+	configurableDeps.SetValueForArch(bazel.ARCH_ARM, bazel.LabelList{Includes: []bazel.Label{{Label: ":libarm"}}})
+	attrs := func(deps bazel.LabelListAttribute, srcs ...string) map[string]reflect.Value {
+		return map[string]reflect.Value{
+			"deps": reflect.ValueOf(deps),
+			"srcs": reflect.ValueOf(srcs),
+		}
+	}
+	targetAttrs := []map[string]reflect.Value{
+		attrs(commonDeps, "a.cc"),
+		attrs(commonDeps, "b.cc"),
+		attrs(commonDeps, "c.cc"),
+		attrs(bazel.MakeLabelListAttribute(bazel.LabelList{Includes: []bazel.Label{{Label: ":libbase"}}}), "d.cc"),
+		attrs(configurableDeps, "e.cc"),
+	}
+
+	variables, targetVariables, err := hoistCommonAttributes(targetAttrs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expectedVariable := `_COMMON_DEPS_0 = [
+    ":libbase",
+    ":liblog",
+]`
+	if len(variables) != 1 || !variables[0].hoisted || variables[0].content != expectedVariable {
+		t.Fatalf("Expected the hoisted variable '%s', got %v", expectedVariable, variables)
+	}
+	for i, expected := range []map[string]string{
+		{"deps": "_COMMON_DEPS_0"},
+		{"deps": "_COMMON_DEPS_0"},
+		{"deps": "_COMMON_DEPS_0"},
+		nil,
+		nil,
+	} {
+		if actual := targetVariables[i]; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected target %d to use variables %v, got %v", i, expected, actual)
+		}
+	}
+}
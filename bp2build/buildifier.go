@@ -0,0 +1,233 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// buildifierNamePriority is the order in which buildifier sorts the attributes of a rule call in a
+// BUILD file, from NamePriority in buildtools/tables. Attributes without a priority sort at 0, and
+// attributes of equal priority are sorted by name.
+var buildifierNamePriority = map[string]int{
+	"name":     -99,
+	"size":     -95,
+	"timeout":  -94,
+	"testonly": -93,
+	"src":      -92,
+	"srcdir":   -91,
+	"srcs":     -90,
+	"out":      -89,
+	"outs":     -88,
+	"hdrs":     -87,
+
+	"destdir":        1,
+	"exports":        2,
+	"runtime_deps":   3,
+	"deps":           4,
+	"implementation": 5,
+	"implements":     6,
+	"alwayslink":     7,
+}
+
+// buildifierSortableListArgs are the attributes whose lists of strings buildifier sorts, from
+// IsSortableListArg in buildtools/tables. Attributes whose order matters, like copts, are not
+// sorted.
+var buildifierSortableListArgs = map[string]bool{
+	"compatible_with": true,
+	"data":            true,
+	"deps":            true,
+	"exec_tools":      true,
+	"exports":         true,
+	"hdrs":            true,
+	"plugins":         true,
+	"resources":       true,
+	"restricted_to":   true,
+	"runtime_deps":    true,
+	"srcs":            true,
+	"tags":            true,
+	"textual_hdrs":    true,
+	"tools":           true,
+	"visibility":      true,
+}
+
+// formatLikeBuildifier formats the content of a generated target, indented with the default
+// indentation, the way buildifier formats a BUILD file, so that running buildifier over generated
+// files leaves them unchanged:
+//   - name comes first and the other attributes are ordered by buildifier's priorities, then by
+//     name,
+//   - the lists of strings of sortable attributes, including those within selects, are sorted in
+//     buildifier's label order,
+//   - empty lists and dicts are written as [] and {}.
+//
+// Lists of two or more elements are already written one element per line with trailing commas, as
+// buildifier does.
+func formatLikeBuildifier(content string) string {
+	lines := strings.Split(content, "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], "(") || lines[len(lines)-1] != ")" {
+		return content
+	}
+
+	type attribute struct {
+		name  string
+		lines []string
+	}
+	var attributes []attribute
+	for _, line := range lines[1 : len(lines)-1] {
+		if name, ok := targetAttributeName(line); ok || len(attributes) == 0 {
+			attributes = append(attributes, attribute{name: name, lines: []string{line}})
+		} else {
+			last := &attributes[len(attributes)-1]
+			last.lines = append(last.lines, line)
+		}
+	}
+
+	for i := range attributes {
+		if buildifierSortableListArgs[attributes[i].name] {
+			sortStringListsLikeBuildifier(attributes[i].lines)
+		}
+		attributes[i].lines = compactEmptyCollections(attributes[i].lines)
+	}
+	sort.SliceStable(attributes, func(i, j int) bool {
+		pi, pj := buildifierNamePriority[attributes[i].name], buildifierNamePriority[attributes[j].name]
+		if pi != pj {
+			return pi < pj
+		}
+		return attributes[i].name < attributes[j].name
+	})
+
+	ret := []string{lines[0]}
+	for _, attr := range attributes {
+		ret = append(ret, attr.lines...)
+	}
+	ret = append(ret, lines[len(lines)-1])
+	return strings.Join(ret, "\n")
+}
+
+// targetAttributeName returns the name of the attribute assigned on line, if line is the first line
+// of a top level attribute of a target with the default indentation.
+func targetAttributeName(line string) (string, bool) {
+	indent := makeIndent(1)
+	if !strings.HasPrefix(line, indent) {
+		return "", false
+	}
+	i := strings.Index(line, " = ")
+	if i < len(indent) {
+		return "", false
+	}
+	name := line[len(indent):i]
+	for j, c := range name {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || j > 0 && '0' <= c && c <= '9') {
+			return "", false
+		}
+	}
+	return name, name != ""
+}
+
+// sortStringListsLikeBuildifier sorts the elements of each multi-line list consisting only of
+// string literals in lines, in place.
+func sortStringListsLikeBuildifier(lines []string) {
+	for i := 0; i < len(lines); i++ {
+		if !strings.HasSuffix(lines[i], "[") {
+			continue
+		}
+		start := i + 1
+		end := start
+		var values []string
+		for end < len(lines) {
+			value, err := strconv.Unquote(strings.TrimSuffix(strings.TrimSpace(lines[end]), ","))
+			if err != nil || !strings.HasSuffix(lines[end], ",") {
+				break
+			}
+			values = append(values, value)
+			end++
+		}
+		if end == len(lines) || !strings.HasPrefix(strings.TrimSpace(lines[end]), "]") {
+			continue
+		}
+
+		elements := lines[start:end]
+		sort.Stable(byBuildifierLabelOrder{elements, values})
+		i = end
+	}
+}
+
+// byBuildifierLabelOrder sorts the lines of list elements by their string values the way
+// buildifier sorts lists of labels: plain strings first, then local labels (":foo"), then absolute
+// labels ("//foo"), then labels of external repositories ("@foo"), each compared by their
+// components separated by "." and ":".
+type byBuildifierLabelOrder struct {
+	lines  []string
+	values []string
+}
+
+func (s byBuildifierLabelOrder) Len() int { return len(s.lines) }
+
+func (s byBuildifierLabelOrder) Swap(i, j int) {
+	s.lines[i], s.lines[j] = s.lines[j], s.lines[i]
+	s.values[i], s.values[j] = s.values[j], s.values[i]
+}
+
+func (s byBuildifierLabelOrder) Less(i, j int) bool {
+	a, b := s.values[i], s.values[j]
+	if phaseA, phaseB := buildifierLabelPhase(a), buildifierLabelPhase(b); phaseA != phaseB {
+		return phaseA < phaseB
+	}
+	splitA := strings.Split(strings.Replace(a, ":", ".", -1), ".")
+	splitB := strings.Split(strings.Replace(b, ":", ".", -1), ".")
+	for k := 0; k < len(splitA) && k < len(splitB); k++ {
+		if splitA[k] != splitB[k] {
+			return splitA[k] < splitB[k]
+		}
+	}
+	if len(splitA) != len(splitB) {
+		return len(splitA) < len(splitB)
+	}
+	return a < b
+}
+
+func buildifierLabelPhase(s string) int {
+	switch {
+	case strings.HasPrefix(s, ":"):
+		return 1
+	case strings.HasPrefix(s, "//"):
+		return 2
+	case strings.HasPrefix(s, "@"):
+		return 3
+	default:
+		return 0
+	}
+}
+
+// compactEmptyCollections joins lists and dicts without elements that span two lines, e.g.
+// "srcs = [" followed by "]," into a single line "srcs = [],".
+func compactEmptyCollections(lines []string) []string {
+	var ret []string
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if i+1 < len(lines) {
+			next := strings.TrimLeft(lines[i+1], " ")
+			if strings.HasSuffix(line, "[") && strings.HasPrefix(next, "]") ||
+				strings.HasSuffix(line, "{") && strings.HasPrefix(next, "}") {
+				line += next
+				i++
+			}
+		}
+		ret = append(ret, line)
+	}
+	return ret
+}
@@ -0,0 +1,194 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"testing"
+)
+
+func TestFormatLikeBuildifier(t *testing.T) {
+	testCases := []struct {
+		description string
+		content     string
+		// The content as formatted by buildifier.
+		expected string
+	}{
+		{
+			description: "attributes ordered by priority",
+			content: `cc_library_static(
+    name = "foo",
+    alwayslink = True,
+    includes = [
+        ".",
+    ],
+    deps = [
+        ":bar",
+    ],
+    hdrs = [
+        "foo.h",
+    ],
+    copts = [
+        "-Wall",
+        "-Werror",
+    ],
+    srcs = [
+        "foo.cpp",
+    ],
+)`,
+			expected: `cc_library_static(
+    name = "foo",
+    srcs = [
+        "foo.cpp",
+    ],
+    hdrs = [
+        "foo.h",
+    ],
+    copts = [
+        "-Wall",
+        "-Werror",
+    ],
+    includes = [
+        ".",
+    ],
+    deps = [
+        ":bar",
+    ],
+    alwayslink = True,
+)`,
+		},
+		{
+			description: "sortable lists in label order",
+			content: `cc_library_static(
+    name = "foo",
+    copts = [
+        "-Werror",
+        "-Wall",
+    ],
+    deps = [
+        "//other:lib",
+        ":lib",
+        ":lib-static",
+        ":lib.static",
+        "@external//:lib",
+    ],
+    srcs = [
+        "foo-bar.cpp",
+        "foo.cpp",
+        "foo/bar.cpp",
+    ],
+)`,
+			expected: `cc_library_static(
+    name = "foo",
+    srcs = [
+        "foo.cpp",
+        "foo-bar.cpp",
+        "foo/bar.cpp",
+    ],
+    copts = [
+        "-Werror",
+        "-Wall",
+    ],
+    deps = [
+        ":lib",
+        ":lib.static",
+        ":lib-static",
+        "//other:lib",
+        "@external//:lib",
+    ],
+)`,
+		},
+		{
+			description: "sortable lists in selects",
+			content: `cc_library_static(
+    name = "foo",
+    deps = [
+        ":b-lib",
+        ":b.lib",
+    ] + select({
+        "//build/bazel/platforms/arch:arm": [
+            "//arm:lib",
+            ":arm-lib",
+        ],
+        "//conditions:default": [],
+    }),
+)`,
+			expected: `cc_library_static(
+    name = "foo",
+    deps = [
+        ":b.lib",
+        ":b-lib",
+    ] + select({
+        "//build/bazel/platforms/arch:arm": [
+            ":arm-lib",
+            "//arm:lib",
+        ],
+        "//conditions:default": [],
+    }),
+)`,
+		},
+		{
+			description: "empty lists and dicts",
+			content: `custom(
+    name = "foo",
+    string_list_prop = [
+    ],
+    nested = {
+    },
+    srcs = [
+    ] + select({
+        "//conditions:default": [
+        ],
+    }),
+)`,
+			expected: `custom(
+    name = "foo",
+    srcs = [] + select({
+        "//conditions:default": [],
+    }),
+    nested = {},
+    string_list_prop = [],
+)`,
+		},
+		{
+			description: "already formatted",
+			content: `filegroup(
+    name = "foo",
+    srcs = [
+        "a.txt",
+        "b.txt",
+    ],
+)`,
+			expected: `filegroup(
+    name = "foo",
+    srcs = [
+        "a.txt",
+        "b.txt",
+    ],
+)`,
+		},
+		{
+			description: "not a target",
+			content:     `exports_files(["a.txt"])`,
+			expected:    `exports_files(["a.txt"])`,
+		},
+	}
+
+	for _, tc := range testCases {
+		if actual := formatLikeBuildifier(tc.content); actual != tc.expected {
+			t.Errorf("%s: expected buildifier formatting of\n%s\nto be\n%s\ngot\n%s",
+				tc.description, tc.content, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"android/soong/android"
+	"android/soong/cc"
+	"testing"
+
+	"github.com/google/blueprint/proptools"
+)
+
+func TestCcBinaryBp2Build(t *testing.T) {
+	testCases := []struct {
+		description          string
+		blueprint            string
+		filesystem           map[string]string
+		nativeCoveragePaths  []string
+		expectedBazelTargets []string
+	}{
+		{
+			description: "cc_binary with symlinks",
+			filesystem: map[string]string{
+				"main.cpp": "",
+			},
+			blueprint: `cc_binary {
+    name: "foo",
+    srcs: ["main.cpp"],
+    symlinks: [
+        "foo_alias",
+        "bar_alias",
+    ],
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "foo",
+    srcs = [
+        "main.cpp",
+    ],
+    symlinks = [
+        "foo_alias",
+        "bar_alias",
+    ],
+)`,
+			},
+		},
+		{
+			description: "cc_binary with symlinks to the preferred arch",
+			filesystem: map[string]string{
+				"main.cpp": "",
+			},
+			blueprint: `cc_binary {
+    name: "foo",
+    srcs: ["main.cpp"],
+    suffix: "64",
+    symlinks: ["foo_alias"],
+    symlink_preferred_arch: true,
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "foo",
+    srcs = [
+        "main.cpp",
+    ],
+    suffix = "64",
+    symlink_preferred_arch = True,
+    symlinks = [
+        "foo_alias64",
+    ],
+)`,
+			},
+		},
+		{
+			description: "cc_binary with required, host_required and target_required modules",
+			filesystem: map[string]string{
+				"main.cpp": "",
+			},
+			blueprint: `cc_binary {
+    name: "foo",
+    srcs: ["main.cpp"],
+    required: [
+        "common_tool",
+        "make_only_tool",
+    ],
+    host_required: ["host_tool"],
+    target_required: ["device_tool"],
+
+    bazel_module: { bp2build_available: true },
+}
+
+cc_binary {
+    name: "common_tool",
+}
+
+cc_binary {
+    name: "host_tool",
+}
+
+cc_binary {
+    name: "device_tool",
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "foo",
+    data = [
+        ":common_tool",
+    ] + select({
+        "//build/bazel/platforms/os:android": [
+            ":device_tool",
+        ],
+        "//build/bazel/platforms/os:darwin": [
+            ":host_tool",
+        ],
+        "//build/bazel/platforms/os:fuchsia": [
+            ":device_tool",
+        ],
+        "//build/bazel/platforms/os:linux": [
+            ":host_tool",
+        ],
+        "//build/bazel/platforms/os:linux_bionic": [
+            ":host_tool",
+        ],
+        "//build/bazel/platforms/os:windows": [
+            ":host_tool",
+        ],
+        "//conditions:default": [],
+    }),
+    srcs = [
+        "main.cpp",
+    ],
+)`,
+			},
+		},
+		{
+			description: "cc_binary with strip.none",
+			filesystem: map[string]string{
+				"main.cpp": "",
+			},
+			blueprint: `cc_binary {
+    name: "foo",
+    srcs: ["main.cpp"],
+    strip: { none: true },
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "foo",
+    srcs = [
+        "main.cpp",
+    ],
+    strip = {
+        "none": True,
+    },
+)`,
+			},
+		},
+		{
+			description: "cc_binary with strip.all",
+			filesystem: map[string]string{
+				"main.cpp": "",
+			},
+			blueprint: `cc_binary {
+    name: "foo",
+    srcs: ["main.cpp"],
+    strip: { all: true },
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "foo",
+    srcs = [
+        "main.cpp",
+    ],
+    strip = {
+        "all": True,
+    },
+)`,
+			},
+		},
+		{
+			description: "cc_binary with strip.keep_symbols",
+			filesystem: map[string]string{
+				"main.cpp": "",
+			},
+			blueprint: `cc_binary {
+    name: "foo",
+    srcs: ["main.cpp"],
+    strip: {
+        keep_symbols: true,
+        keep_symbols_list: ["foo_symbol"],
+    },
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "foo",
+    srcs = [
+        "main.cpp",
+    ],
+    strip = {
+        "keep_symbols": True,
+        "keep_symbols_list": [
+            "foo_symbol",
+        ],
+    },
+)`,
+			},
+		},
+		{
+			description: "cc_binary with native coverage enabled for its path",
+			filesystem: map[string]string{
+				"main.cpp": "",
+			},
+			nativeCoveragePaths: []string{"*"},
+			blueprint: `cc_binary {
+    name: "foo",
+    srcs: ["main.cpp"],
+
+    bazel_module: { bp2build_available: true },
+}
+
+cc_binary {
+    name: "bar",
+    srcs: ["main.cpp"],
+    native_coverage: false,
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "bar",
+    srcs = [
+        "main.cpp",
+    ],
+)`, `cc_binary(
+    name = "foo",
+    features = [
+        "coverage",
+    ],
+    srcs = [
+        "main.cpp",
+    ],
+)`,
+			},
+		},
+		{
+			description: "cc_binary with cflags, conlyflags and cppflags",
+			filesystem: map[string]string{
+				"main.cpp": "",
+				"util.c":   "",
+			},
+			blueprint: `cc_binary {
+    name: "foo",
+    srcs: [
+        "main.cpp",
+        "util.c",
+    ],
+    cflags: ["-Wall"],
+    conlyflags: ["-std=gnu11"],
+    cppflags: ["-std=gnu++17"],
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "foo",
+    conlyflags = [
+        "-std=gnu11",
+    ],
+    copts = [
+        "-Wall",
+    ],
+    cppflags = [
+        "-std=gnu++17",
+    ],
+    srcs = [
+        "main.cpp",
+        "util.c",
+    ],
+)`,
+			},
+		},
+		{
+			description: "cc_binary with arch and os specific srcs and cflags",
+			filesystem: map[string]string{
+				"main.cpp":         "",
+				"main_arm64.cpp":   "",
+				"main_android.cpp": "",
+			},
+			blueprint: `cc_binary {
+    name: "foo",
+    srcs: ["main.cpp"],
+    cflags: ["-Wall"],
+    arch: {
+        arm64: {
+            srcs: ["main_arm64.cpp"],
+            cflags: ["-DARM64"],
+        },
+    },
+    target: {
+        android: {
+            srcs: ["main_android.cpp"],
+        },
+    },
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "foo",
+    copts = [
+        "-Wall",
+    ] + select({
+        "//build/bazel/platforms/arch:arm64": [
+            "-DARM64",
+        ],
+        "//conditions:default": [],
+    }),
+    srcs = [
+        "main.cpp",
+    ] + select({
+        "//build/bazel/platforms/arch:arm64": [
+            "main_arm64.cpp",
+        ],
+        "//conditions:default": [],
+    }) + select({
+        "//build/bazel/platforms/os:android": [
+            "main_android.cpp",
+        ],
+        "//conditions:default": [],
+    }),
+)`,
+			},
+		},
+		{
+			description: "cc_binary with static, shared and arch specific deps",
+			filesystem: map[string]string{
+				"main.cpp": "",
+			},
+			blueprint: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "static_dep",
+}
+
+cc_library_static {
+    name: "whole_static_dep",
+}
+
+cc_library_static {
+    name: "static_dep_arm64",
+}
+
+cc_library_shared {
+    name: "shared_dep",
+}
+
+cc_library_shared {
+    name: "shared_dep_android",
+}
+
+cc_binary {
+    name: "foo",
+    srcs: ["main.cpp"],
+    static_libs: ["static_dep"],
+    whole_static_libs: ["whole_static_dep"],
+    shared_libs: ["shared_dep"],
+    arch: {
+        arm64: {
+            static_libs: ["static_dep_arm64"],
+        },
+    },
+    target: {
+        android: {
+            shared_libs: ["shared_dep_android"],
+        },
+    },
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_binary(
+    name = "foo",
+    deps = [
+        ":static_dep",
+        ":whole_static_dep",
+    ] + select({
+        "//build/bazel/platforms/arch:arm64": [
+            ":static_dep_arm64",
+        ],
+        "//conditions:default": [],
+    }),
+    dynamic_deps = [
+        ":shared_dep",
+    ] + select({
+        "//build/bazel/platforms/os:android": [
+            ":shared_dep_android",
+        ],
+        "//conditions:default": [],
+    }),
+    srcs = [
+        "main.cpp",
+    ],
+)`,
+			},
+		},
+	}
+
+	dir := "."
+	for _, testCase := range testCases {
+		filesystem := make(map[string][]byte)
+		for f, content := range testCase.filesystem {
+			filesystem[f] = []byte(content)
+		}
+		config := android.TestConfig(buildDir, nil, testCase.blueprint, filesystem)
+		if len(testCase.nativeCoveragePaths) > 0 {
+			config.TestProductVariables.ClangCoverage = proptools.BoolPtr(true)
+			config.TestProductVariables.NativeCoveragePaths = testCase.nativeCoveragePaths
+		}
+		ctx := android.NewTestContext(config)
+
+		cc.RegisterCCBuildComponents(ctx)
+		ctx.RegisterModuleType("cc_binary", cc.BinaryFactory)
+		ctx.RegisterModuleType("cc_library_static", cc.LibraryStaticFactory)
+		ctx.RegisterModuleType("cc_library_shared", cc.LibrarySharedFactory)
+		ctx.RegisterBp2BuildMutator("cc_binary", cc.CcBinaryBp2Build)
+		ctx.RegisterForBazelConversion()
+
+		_, errs := ctx.ParseFileList(dir, []string{"Android.bp"})
+		if Errored(t, testCase.description, errs) {
+			continue
+		}
+		_, errs = ctx.ResolveDependencies(config)
+		if Errored(t, testCase.description, errs) {
+			continue
+		}
+
+		codegenCtx := NewCodegenContext(config, *ctx.Context, Bp2Build)
+		bazelTargets := generateBazelTargetsForDir(codegenCtx, dir)
+		if actualCount, expectedCount := len(bazelTargets), len(testCase.expectedBazelTargets); actualCount != expectedCount {
+			t.Errorf("%s: Expected %d bazel target, got %d", testCase.description, expectedCount, actualCount)
+			continue
+		}
+		for i, target := range bazelTargets {
+			if w, g := testCase.expectedBazelTargets[i], target.content; w != g {
+				t.Errorf(
+					"%s: Expected generated Bazel target to be '%s', got '%s'",
+					testCase.description,
+					w,
+					g,
+				)
+			}
+		}
+	}
+}
@@ -121,8 +121,6 @@ cc_library_headers {
     name: "foo_headers",
     export_include_dirs: ["dir-1", "dir-2"],
     header_libs: ["lib-1", "lib-2"],
-
-    // TODO: Also support export_header_lib_headers
 }`,
 			expectedBazelTargets: []string{`cc_library_headers(
     name = "foo_headers",
@@ -158,6 +156,33 @@ cc_library_headers {
     includes = [
         "lib-2",
     ],
+)`},
+		},
+		{
+			description:                        "cc_library_headers test with header_libs and export_header_lib_headers props",
+			moduleTypeUnderTest:                "cc_library_headers",
+			moduleTypeUnderTestFactory:         cc.LibraryHeaderFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryHeadersBp2Build,
+			depsMutators:                       []android.RegisterMutatorFunc{cc.RegisterDepsBp2Build},
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryPreamble + `
+cc_library_headers { name: "plain-lib" }
+cc_library_headers { name: "exported-lib" }
+cc_library_headers {
+    name: "foo_headers",
+    header_libs: ["plain-lib", "exported-lib"],
+    export_header_lib_headers: ["exported-lib"],
+}`,
+			expectedBazelTargets: []string{`cc_library_headers(
+    name = "exported-lib",
+)`, `cc_library_headers(
+    name = "foo_headers",
+    deps = [
+        ":exported-lib",
+        ":plain-lib",
+    ],
+)`, `cc_library_headers(
+    name = "plain-lib",
 )`},
 		},
 		{
@@ -258,6 +283,46 @@ cc_library_headers {
         ],
         "//conditions:default": [],
     }),
+)`},
+		},
+		{
+			description:                        "cc_library_headers test with same-named header_libs in different namespaces",
+			moduleTypeUnderTest:                "cc_library_headers",
+			moduleTypeUnderTestFactory:         cc.LibraryHeaderFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryHeadersBp2Build,
+			depsMutators:                       []android.RegisterMutatorFunc{cc.RegisterDepsBp2Build},
+			filesystem: map[string]string{
+				"a/Android.bp": `
+soong_namespace {}
+
+cc_library_headers {
+    name: "foo",
+    bazel_module: { bp2build_available: true },
+}`,
+				"b/Android.bp": `
+soong_namespace {}
+
+cc_library_headers {
+    name: "foo",
+    bazel_module: { bp2build_available: true },
+}`,
+				"c/Android.bp": `
+soong_namespace {}
+
+cc_library_headers {
+    name: "bar",
+    header_libs: ["//a:foo", "//b:foo"],
+    bazel_module: { bp2build_available: true },
+}`,
+			},
+			bp:  soongCcLibraryPreamble,
+			dir: "c",
+			expectedBazelTargets: []string{`cc_library_headers(
+    name = "bar",
+    deps = [
+        "//a:foo",
+        "//b:foo",
+    ],
 )`},
 		},
 	}
@@ -321,3 +386,69 @@ cc_library_headers {
 		}
 	}
 }
+
+func TestCcLibraryHeadersBp2BuildDefaultsInHeaderLibs(t *testing.T) {
+	bp := soongCcLibraryPreamble + `
+cc_defaults {
+    name: "foo_defaults",
+    export_include_dirs: ["dir-1"],
+}
+
+cc_library_headers {
+    name: "foo_headers",
+    header_libs: ["foo_defaults"],
+}`
+
+	config := android.TestConfig(buildDir, nil, bp, nil)
+	ctx := android.NewTestContext(config)
+	ctx.RegisterBp2BuildConfig(bp2buildConfig)
+
+	cc.RegisterCCBuildComponents(ctx)
+	ctx.RegisterModuleType("toolchain_library", cc.ToolchainLibraryFactory)
+	ctx.RegisterModuleType("cc_library_headers", cc.LibraryHeaderFactory)
+	ctx.RegisterBp2BuildMutator("cc_library_headers", cc.CcLibraryHeadersBp2Build)
+	ctx.RegisterForBazelConversion()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.ResolveDependencies(config)
+	android.FailIfNoMatchingErrors(t, `"foo_defaults" is a defaults module and cannot be used as a dependency`, errs)
+}
+
+func TestCcLibraryHeadersBp2BuildAmbiguousHeaderLib(t *testing.T) {
+	fs := map[string][]byte{
+		"a/Android.bp": []byte(`
+soong_namespace {}
+
+cc_library_headers { name: "foo" }`),
+		"b/Android.bp": []byte(`
+soong_namespace {}
+
+cc_library_headers { name: "foo" }`),
+		"c/Android.bp": []byte(`
+soong_namespace {
+    imports: ["b"],
+}
+
+cc_library_headers {
+    name: "bar",
+    header_libs: ["//a:foo"],
+    export_header_lib_headers: ["foo"],
+}`),
+	}
+
+	config := android.TestConfig(buildDir, nil, soongCcLibraryPreamble, fs)
+	ctx := android.NewTestContext(config)
+	ctx.RegisterBp2BuildConfig(bp2buildConfig)
+
+	cc.RegisterCCBuildComponents(ctx)
+	ctx.RegisterModuleType("toolchain_library", cc.ToolchainLibraryFactory)
+	ctx.RegisterModuleType("cc_library_headers", cc.LibraryHeaderFactory)
+	ctx.RegisterBp2BuildMutator("cc_library_headers", cc.CcLibraryHeadersBp2Build)
+	ctx.RegisterForBazelConversion()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp", "a/Android.bp", "b/Android.bp", "c/Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.ResolveDependencies(config)
+	android.FailIfNoMatchingErrors(t, `"foo" is ambiguous, it matches dependencies in \["a" "b"\]`, errs)
+}
@@ -0,0 +1,239 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"android/soong/android"
+	"android/soong/cc"
+	"testing"
+)
+
+func TestCcLibrarySharedBp2Build(t *testing.T) {
+	testCases := []struct {
+		description          string
+		blueprint            string
+		filesystem           map[string]string
+		expectedBazelTargets []string
+	}{
+		{
+			description: "cc_library_shared",
+			filesystem: map[string]string{
+				"foo.cpp":             "",
+				"include/foo.h":       "",
+				"local_include/bar.h": "",
+			},
+			blueprint: soongCcLibraryStaticPreamble + `
+cc_library_shared {
+    name: "foo",
+    srcs: ["foo.cpp"],
+    cflags: ["-Wall"],
+    local_include_dirs: ["local_include"],
+    export_include_dirs: ["include"],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_shared(
+    name = "foo",
+    copts = [
+        "-Wall",
+    ],
+    hdrs = [
+        "include/foo.h",
+    ],
+    includes = [
+        "include",
+        "local_include",
+    ],
+    srcs = [
+        "foo.cpp",
+    ],
+)`},
+		},
+		{
+			description: "cc_library_shared with strip properties",
+			filesystem: map[string]string{
+				"foo.cpp": "",
+			},
+			blueprint: soongCcLibraryStaticPreamble + `
+cc_library_shared {
+    name: "foo",
+    srcs: ["foo.cpp"],
+    strip: {
+        keep_symbols: true,
+        keep_symbols_list: ["foo_symbol"],
+    },
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_shared(
+    name = "foo",
+    srcs = [
+        "foo.cpp",
+    ],
+    strip = {
+        "keep_symbols": True,
+        "keep_symbols_list": [
+            "foo_symbol",
+        ],
+    },
+)`},
+		},
+		{
+			description: "cc_library_shared with srcs shared by arm and arm64",
+			filesystem: map[string]string{
+				"foo.cpp":    "",
+				"arm.cpp":    "",
+				"arm64.cpp":  "",
+				"shared.cpp": "",
+			},
+			blueprint: soongCcLibraryStaticPreamble + `
+cc_library_shared {
+    name: "foo",
+    srcs: ["foo.cpp"],
+    arch: {
+        arm: {
+            srcs: ["arm.cpp", "shared.cpp"],
+        },
+        arm64: {
+            srcs: ["shared.cpp", "arm64.cpp"],
+        },
+    },
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_shared(
+    name = "foo",
+    srcs = [
+        "foo.cpp",
+        "shared.cpp",
+    ] + select({
+        "//build/bazel/platforms/arch:arm": [
+            "arm.cpp",
+        ],
+        "//build/bazel/platforms/arch:arm64": [
+            "arm64.cpp",
+        ],
+        "//conditions:default": [],
+    }),
+)`},
+		},
+		{
+			description: "cc_library_shared with android_arm64 srcs",
+			filesystem: map[string]string{
+				"foo.cpp":           "",
+				"android_arm64.cpp": "",
+			},
+			blueprint: soongCcLibraryStaticPreamble + `
+cc_library_shared {
+    name: "foo",
+    srcs: ["foo.cpp"],
+    target: {
+        android_arm64: {
+            srcs: ["android_arm64.cpp"],
+        },
+    },
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_shared(
+    name = "foo",
+    srcs = [
+        "foo.cpp",
+    ] + select({
+        "//build/bazel/platforms:android_arm64": [
+            "android_arm64.cpp",
+        ],
+        "//conditions:default": [],
+    }),
+)`},
+		},
+		{
+			description: "cc_library_shared with stubs",
+			filesystem: map[string]string{
+				"foo.cpp":     "",
+				"foo.map.txt": "",
+			},
+			blueprint: soongCcLibraryStaticPreamble + `
+cc_library_shared {
+    name: "foo",
+    srcs: ["foo.cpp"],
+    stubs: {
+        symbol_file: "foo.map.txt",
+        versions: ["29", "30"],
+    },
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_shared(
+    name = "foo",
+    srcs = [
+        "foo.cpp",
+    ],
+)`, `cc_stub_library(
+    name = "foo_stub_libs-29",
+    source_library = ":foo",
+    symbol_file = "foo.map.txt",
+    version = "29",
+)`, `cc_stub_library(
+    name = "foo_stub_libs-30",
+    source_library = ":foo",
+    symbol_file = "foo.map.txt",
+    version = "30",
+)`, `cc_stub_library(
+    name = "foo_stub_libs-current",
+    source_library = ":foo",
+    symbol_file = "foo.map.txt",
+    version = "current",
+)`},
+		},
+	}
+
+	dir := "."
+	for _, testCase := range testCases {
+		filesystem := make(map[string][]byte)
+		for f, content := range testCase.filesystem {
+			filesystem[f] = []byte(content)
+		}
+		config := android.TestConfig(buildDir, nil, testCase.blueprint, filesystem)
+		ctx := android.NewTestContext(config)
+
+		cc.RegisterCCBuildComponents(ctx)
+		ctx.RegisterModuleType("toolchain_library", cc.ToolchainLibraryFactory)
+		ctx.RegisterModuleType("cc_library_shared", cc.LibrarySharedFactory)
+		ctx.RegisterBp2BuildMutator("cc_library_shared", cc.CcLibrarySharedBp2Build)
+		ctx.RegisterForBazelConversion()
+
+		_, errs := ctx.ParseFileList(dir, []string{"Android.bp"})
+		if Errored(t, testCase.description, errs) {
+			continue
+		}
+		_, errs = ctx.ResolveDependencies(config)
+		if Errored(t, testCase.description, errs) {
+			continue
+		}
+
+		codegenCtx := NewCodegenContext(config, *ctx.Context, Bp2Build)
+		bazelTargets := generateBazelTargetsForDir(codegenCtx, dir)
+		if actualCount, expectedCount := len(bazelTargets), len(testCase.expectedBazelTargets); actualCount != expectedCount {
+			t.Errorf("%s: Expected %d bazel target, got %d", testCase.description, expectedCount, actualCount)
+			continue
+		}
+		for i, target := range bazelTargets {
+			if w, g := testCase.expectedBazelTargets[i], target.content; w != g {
+				t.Errorf(
+					"%s: Expected generated Bazel target to be '%s', got '%s'",
+					testCase.description,
+					w,
+					g,
+				)
+			}
+		}
+	}
+}
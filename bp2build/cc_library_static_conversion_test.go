@@ -17,6 +17,7 @@ package bp2build
 import (
 	"android/soong/android"
 	"android/soong/cc"
+	"android/soong/genrule"
 	"strings"
 	"testing"
 )
@@ -244,6 +245,543 @@ cc_library_static {
     srcs = [
         "whole_static_lib_2.cc",
     ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with sanitize",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    sanitize: {
+        address: true,
+    },
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    features = [
+        "asan",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with vendor_available",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			depsMutators:                       []android.RegisterMutatorFunc{cc.RegisterDepsBp2Build},
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "vendor_dep",
+    srcs: ["vendor_dep.cc"],
+    vendor_available: true,
+    bazel_module: { bp2build_available: true },
+}
+
+cc_library_static {
+    name: "recovery_dep",
+    srcs: ["recovery_dep.cc"],
+    recovery_available: true,
+    bazel_module: { bp2build_available: true },
+}
+
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    static_libs: [
+        "recovery_dep",
+        "vendor_dep",
+    ],
+    vendor_available: true,
+    recovery_available: true,
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    deps = [
+        ":recovery_dep",
+        ":vendor_dep",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`, `cc_library_static(
+    name = "foo_static_recovery",
+    deps = [
+        ":recovery_dep_recovery",
+        ":vendor_dep",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+    target_compatible_with = [
+        "//build/bazel/platforms/image:recovery",
+    ],
+)`, `cc_library_static(
+    name = "foo_static_vendor",
+    deps = [
+        ":recovery_dep",
+        ":vendor_dep_vendor",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+    target_compatible_with = [
+        "//build/bazel/platforms/image:vendor",
+    ],
+)`, `cc_library_static(
+    name = "recovery_dep",
+    linkstatic = True,
+    srcs = [
+        "recovery_dep.cc",
+    ],
+)`, `cc_library_static(
+    name = "recovery_dep_recovery",
+    linkstatic = True,
+    srcs = [
+        "recovery_dep.cc",
+    ],
+    target_compatible_with = [
+        "//build/bazel/platforms/image:recovery",
+    ],
+)`, `cc_library_static(
+    name = "vendor_dep",
+    linkstatic = True,
+    srcs = [
+        "vendor_dep.cc",
+    ],
+)`, `cc_library_static(
+    name = "vendor_dep_vendor",
+    linkstatic = True,
+    srcs = [
+        "vendor_dep.cc",
+    ],
+    target_compatible_with = [
+        "//build/bazel/platforms/image:vendor",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with a whole archive dependency",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			depsMutators:                       []android.RegisterMutatorFunc{cc.RegisterDepsBp2Build},
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "whole_static_lib",
+    srcs: ["whole_static_lib.cc"],
+    bazel_module: { bp2build_available: true },
+}
+
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    whole_static_libs: ["whole_static_lib"],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    deps = [
+        ":whole_static_lib",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`, `cc_library_static(
+    name = "whole_static_lib",
+    alwayslink = True,
+    linkstatic = True,
+    srcs = [
+        "whole_static_lib.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with an arch-specific whole archive dependency",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			depsMutators:                       []android.RegisterMutatorFunc{cc.RegisterDepsBp2Build},
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "whole_static_lib",
+    srcs: ["whole_static_lib.cc"],
+    bazel_module: { bp2build_available: true },
+}
+
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    arch: {
+        arm64: {
+            whole_static_libs: ["whole_static_lib"],
+        },
+    },
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`, `cc_library_static(
+    name = "whole_static_lib",
+    alwayslink = True,
+    linkstatic = True,
+    srcs = [
+        "whole_static_lib.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with a whole archive dependency of an unconverted module",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			depsMutators:                       []android.RegisterMutatorFunc{cc.RegisterDepsBp2Build},
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "whole_static_lib",
+    srcs: ["whole_static_lib.cc"],
+    bazel_module: { bp2build_available: true },
+}
+
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    whole_static_libs: ["whole_static_lib"],
+    bazel_module: { bp2build_available: false },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "whole_static_lib",
+    linkstatic = True,
+    srcs = [
+        "whole_static_lib.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with arch-specific ldflags",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    ldflags: ["-Wl,--as-needed", "-shared", "-Wl,-z,defs"],
+    arch: {
+        arm: {
+            ldflags: ["-Wl,--fix-cortex-a8"],
+        },
+    },
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    linkopts = [
+        "-Wl,--as-needed",
+        "-Wl,-z,defs",
+    ] + select({
+        "//build/bazel/platforms/arch:arm": [
+            "-Wl,--fix-cortex-a8",
+        ],
+        "//conditions:default": [],
+    }),
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with version_script and dynamic_list",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    version_script: "foo.map.txt",
+    dynamic_list: "foo.dynamic.txt",
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    additional_linker_inputs = [
+        "foo.dynamic.txt",
+        "foo.map.txt",
+    ],
+    linkopts = [
+        "-Wl,--version-script,$(location foo.map.txt)",
+        "-Wl,--dynamic-list,$(location foo.dynamic.txt)",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with arch specific version_script",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    version_script: "foo.map.txt",
+    arch: {
+        arm64: {
+            version_script: "foo_arm64.map.txt",
+        },
+    },
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    additional_linker_inputs = [] + select({
+        "//build/bazel/platforms/arch:arm": [
+            "foo.map.txt",
+        ],
+        "//build/bazel/platforms/arch:arm64": [
+            "foo_arm64.map.txt",
+        ],
+        "//build/bazel/platforms/arch:riscv64": [
+            "foo.map.txt",
+        ],
+        "//build/bazel/platforms/arch:x86": [
+            "foo.map.txt",
+        ],
+        "//build/bazel/platforms/arch:x86_64": [
+            "foo.map.txt",
+        ],
+        "//conditions:default": [
+            "foo.map.txt",
+        ],
+    }),
+    linkopts = [] + select({
+        "//build/bazel/platforms/arch:arm": [
+            "-Wl,--version-script,$(location foo.map.txt)",
+        ],
+        "//build/bazel/platforms/arch:arm64": [
+            "-Wl,--version-script,$(location foo_arm64.map.txt)",
+        ],
+        "//build/bazel/platforms/arch:riscv64": [
+            "-Wl,--version-script,$(location foo.map.txt)",
+        ],
+        "//build/bazel/platforms/arch:x86": [
+            "-Wl,--version-script,$(location foo.map.txt)",
+        ],
+        "//build/bazel/platforms/arch:x86_64": [
+            "-Wl,--version-script,$(location foo.map.txt)",
+        ],
+        "//conditions:default": [
+            "-Wl,--version-script,$(location foo.map.txt)",
+        ],
+    }),
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with export_generated_headers",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			depsMutators:                       []android.RegisterMutatorFunc{cc.RegisterDepsBp2Build},
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+genrule {
+    name: "foo_gen_headers",
+    out: ["foo_gen.h"],
+    cmd: "touch $(out)",
+    bazel_module: { bp2build_available: true },
+}
+
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    generated_headers: ["foo_gen_headers"],
+    export_generated_headers: ["foo_gen_headers"],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    hdrs = [
+        ":foo_gen_headers",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with cflags, conlyflags and cppflags",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: [
+        "foo_static.c",
+        "foo_static.cc",
+    ],
+    cflags: ["-Wall"],
+    conlyflags: ["-std=gnu11"],
+    cppflags: [
+        "-std=gnu++17",
+        "-fno-rtti",
+    ],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    conlyflags = [
+        "-std=gnu11",
+    ],
+    copts = [
+        "-Wall",
+    ],
+    cppflags = [
+        "-std=gnu++17",
+        "-fno-rtti",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.c",
+        "foo_static.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with use_clang_lld: false",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    use_clang_lld: false,
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    features = [
+        "-use_lld",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with allow_undefined_symbols and pack_relocations",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    allow_undefined_symbols: true,
+    pack_relocations: false,
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    features = [
+        "disable_pack_relocations",
+        "-no_undefined_symbols",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with stubs",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    stubs: {
+        symbol_file: "foo.map.txt",
+        versions: ["29", "30"],
+    },
+    bazel_module: { bp2build_available: true },
+}`,
+			// Only shared libraries have stubs variants.
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
+)`},
+		},
+		{
+			description:                        "cc_library_static test with init_rc and vintf_fragments",
+			moduleTypeUnderTest:                "cc_library_static",
+			moduleTypeUnderTestFactory:         cc.LibraryStaticFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.CcLibraryStaticBp2Build,
+			filesystem:                         map[string]string{},
+			bp: soongCcLibraryStaticPreamble + `
+cc_library_static {
+    name: "foo_static",
+    srcs: ["foo_static.cc"],
+    init_rc: ["foo.rc"],
+    vintf_fragments: ["foo_manifest.xml"],
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`cc_library_static(
+    name = "foo_static",
+    data = [
+        "foo.rc",
+        "foo_manifest.xml",
+    ],
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+    ],
 )`},
 		},
 	}
@@ -266,6 +804,7 @@ cc_library_static {
 		cc.RegisterCCBuildComponents(ctx)
 		ctx.RegisterModuleType("toolchain_library", cc.ToolchainLibraryFactory)
 		ctx.RegisterModuleType("cc_library_headers", cc.LibraryHeaderFactory)
+		ctx.RegisterModuleType("genrule", genrule.GenRuleFactory)
 
 		ctx.RegisterModuleType(testCase.moduleTypeUnderTest, testCase.moduleTypeUnderTestFactory)
 		for _, m := range testCase.depsMutators {
@@ -305,3 +844,67 @@ cc_library_static {
 		}
 	}
 }
+
+func TestCcLibraryStaticSrcsInSubpackage(t *testing.T) {
+	filesystem := map[string][]byte{
+		"foo/Android.bp": []byte(`
+cc_library_static {
+    name: "foo_static",
+    srcs: [
+        "foo_static.cc",
+        "bar/*.h",
+        "baz/baz.h",
+    ],
+    bazel_module: { bp2build_available: true },
+}`),
+		"foo/foo_static.cc":  nil,
+		"foo/bar/Android.bp": nil,
+		"foo/bar/bar.h":      nil,
+		"foo/baz/baz.h":      nil,
+	}
+	config := android.TestConfig(buildDir, nil, soongCcLibraryStaticPreamble, filesystem)
+	ctx := android.NewTestContext(config)
+	cc.RegisterCCBuildComponents(ctx)
+	ctx.RegisterModuleType("toolchain_library", cc.ToolchainLibraryFactory)
+	ctx.RegisterModuleType("cc_library_static", cc.LibraryStaticFactory)
+	ctx.RegisterBp2BuildMutator("cc_library_static", cc.CcLibraryStaticBp2Build)
+	ctx.RegisterForBazelConversion()
+
+	_, errs := ctx.ParseFileList(".", []string{"Android.bp", "foo/Android.bp", "foo/bar/Android.bp"})
+	android.FailIfErrored(t, errs)
+	_, errs = ctx.ResolveDependencies(config)
+	android.FailIfErrored(t, errs)
+
+	codegenCtx := NewCodegenContext(config, *ctx.Context, Bp2Build)
+	buildFileToTargets, _, err := GenerateBazelTargets(codegenCtx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	expected := map[string][]string{
+		"foo": {`cc_library_static(
+    name = "foo_static",
+    linkstatic = True,
+    srcs = [
+        "foo_static.cc",
+        "//foo/bar:bar.h",
+        "baz/baz.h",
+    ],
+)`},
+		"foo/bar": {`exports_files([
+    "bar.h",
+])`},
+	}
+	for dir, expectedTargets := range expected {
+		targets := buildFileToTargets[dir]
+		if actualCount, expectedCount := len(targets), len(expectedTargets); actualCount != expectedCount {
+			t.Errorf("%s: Expected %d bazel targets, got %d: %v", dir, expectedCount, actualCount, targets)
+			continue
+		}
+		for i, target := range targets {
+			if w, g := expectedTargets[i], target.content; w != g {
+				t.Errorf("%s: Expected generated Bazel target to be '%s', got '%s'", dir, w, g)
+			}
+		}
+	}
+}
@@ -234,6 +234,40 @@ cc_object {
     copts = [
         "-fno-addrsig",
     ],
+)`,
+			},
+		},
+		{
+			description:                        "cc_object with debuggable srcs",
+			moduleTypeUnderTest:                "cc_object",
+			moduleTypeUnderTestFactory:         cc.ObjectFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.ObjectBp2Build,
+			blueprint: `cc_object {
+    name: "foo",
+    include_build_directory: false,
+    srcs: ["a.c"],
+    product_variables: {
+        debuggable: {
+            srcs: ["debug.c"],
+        },
+    },
+
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{`cc_object(
+    name = "foo",
+    copts = [
+        "-fno-addrsig",
+    ],
+    srcs = [
+        "a.c",
+    ] + select({
+        "//build/bazel/product_variables:debuggable": [
+            "debug.c",
+        ],
+        "//conditions:default": [],
+    }),
 )`,
 			},
 		},
@@ -412,6 +446,97 @@ func TestCcObjectConfigurableAttributesBp2Build(t *testing.T) {
         ],
         "//conditions:default": [],
     }),
+)`,
+			},
+		},
+		{
+			description:                        "cc_object setting srcs for an x86 arch feature",
+			moduleTypeUnderTest:                "cc_object",
+			moduleTypeUnderTestFactory:         cc.ObjectFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.ObjectBp2Build,
+			blueprint: `cc_object {
+    name: "foo",
+    srcs: ["base.cpp"],
+    arch: {
+        x86: {
+            srcs: ["x86.cpp"],
+            avx2: {
+                srcs: ["x86_avx2.cpp"],
+            },
+        },
+    },
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{
+				`cc_object(
+    name = "foo",
+    copts = [
+        "-fno-addrsig",
+    ],
+    local_include_dirs = [
+        ".",
+    ],
+    srcs = [
+        "base.cpp",
+    ] + select({
+        "//build/bazel/platforms/arch:x86": [
+            "x86.cpp",
+        ],
+        "//conditions:default": [],
+    }) + select({
+        "//build/bazel/platforms/arch/variants:x86_avx2": [
+            "x86_avx2.cpp",
+        ],
+        "//conditions:default": [],
+    }),
+)`,
+			},
+		},
+		{
+			description:                        "cc_object excluding srcs for one arch",
+			moduleTypeUnderTest:                "cc_object",
+			moduleTypeUnderTestFactory:         cc.ObjectFactory,
+			moduleTypeUnderTestBp2BuildMutator: cc.ObjectBp2Build,
+			filesystem: map[string]string{
+				"common.c":       "",
+				"generic.c":      "",
+				"arch/arm/a.S":   "",
+				"arch/arm/b.S":   "",
+				"arch/arm/c.txt": "",
+			},
+			blueprint: `cc_object {
+    name: "foo",
+    srcs: ["*.c"],
+    arch: {
+        arm: {
+            srcs: ["arch/arm/*.S"],
+            exclude_srcs: ["generic.c"],
+        },
+    },
+    bazel_module: { bp2build_available: true },
+}
+`,
+			expectedBazelTargets: []string{
+				`cc_object(
+    name = "foo",
+    copts = [
+        "-fno-addrsig",
+    ],
+    local_include_dirs = [
+        ".",
+    ],
+    srcs = [
+        "common.c",
+    ] + select({
+        "//build/bazel/platforms/arch:arm": [
+            "arch/arm/a.S",
+            "arch/arm/b.S",
+        ],
+        "//conditions:default": [
+            "generic.c",
+        ],
+    }),
 )`,
 			},
 		},
@@ -423,6 +548,9 @@ func TestCcObjectConfigurableAttributesBp2Build(t *testing.T) {
 		toParse := []string{
 			"Android.bp",
 		}
+		for f, content := range testCase.filesystem {
+			filesystem[f] = []byte(content)
+		}
 		config := android.TestConfig(buildDir, nil, testCase.blueprint, filesystem)
 		ctx := android.NewTestContext(config)
 		// Always register cc_defaults module factory
@@ -460,3 +588,52 @@ func TestCcObjectConfigurableAttributesBp2Build(t *testing.T) {
 		}
 	}
 }
+
+func TestCcObjectBp2BuildStrictUnconvertedProperties(t *testing.T) {
+	bp := `cc_object {
+    name: "foo",
+    include_build_directory: false,
+    product_variables: {
+        platform_sdk_version: {
+            cflags: ["-DPLATFORM_SDK_VERSION=%d"],
+        },
+    },
+    bazel_module: { bp2build_available: true },
+}
+`
+	newCodegenContext := func() *CodegenContext {
+		config := android.TestConfig(buildDir, nil, bp, nil)
+		ctx := android.NewTestContext(config)
+		ctx.RegisterModuleType("cc_object", cc.ObjectFactory)
+		ctx.RegisterBp2BuildMutator("cc_object", cc.ObjectBp2Build)
+		ctx.RegisterForBazelConversion()
+
+		_, errs := ctx.ParseFileList(".", []string{"Android.bp"})
+		android.FailIfErrored(t, errs)
+		_, errs = ctx.ResolveDependencies(config)
+		android.FailIfErrored(t, errs)
+		return NewCodegenContext(config, *ctx.Context, Bp2Build)
+	}
+
+	// The unconverted cflags are dropped by default.
+	bazelTargets := generateBazelTargetsForDir(newCodegenContext(), ".")
+	expectedBazelTarget := `cc_object(
+    name = "foo",
+    copts = [
+        "-fno-addrsig",
+    ],
+)`
+	if len(bazelTargets) != 1 {
+		t.Fatalf("Expected 1 bazel target, got %d", len(bazelTargets))
+	} else if g := bazelTargets[0].content; g != expectedBazelTarget {
+		t.Errorf("Expected generated Bazel target to be '%s', got '%s'", expectedBazelTarget, g)
+	}
+
+	// They fail the conversion in strict mode.
+	codegenCtx := newCodegenContext()
+	codegenCtx.SetStrict(true)
+	expectedErr := `could not convert properties ["product_variables.platform_sdk_version.cflags"]`
+	if _, _, err := GenerateBazelTargets(codegenCtx); err == nil || !strings.Contains(err.Error(), expectedErr) {
+		t.Errorf("Expected strict conversion to fail with an error containing %q, got %v", expectedErr, err)
+	}
+}
@@ -10,9 +10,14 @@ import (
 // Configurability support for bp2build.
 
 // prettyPrintStringListAttribute converts a StringListAttribute to its Bazel
-// syntax. May contain a select statement.
+// syntax. May contain select statements.
 func prettyPrintStringListAttribute(stringList bazel.StringListAttribute, indent int) (string, error) {
-	ret, err := prettyPrint(reflect.ValueOf(stringList.Value), indent)
+	// Values of the common arch apply to every arch, so are part of the base value.
+	value := stringList.Value
+	if common := stringList.GetValueForArch(bazel.ARCH_COMMON); len(common) > 0 {
+		value = append(android.CopyOf(value), common...)
+	}
+	ret, err := prettyPrint(reflect.ValueOf(value), indent)
 	if err != nil {
 		return ret, err
 	}
@@ -24,19 +29,131 @@ func prettyPrintStringListAttribute(stringList bazel.StringListAttribute, indent
 
 	// Create the selects for arch specific values.
 	selects := map[string]reflect.Value{}
-	for arch, selectKey := range bazel.PlatformArchMap {
-		selects[selectKey] = reflect.ValueOf(stringList.GetValueForArch(arch))
+	for _, arch := range bazel.SelectableArchs() {
+		selects[bazel.PlatformArchMap[arch]] = reflect.ValueOf(stringList.GetValueForArch(arch))
+	}
+
+	selectMap, err := prettyPrintSelectMap(selects, reflect.ValueOf(stringList.ArchValues.ConditionsDefault), indent)
+	if err != nil {
+		return "", err
 	}
+	ret += selectMap
 
-	selectMap, err := prettyPrintSelectMap(selects, "[]", indent)
+	// Create the selects for target os specific values.
+	osSelects := map[string]reflect.Value{}
+	for os, selectKey := range bazel.PlatformOsMap {
+		osSelects[selectKey] = reflect.ValueOf(stringList.GetValueForOS(os))
+	}
+	selectMap, err = prettyPrintSelectMap(osSelects, reflect.ValueOf([]string(nil)), indent)
 	return ret + selectMap, err
 }
 
+// prettyPrintStringAttribute converts a StringAttribute to its Bazel syntax. May be a select
+// statement.
+func prettyPrintStringAttribute(str bazel.StringAttribute, indent int) (string, error) {
+	// The value of the common arch applies to every arch, so it replaces the base value.
+	value := str.Value
+	if common := str.GetValueForArch(bazel.ARCH_COMMON); common != "" {
+		value = common
+	}
+	if !str.HasConfigurableValues() {
+		// Select statement not needed.
+		return prettyPrint(reflect.ValueOf(value), indent)
+	}
+
+	archSelects := map[string]reflect.Value{}
+	for _, arch := range bazel.SelectableArchs() {
+		if value := str.GetValueForArch(arch); value != "" {
+			archSelects[bazel.PlatformArchMap[arch]] = reflect.ValueOf(value)
+		}
+	}
+	osSelects := map[string]reflect.Value{}
+	for os, selectKey := range bazel.PlatformOsMap {
+		if value := str.GetValueForOS(os); value != "" {
+			osSelects[selectKey] = reflect.ValueOf(value)
+		}
+	}
+	return prettyPrintScalarSelect(archSelects, osSelects, reflect.ValueOf(value), indent)
+}
+
+// prettyPrintLabelAttribute converts a LabelAttribute to its Bazel syntax. May be a select
+// statement.
+func prettyPrintLabelAttribute(label bazel.LabelAttribute, indent int) (string, error) {
+	// The value of the common arch applies to every arch, so it replaces the base value.
+	value := label.Value
+	if common := label.GetValueForArch(bazel.ARCH_COMMON); common.Label != "" {
+		value = common
+	}
+	if !label.HasConfigurableValues() {
+		// Select statement not needed.
+		return prettyPrint(reflect.ValueOf(value.Label), indent)
+	}
+
+	archSelects := map[string]reflect.Value{}
+	for _, arch := range bazel.SelectableArchs() {
+		if value := label.GetValueForArch(arch); value.Label != "" {
+			archSelects[bazel.PlatformArchMap[arch]] = reflect.ValueOf(value.Label)
+		}
+	}
+	osSelects := map[string]reflect.Value{}
+	for os, selectKey := range bazel.PlatformOsMap {
+		if value := label.GetValueForOS(os); value.Label != "" {
+			osSelects[selectKey] = reflect.ValueOf(value.Label)
+		}
+	}
+	return prettyPrintScalarSelect(archSelects, osSelects, reflect.ValueOf(value.Label), indent)
+}
+
+// prettyPrintScalarSelect converts the arch or os specific values of a scalar attribute to a
+// select statement. As a scalar value can't be concatenated with a select, the base value is used
+// for the //conditions:default branch, so the attribute can't be configured by both arch and os.
+func prettyPrintScalarSelect(archSelects, osSelects map[string]reflect.Value, defaultValue reflect.Value, indent int) (string, error) {
+	selects := archSelects
+	if len(osSelects) > 0 {
+		if len(archSelects) > 0 {
+			return "", fmt.Errorf("scalar attribute can't be configured by both arch and os")
+		}
+		selects = osSelects
+	}
+
+	ret := "select({\n"
+	for _, selectKey := range android.SortedStringKeys(selects) {
+		entry, err := prettyPrintSelectEntry(selects[selectKey], selectKey, indent)
+		if err != nil {
+			return "", err
+		}
+		ret += entry + ",\n"
+	}
+	// An unset base value leaves the attribute at its default for other configurations.
+	if isZero(defaultValue) {
+		ret += makeIndent(indent + 1)
+		ret += "\"//conditions:default\": None,\n"
+	} else {
+		defaultEntry, err := prettyPrintSelectEntry(defaultValue, "//conditions:default", indent)
+		if err != nil {
+			return "", err
+		}
+		ret += defaultEntry + ",\n"
+	}
+	ret += makeIndent(indent)
+	ret += "})"
+	return ret, nil
+}
+
 // prettyPrintLabelListAttribute converts a LabelListAttribute to its Bazel
 // syntax. May contain select statements.
 func prettyPrintLabelListAttribute(labels bazel.LabelListAttribute, indent int) (string, error) {
 	// TODO(b/165114590): convert glob syntax
-	ret, err := prettyPrint(reflect.ValueOf(labels.Value.Includes), indent)
+	// Globs are expanded by Soong, so excluded labels are subtracted from the base value by moving
+	// them into the selects.
+	labels.ResolveExcludes()
+
+	// Values of the common arch apply to every arch, so are part of the base value.
+	value := labels.Value.Includes
+	if common := labels.GetValueForArch(bazel.ARCH_COMMON).Includes; len(common) > 0 {
+		value = append(append([]bazel.Label(nil), value...), common...)
+	}
+	ret, err := prettyPrint(reflect.ValueOf(value), indent)
 	if err != nil {
 		return ret, err
 	}
@@ -48,10 +165,11 @@ func prettyPrintLabelListAttribute(labels bazel.LabelListAttribute, indent int)
 
 	// Create the selects for arch specific values.
 	archSelects := map[string]reflect.Value{}
-	for arch, selectKey := range bazel.PlatformArchMap {
-		archSelects[selectKey] = reflect.ValueOf(labels.GetValueForArch(arch).Includes)
+	for _, arch := range bazel.SelectableArchs() {
+		archSelects[bazel.PlatformArchMap[arch]] = reflect.ValueOf(labels.GetValueForArch(arch).Includes)
 	}
-	selectMap, err := prettyPrintSelectMap(archSelects, "[]", indent)
+	selectMap, err := prettyPrintSelectMap(archSelects,
+		reflect.ValueOf(labels.ArchValues.ConditionsDefault.Includes), indent)
 	if err != nil {
 		return "", err
 	}
@@ -62,13 +180,69 @@ func prettyPrintLabelListAttribute(labels bazel.LabelListAttribute, indent int)
 	for os, selectKey := range bazel.PlatformOsMap {
 		osSelects[selectKey] = reflect.ValueOf(labels.GetValueForOS(os).Includes)
 	}
-	selectMap, err = prettyPrintSelectMap(osSelects, "[]", indent)
-	return ret + selectMap, err
+	selectMap, err = prettyPrintSelectMap(osSelects,
+		reflect.ValueOf(labels.OsValues.ConditionsDefault.Includes), indent)
+	if err != nil {
+		return "", err
+	}
+	ret += selectMap
+
+	// Create the select for values specific to an arch and os, keyed by the platform of the
+	// combination.
+	archOsSelects := map[string]reflect.Value{}
+	for platform, value := range labels.ArchOsValues {
+		archOsSelects[platform] = reflect.ValueOf(value.Includes)
+	}
+	selectMap, err = prettyPrintSelectMap(archOsSelects, reflect.ValueOf([]bazel.Label(nil)), indent)
+	if err != nil {
+		return "", err
+	}
+	ret += selectMap
+
+	// Multiple features can be supported by a target at once, multiple product variables can be
+	// set at once, and min_sdk_version ranges overlap, so their values can't share a select without
+	// making it ambiguous.
+	selectMap, err = prettyPrintOverlappingSelects(labels.ArchFeatureValues, indent)
+	if err != nil {
+		return "", err
+	}
+	ret += selectMap
+
+	selectMap, err = prettyPrintOverlappingSelects(labels.ProductVariableValues, indent)
+	if err != nil {
+		return "", err
+	}
+	ret += selectMap
+
+	selectMap, err = prettyPrintOverlappingSelects(labels.MinSdkVersionValues, indent)
+	if err != nil {
+		return "", err
+	}
+	ret += selectMap
+	return ret, nil
+}
+
+// prettyPrintOverlappingSelects converts label list values keyed by config_settings which may
+// match at the same time into a separate select for each config_setting.
+func prettyPrintOverlappingSelects(values map[string]bazel.LabelList, indent int) (string, error) {
+	var ret string
+	for _, selectKey := range android.SortedStringKeys(values) {
+		singleSelect := map[string]reflect.Value{
+			selectKey: reflect.ValueOf(values[selectKey].Includes),
+		}
+		selectMap, err := prettyPrintSelectMap(singleSelect, reflect.ValueOf([]string(nil)), indent)
+		if err != nil {
+			return "", err
+		}
+		ret += selectMap
+	}
+	return ret, nil
 }
 
 // prettyPrintSelectMap converts a map of select keys to reflected Values as a generic way
-// to construct a select map for any kind of attribute type.
-func prettyPrintSelectMap(selectMap map[string]reflect.Value, defaultValue string, indent int) (string, error) {
+// to construct a select map for any kind of attribute type. defaultValue is used for the
+// //conditions:default branch, and is printed as an empty list if it is a zero value.
+func prettyPrintSelectMap(selectMap map[string]reflect.Value, defaultValue reflect.Value, indent int) (string, error) {
 	var selects string
 	for _, selectKey := range android.SortedStringKeys(selectMap) {
 		value := selectMap[selectKey]
@@ -83,7 +257,7 @@ func prettyPrintSelectMap(selectMap map[string]reflect.Value, defaultValue strin
 		selects += s + ",\n"
 	}
 
-	if len(selects) == 0 {
+	if len(selects) == 0 && isZero(defaultValue) {
 		// No conditions (or all values are empty lists), so no need for a map.
 		return "", nil
 	}
@@ -92,7 +266,11 @@ func prettyPrintSelectMap(selectMap map[string]reflect.Value, defaultValue strin
 	ret := " + select({\n"
 	ret += selects
 	// default condition comes last.
-	ret += fmt.Sprintf("%s\"%s\": %s,\n", makeIndent(indent+1), "//conditions:default", defaultValue)
+	defaultEntry, err := prettyPrintSelectEntry(defaultValue, "//conditions:default", indent)
+	if err != nil {
+		return "", err
+	}
+	ret += defaultEntry + ",\n"
 	ret += makeIndent(indent)
 	ret += "})"
 
@@ -0,0 +1,419 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bp2build
+
+import (
+	"android/soong/bazel"
+	"testing"
+)
+
+func TestPrettyPrintLabelListAttributeConditionsDefault(t *testing.T) {
+	testCases := []struct {
+		description string
+		attr        func() bazel.LabelListAttribute
+		expected    string
+	}{
+		{
+			description: "arch select with fallback dep",
+			attr: func() bazel.LabelListAttribute {
+				attr := bazel.MakeLabelListAttribute(bazel.LabelList{Includes: []bazel.Label{{Label: ":base"}}})
+				attr.SetValueForArch(bazel.ARCH_ARM, bazel.LabelList{Includes: []bazel.Label{{Label: ":arm_dep"}}})
+				attr.ArchValues.ConditionsDefault = bazel.LabelList{Includes: []bazel.Label{{Label: ":fallback"}}}
+				return attr
+			},
+			expected: `[
+    ":base",
+] + select({
+    "//build/bazel/platforms/arch:arm": [
+        ":arm_dep",
+    ],
+    "//conditions:default": [
+        ":fallback",
+    ],
+})`,
+		},
+		{
+			description: "os select with only a fallback dep",
+			attr: func() bazel.LabelListAttribute {
+				attr := bazel.LabelListAttribute{}
+				attr.OsValues.ConditionsDefault = bazel.LabelList{Includes: []bazel.Label{{Label: ":fallback"}}}
+				return attr
+			},
+			expected: `[] + select({
+    "//conditions:default": [
+        ":fallback",
+    ],
+})`,
+		},
+		{
+			description: "arch select with a riscv64 dep",
+			attr: func() bazel.LabelListAttribute {
+				attr := bazel.MakeLabelListAttribute(bazel.LabelList{Includes: []bazel.Label{{Label: ":base"}}})
+				attr.SetValueForArch(bazel.ARCH_RISCV64, bazel.LabelList{Includes: []bazel.Label{{Label: ":riscv64_dep"}}})
+				return attr
+			},
+			expected: `[
+    ":base",
+] + select({
+    "//build/bazel/platforms/arch:riscv64": [
+        ":riscv64_dep",
+    ],
+    "//conditions:default": [],
+})`,
+		},
+	}
+
+	for _, tc := range testCases {
+		actual, err := prettyPrintLabelListAttribute(tc.attr(), 0)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.description, err)
+			continue
+		}
+		if actual != tc.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tc.description, tc.expected, actual)
+		}
+	}
+}
+
+func TestPrettyPrintAttributesWithRestrictedSelectableArchs(t *testing.T) {
+	bazel.SetSelectableArchs([]string{bazel.ARCH_ARM64})
+	defer bazel.SetSelectableArchs(nil)
+
+	labels := bazel.MakeLabelListAttribute(bazel.LabelList{Includes: []bazel.Label{{Label: ":base"}}})
+	labels.SetValueForArch(bazel.ARCH_ARM64, bazel.LabelList{Includes: []bazel.Label{{Label: ":arm64_dep"}}})
+	labels.SetValueForArch(bazel.ARCH_X86, bazel.LabelList{Includes: []bazel.Label{{Label: ":x86_dep"}}})
+	actual, err := prettyPrintLabelListAttribute(labels, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `[
+    ":base",
+] + select({
+    "//build/bazel/platforms/arch:arm64": [
+        ":arm64_dep",
+    ],
+    "//conditions:default": [],
+})`
+	if actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+
+	var flags bazel.StringListAttribute
+	flags.Value = []string{"-base"}
+	flags.SetValueForArch(bazel.ARCH_X86, []string{"-x86"})
+	actual, err = prettyPrintStringListAttribute(flags, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = `[
+    "-base",
+]`
+	if actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestPrettyPrintLabelListAttributeMinSdkVersion(t *testing.T) {
+	attr := bazel.MakeLabelListAttribute(bazel.LabelList{Includes: []bazel.Label{{Label: ":base"}}})
+	attr.SetValueForMinSdkVersion(30, bazel.LabelList{Includes: []bazel.Label{{Label: ":api30_dep"}}})
+	attr.SetValueForMinSdkVersion(29, bazel.LabelList{Includes: []bazel.Label{{Label: ":api29_dep"}}})
+
+	actual, err := prettyPrintLabelListAttribute(attr, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `[
+    ":base",
+] + select({
+    "//build/bazel/rules/apex:min_sdk_version_at_least_29": [
+        ":api29_dep",
+    ],
+    "//conditions:default": [],
+}) + select({
+    "//build/bazel/rules/apex:min_sdk_version_at_least_30": [
+        ":api30_dep",
+    ],
+    "//conditions:default": [],
+})`
+	if actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestPrettyPrintStringAttribute(t *testing.T) {
+	testCases := []struct {
+		description string
+		attr        func() bazel.StringAttribute
+		expected    string
+	}{
+		{
+			description: "scalar value",
+			attr: func() bazel.StringAttribute {
+				return bazel.StringAttribute{Value: "libc++"}
+			},
+			expected: `"libc++"`,
+		},
+		{
+			description: "arch select with base value as default",
+			attr: func() bazel.StringAttribute {
+				attr := bazel.StringAttribute{Value: "base.lds"}
+				attr.SetValueForArch(bazel.ARCH_X86, "x86.lds")
+				attr.SetValueForArch(bazel.ARCH_ARM, "arm.lds")
+				return attr
+			},
+			expected: `select({
+    "//build/bazel/platforms/arch:arm": "arm.lds",
+    "//build/bazel/platforms/arch:x86": "x86.lds",
+    "//conditions:default": "base.lds",
+})`,
+		},
+		{
+			description: "common arch value",
+			attr: func() bazel.StringAttribute {
+				attr := bazel.StringAttribute{Value: "base.lds"}
+				attr.SetValueForArch(bazel.ARCH_COMMON, "common.lds")
+				return attr
+			},
+			expected: `"common.lds"`,
+		},
+		{
+			description: "arch select with common arch value as default",
+			attr: func() bazel.StringAttribute {
+				attr := bazel.StringAttribute{}
+				attr.SetValueForArch(bazel.ARCH_COMMON, "common.lds")
+				attr.SetValueForArch(bazel.ARCH_ARM, "arm.lds")
+				return attr
+			},
+			expected: `select({
+    "//build/bazel/platforms/arch:arm": "arm.lds",
+    "//conditions:default": "common.lds",
+})`,
+		},
+		{
+			description: "os select without a base value",
+			attr: func() bazel.StringAttribute {
+				attr := bazel.StringAttribute{}
+				attr.SetValueForOS(bazel.OS_ANDROID, "libc++")
+				return attr
+			},
+			expected: `select({
+    "//build/bazel/platforms/os:android": "libc++",
+    "//conditions:default": None,
+})`,
+		},
+	}
+
+	for _, tc := range testCases {
+		actual, err := prettyPrintStringAttribute(tc.attr(), 0)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.description, err)
+			continue
+		}
+		if actual != tc.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tc.description, tc.expected, actual)
+		}
+	}
+}
+
+func TestPrettyPrintStringAttributeArchAndOsValues(t *testing.T) {
+	attr := bazel.StringAttribute{}
+	attr.SetValueForArch(bazel.ARCH_ARM64, "arm64.lds")
+	attr.SetValueForOS(bazel.OS_ANDROID, "android.lds")
+	if _, err := prettyPrintStringAttribute(attr, 0); err == nil {
+		t.Errorf("expected an error for a string attribute configured by both arch and os")
+	}
+}
+
+func TestPrettyPrintLabelAttribute(t *testing.T) {
+	testCases := []struct {
+		description string
+		attr        func() bazel.LabelAttribute
+		expected    string
+	}{
+		{
+			description: "unset",
+			attr: func() bazel.LabelAttribute {
+				return bazel.LabelAttribute{}
+			},
+			expected: ``,
+		},
+		{
+			description: "set",
+			attr: func() bazel.LabelAttribute {
+				return bazel.LabelAttribute{Value: bazel.Label{Label: ":libfoo.map.txt"}}
+			},
+			expected: `":libfoo.map.txt"`,
+		},
+		{
+			description: "arch select",
+			attr: func() bazel.LabelAttribute {
+				attr := bazel.LabelAttribute{Value: bazel.Label{Label: ":base.map.txt"}}
+				attr.SetValueForArch(bazel.ARCH_ARM64, bazel.Label{Label: ":arm64.map.txt"})
+				return attr
+			},
+			expected: `select({
+    "//build/bazel/platforms/arch:arm64": ":arm64.map.txt",
+    "//conditions:default": ":base.map.txt",
+})`,
+		},
+		{
+			description: "common arch value",
+			attr: func() bazel.LabelAttribute {
+				attr := bazel.LabelAttribute{Value: bazel.Label{Label: ":base.map.txt"}}
+				attr.SetValueForArch(bazel.ARCH_COMMON, bazel.Label{Label: ":common.map.txt"})
+				return attr
+			},
+			expected: `":common.map.txt"`,
+		},
+		{
+			description: "arch select with common arch value as default",
+			attr: func() bazel.LabelAttribute {
+				attr := bazel.LabelAttribute{}
+				attr.SetValueForArch(bazel.ARCH_COMMON, bazel.Label{Label: ":common.map.txt"})
+				attr.SetValueForArch(bazel.ARCH_ARM64, bazel.Label{Label: ":arm64.map.txt"})
+				return attr
+			},
+			expected: `select({
+    "//build/bazel/platforms/arch:arm64": ":arm64.map.txt",
+    "//conditions:default": ":common.map.txt",
+})`,
+		},
+		{
+			description: "arch select without a base value",
+			attr: func() bazel.LabelAttribute {
+				attr := bazel.LabelAttribute{}
+				attr.SetValueForArch(bazel.ARCH_X86, bazel.Label{Label: ":x86.map.txt"})
+				return attr
+			},
+			expected: `select({
+    "//build/bazel/platforms/arch:x86": ":x86.map.txt",
+    "//conditions:default": None,
+})`,
+		},
+	}
+
+	for _, tc := range testCases {
+		actual, err := prettyPrintLabelAttribute(tc.attr(), 0)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.description, err)
+			continue
+		}
+		if actual != tc.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tc.description, tc.expected, actual)
+		}
+	}
+}
+
+func TestPrettyPrintStringListAttributeOsValues(t *testing.T) {
+	var flags bazel.StringListAttribute
+	flags.Value = []string{"-base"}
+	flags.SetValueForArch(bazel.ARCH_ARM, []string{"-arm"})
+	flags.SetValueForOS(bazel.OS_ANDROID, []string{"-android"})
+	flags.SetValueForOS(bazel.OS_LINUX, []string{"-linux"})
+	flags.SetValueForOS(bazel.OS_DARWIN, []string{"-darwin"})
+
+	actual, err := prettyPrintStringListAttribute(flags, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `[
+    "-base",
+] + select({
+    "//build/bazel/platforms/arch:arm": [
+        "-arm",
+    ],
+    "//conditions:default": [],
+}) + select({
+    "//build/bazel/platforms/os:android": [
+        "-android",
+    ],
+    "//build/bazel/platforms/os:darwin": [
+        "-darwin",
+    ],
+    "//build/bazel/platforms/os:linux": [
+        "-linux",
+    ],
+    "//conditions:default": [],
+})`
+	if actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestPrettyPrintCommonArchValues(t *testing.T) {
+	labels := bazel.MakeLabelListAttribute(bazel.LabelList{Includes: []bazel.Label{{Label: ":base"}}})
+	labels.SetValueForArch(bazel.ARCH_COMMON, bazel.LabelList{Includes: []bazel.Label{{Label: ":common"}}})
+	labels.SetValueForArch(bazel.ARCH_ARM, bazel.LabelList{Includes: []bazel.Label{{Label: ":arm"}}})
+	actual, err := prettyPrintLabelListAttribute(labels, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `[
+    ":base",
+    ":common",
+] + select({
+    "//build/bazel/platforms/arch:arm": [
+        ":arm",
+    ],
+    "//conditions:default": [],
+})`
+	if actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+
+	var flags bazel.StringListAttribute
+	flags.SetValueForArch(bazel.ARCH_COMMON, []string{"-common"})
+	actual, err = prettyPrintStringListAttribute(flags, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = `[
+    "-common",
+]`
+	if actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestPrettyPrintLabelListAttributeArchOsValues(t *testing.T) {
+	attr := bazel.MakeLabelListAttribute(bazel.LabelList{Includes: []bazel.Label{{Label: ":base"}}})
+	attr.SetValueForArch(bazel.ARCH_ARM64, bazel.LabelList{Includes: []bazel.Label{{Label: ":arm64_dep"}}})
+	attr.SetValueForArchOS(bazel.ARCH_ARM64, bazel.OS_ANDROID, bazel.LabelList{Includes: []bazel.Label{{Label: ":android_arm64_dep"}}})
+	attr.SetValueForArchOS(bazel.ARCH_X86_64, bazel.OS_LINUX, bazel.LabelList{Includes: []bazel.Label{{Label: ":linux_x86_64_dep"}}})
+
+	actual, err := prettyPrintLabelListAttribute(attr, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `[
+    ":base",
+] + select({
+    "//build/bazel/platforms/arch:arm64": [
+        ":arm64_dep",
+    ],
+    "//conditions:default": [],
+}) + select({
+    "//build/bazel/platforms:android_arm64": [
+        ":android_arm64_dep",
+    ],
+    "//build/bazel/platforms:linux_x86_64": [
+        ":linux_x86_64_dep",
+    ],
+    "//conditions:default": [],
+})`
+	if actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
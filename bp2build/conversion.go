@@ -2,6 +2,7 @@ package bp2build
 
 import (
 	"android/soong/android"
+	"io"
 	"reflect"
 	"sort"
 	"strings"
@@ -48,32 +49,61 @@ func CreateBazelFiles(
 func createBuildFiles(buildToTargets map[string]BazelTargets, mode CodegenMode) []BazelFile {
 	files := make([]BazelFile, 0, len(buildToTargets))
 	for _, dir := range android.SortedStringKeys(buildToTargets) {
-		targets := buildToTargets[dir]
-		sort.Slice(targets, func(i, j int) bool {
-			// this will cover all bp2build generated targets
-			if targets[i].name < targets[j].name {
-				return true
-			}
-			// give a strict ordering to content from hand-crafted targets
-			return targets[i].content < targets[j].content
-		})
-		content := soongModuleLoad
-		if mode == Bp2Build {
-			content = `# This file was automatically generated by bp2build for the Bazel migration project.
+		var content strings.Builder
+		// writing to a strings.Builder never fails
+		writeBuildFile(&content, buildToTargets[dir], mode)
+		files = append(files, newFile(dir, GeneratedBuildFileName, content.String()))
+	}
+	return files
+}
+
+// sortBazelTargets sorts the targets of a BUILD file into the order they are written in.
+func sortBazelTargets(targets BazelTargets) {
+	sort.Slice(targets, func(i, j int) bool {
+		// variables hoisted out of the targets must be defined before they are used
+		if targets[i].hoisted != targets[j].hoisted {
+			return targets[i].hoisted
+		}
+		// this will cover all bp2build generated targets
+		if targets[i].name != targets[j].name {
+			return targets[i].name < targets[j].name
+		}
+		// give a strict ordering to content from hand-crafted targets
+		return targets[i].content < targets[j].content
+	})
+}
+
+// writeBuildFile writes the contents of the BUILD file for targets to w. The targets are written
+// one at a time, so that the contents of a large BUILD file are never concatenated in memory.
+func writeBuildFile(w io.Writer, targets BazelTargets, mode CodegenMode) error {
+	sortBazelTargets(targets)
+	header := soongModuleLoad
+	if mode == Bp2Build {
+		header = `# This file was automatically generated by bp2build for the Bazel migration project.
 # Feel free to edit or test it, but do *not* check it into your version control system.`
-			content += "\n\n"
-			content += "package(default_visibility = [\"//visibility:public\"])"
-			content += "\n\n"
-			content += targets.LoadStatements()
+		header += "\n\n"
+		header += "package(default_visibility = [\"//visibility:public\"])"
+		header += "\n\n"
+		header += targets.LoadStatements()
+	}
+	if header != "" {
+		// If there are load statements, add a couple of newlines.
+		header += "\n\n"
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	for i, target := range targets {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n\n"); err != nil {
+				return err
+			}
 		}
-		if content != "" {
-			// If there are load statements, add a couple of newlines.
-			content += "\n\n"
+		if _, err := io.WriteString(w, target.content); err != nil {
+			return err
 		}
-		content += targets.String()
-		files = append(files, newFile(dir, GeneratedBuildFileName, content))
 	}
-	return files
+	return nil
 }
 
 func newFile(dir, basename, content string) BazelFile {
@@ -115,6 +145,13 @@ func shouldGenerateAttribute(prop string) bool {
 	return !ignoredPropNames[prop]
 }
 
+// shouldGenerateBp2BuildAttribute returns whether an attribute of a target created by a bp2build
+// converter is generated. Unlike Soong module properties, these are Bazel attributes, so may set
+// the built-in features attribute.
+func shouldGenerateBp2BuildAttribute(prop string) bool {
+	return prop == "features" || shouldGenerateAttribute(prop)
+}
+
 func shouldSkipStructField(field reflect.StructField) bool {
 	if field.PkgPath != "" {
 		// Skip unexported fields. Some properties are
@@ -15,6 +15,8 @@
 package bp2build
 
 import (
+	"android/soong/bazel"
+	"bytes"
 	"sort"
 	"testing"
 )
@@ -85,3 +87,56 @@ func TestCreateBazelFiles_Bp2Build_CreatesNoFilesWithNoTargets(t *testing.T) {
 		t.Errorf("Expected no files, got %d", len(files))
 	}
 }
+
+func TestWriteBuildFile_MatchesBufferedContents(t *testing.T) {
+	for _, mode := range []CodegenMode{Bp2Build, QueryView} {
+		newTargets := func() BazelTargets {
+			return BazelTargets{
+				{name: "foo", content: "custom(\n    name = \"foo\",\n)", ruleClass: "custom", bzlLoadLocation: "//build/bazel/rules:custom.bzl"},
+				{name: "bar", content: "filegroup(\n    name = \"bar\",\n)", ruleClass: "filegroup"},
+				{name: "_COMMON_SRCS_0", content: "_COMMON_SRCS_0 = [\"a.c\"]", hoisted: true},
+				{name: "baz", content: "filegroup(\n    name = \"baz\",\n)", ruleClass: "filegroup"},
+			}
+		}
+		buildToTargets := map[string]BazelTargets{"pkg": newTargets()}
+		files := createBuildFiles(buildToTargets, mode)
+		if len(files) != 1 {
+			t.Fatalf("mode %d: expected 1 file, got %d", mode, len(files))
+		}
+
+		var streamed bytes.Buffer
+		if err := writeBuildFile(&streamed, newTargets(), mode); err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		if streamed.String() != files[0].Contents {
+			t.Errorf("mode %d: expected streamed contents\n%s\nto equal buffered contents\n%s",
+				mode, streamed.String(), files[0].Contents)
+		}
+
+		targets := newTargets()
+		sortBazelTargets(targets)
+		if !bytes.HasSuffix(streamed.Bytes(), []byte(targets.String())) {
+			t.Errorf("mode %d: expected contents to end with the sorted targets\n%s\ngot\n%s",
+				mode, targets.String(), streamed.String())
+		}
+	}
+}
+
+func TestImageConstraintTargets(t *testing.T) {
+	targets := imageConstraintTargets()
+	names := map[string]bool{}
+	for _, target := range targets {
+		names[target.name] = true
+	}
+	if !names["image"] {
+		t.Errorf("Expected an image constraint_setting, got %v", targets)
+	}
+	for _, image := range bazel.Images {
+		if !names[image] {
+			t.Errorf("Expected a constraint_value for image %q, got %v", image, targets)
+		}
+		if expected, actual := "//"+bazel.ImageConstraintPackage+":"+image, bazel.ImageConstraintValue(image); expected != actual {
+			t.Errorf("Expected the constraint_value of image %q to be %q, got %q", image, expected, actual)
+		}
+	}
+}
@@ -16,6 +16,9 @@ type CodegenMetrics struct {
 
 	// Total number of handcrafted targets
 	handCraftedTargetCount int
+
+	// Total number of module properties dropped because they could not be converted
+	unconvertedPropertyCount int
 }
 
 // Print the codegen metrics to stdout.
@@ -31,4 +34,8 @@ func (metrics CodegenMetrics) Print() {
 		generatedTargetCount,
 		metrics.handCraftedTargetCount,
 		metrics.TotalModuleCount)
+	if metrics.unconvertedPropertyCount > 0 {
+		fmt.Printf("[bp2build] Dropped %d module properties that could not be converted.\n",
+			metrics.unconvertedPropertyCount)
+	}
 }
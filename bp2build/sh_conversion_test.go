@@ -77,6 +77,27 @@ func TestShBinaryBp2Build(t *testing.T) {
     srcs = [
         "foo.sh",
     ],
+)`},
+		},
+		{
+			description:                        "sh_binary test with installable: false",
+			moduleTypeUnderTest:                "sh_binary",
+			moduleTypeUnderTestFactory:         sh.ShBinaryFactory,
+			moduleTypeUnderTestBp2BuildMutator: sh.ShBinaryBp2Build,
+			bp: `sh_binary {
+    name: "foo",
+    src: "foo.sh",
+    installable: false,
+    bazel_module: { bp2build_available: true },
+}`,
+			expectedBazelTargets: []string{`sh_binary(
+    name = "foo",
+    srcs = [
+        "foo.sh",
+    ],
+    tags = [
+        "no-install",
+    ],
 )`},
 		},
 	}
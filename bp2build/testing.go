@@ -181,8 +181,12 @@ func customBp2BuildMutatorFromStarlark(ctx android.TopDownMutatorContext) {
 	}
 }
 
-// Helper method for tests to easily access the targets in a dir.
+// Helper method for tests to easily access the targets in a dir. It panics if the conversion
+// fails.
 func generateBazelTargetsForDir(codegenCtx *CodegenContext, dir string) BazelTargets {
-	buildFileToTargets, _ := GenerateBazelTargets(codegenCtx)
+	buildFileToTargets, _, err := GenerateBazelTargets(codegenCtx)
+	if err != nil {
+		panic(err)
+	}
 	return buildFileToTargets[dir]
 }
@@ -20,6 +20,7 @@ import (
 	"github.com/google/blueprint"
 
 	"android/soong/android"
+	"android/soong/bazel"
 )
 
 type BinaryLinkerProperties struct {
@@ -58,6 +59,8 @@ type BinaryLinkerProperties struct {
 
 func init() {
 	RegisterBinaryBuildComponents(android.InitRegistrationContext)
+
+	android.RegisterBp2BuildMutator("cc_binary", CcBinaryBp2Build)
 }
 
 func RegisterBinaryBuildComponents(ctx android.RegistrationContext) {
@@ -553,3 +556,97 @@ func (binary *binaryDecorator) injectHostBionicLinkerSymbols(ctx ModuleContext,
 		},
 	})
 }
+
+type bazelCcBinaryAttributes struct {
+	Srcs                   bazel.LabelListAttribute
+	Copts                  bazel.StringListAttribute
+	Conlyflags             bazel.StringListAttribute
+	Cppflags               bazel.StringListAttribute
+	Includes               bazel.LabelListAttribute
+	Deps                   bazel.LabelListAttribute
+	Dynamic_deps           bazel.LabelListAttribute
+	Linkopts               bazel.StringListAttribute
+	Features               bazel.StringListAttribute
+	Data                   bazel.LabelListAttribute
+	Strip                  bazelStripAttributes
+	Symlinks               []string
+	Symlink_preferred_arch bool
+	Suffix                 string
+}
+
+type bazelCcBinary struct {
+	android.BazelTargetModuleBase
+	bazelCcBinaryAttributes
+}
+
+func (m *bazelCcBinary) Name() string {
+	return m.BaseModuleName()
+}
+
+func (m *bazelCcBinary) GenerateAndroidBuildActions(ctx android.ModuleContext) {}
+
+func BazelCcBinaryFactory() android.Module {
+	module := &bazelCcBinary{}
+	module.AddProperties(&module.bazelCcBinaryAttributes)
+	android.InitBazelTargetModule(module)
+	return module
+}
+
+// CcBinaryBp2Build is the bp2build converter from cc_binary modules to the Bazel equivalent
+// target, including the install symlinks declared by the binary.
+func CcBinaryBp2Build(ctx android.TopDownMutatorContext) {
+	module, ok := ctx.Module().(*Module)
+	if !ok || !module.ConvertWithBp2build(ctx) {
+		return
+	}
+	if ctx.ModuleType() != "cc_binary" {
+		return
+	}
+
+	binary, ok := module.linker.(*binaryDecorator)
+	if !ok {
+		ctx.ModuleErrorf("linker must be a binary linker for a cc_binary module")
+		return
+	}
+
+	compilerAttrs := bp2BuildParseCompilerProps(ctx, module)
+	deps, dynamicDeps := bp2BuildParseDeps(ctx, module)
+
+	// Soong appends the binary suffix to every symlink name; do the same here so that the
+	// symlinks installed by Bazel match the ones installed by Soong.
+	suffix := String(binary.Properties.Suffix)
+	var symlinks []string
+	for _, symlink := range binary.Properties.Symlinks {
+		symlinks = append(symlinks, symlink+suffix)
+	}
+
+	preferredArch := Bool(binary.Properties.Symlink_preferred_arch)
+	if preferredArch && suffix == "" {
+		ctx.PropertyErrorf("symlink_preferred_arch", "must also specify suffix")
+		return
+	}
+
+	attrs := &bazelCcBinaryAttributes{
+		Srcs:                   compilerAttrs.srcs,
+		Copts:                  compilerAttrs.copts,
+		Conlyflags:             compilerAttrs.conlyflags,
+		Cppflags:               compilerAttrs.cppflags,
+		Includes:               compilerAttrs.includes,
+		Deps:                   deps,
+		Dynamic_deps:           dynamicDeps,
+		Linkopts:               bp2BuildParseLinkopts(module),
+		Features:               bp2BuildParseFeatures(ctx, module),
+		Data:                   bp2BuildParseData(ctx, module),
+		Strip:                  bp2BuildParseStripProperties(binary.stripper),
+		Symlinks:               symlinks,
+		Symlink_preferred_arch: preferredArch,
+		Suffix:                 suffix,
+	}
+
+	props := bazel.BazelTargetModuleProperties{
+		Rule_class:        "cc_binary",
+		Bzl_load_location: "//build/bazel/rules:cc_binary.bzl",
+	}
+
+	ctx.CreateBazelTargetModule(BazelCcBinaryFactory, module.Name(), props, attrs)
+}
@@ -14,6 +14,10 @@
 package cc
 
 import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
 	"android/soong/android"
 	"android/soong/bazel"
 )
@@ -26,6 +30,7 @@ func init() {
 
 func RegisterDepsBp2Build(ctx android.RegisterMutatorsContext) {
 	ctx.BottomUp("cc_bp2build_deps", depsBp2BuildMutator)
+	ctx.BottomUp("cc_bp2build_whole_archive_libs", wholeArchiveLibsBp2BuildMutator)
 }
 
 // A naive deps mutator to add deps on all modules across all combinations of
@@ -47,15 +52,106 @@ func depsBp2BuildMutator(ctx android.BottomUpMutatorContext) {
 
 	var allDeps []string
 
+	if module.linker != nil {
+		for _, p := range module.linker.linkerProps() {
+			if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+				allDeps = append(allDeps, baseLinkerProps.Export_generated_headers...)
+			}
+		}
+	}
+
 	for _, p := range module.GetTargetProperties(&BaseLinkerProperties{}) {
+		// os specific linker props
+		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+			allDeps = append(allDeps, baseLinkerProps.Header_libs...)
+			allDeps = append(allDeps, baseLinkerProps.Export_header_lib_headers...)
+			allDeps = append(allDeps, baseLinkerProps.Static_libs...)
+			allDeps = append(allDeps, baseLinkerProps.Whole_static_libs...)
+			allDeps = append(allDeps, baseLinkerProps.Shared_libs...)
+		}
+	}
+
+	for _, p := range module.GetArchProperties(&BaseLinkerProperties{}) {
 		// arch specific linker props
 		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
 			allDeps = append(allDeps, baseLinkerProps.Header_libs...)
 			allDeps = append(allDeps, baseLinkerProps.Export_header_lib_headers...)
+			allDeps = append(allDeps, baseLinkerProps.Static_libs...)
+			allDeps = append(allDeps, baseLinkerProps.Whole_static_libs...)
+			allDeps = append(allDeps, baseLinkerProps.Shared_libs...)
 		}
 	}
 
 	ctx.AddDependency(module, nil, android.SortedUniqueStrings(allDeps)...)
+
+	addBp2BuildWholeArchiveLibDeps(ctx, module)
+}
+
+// bp2buildWholeArchiveDepTag marks the dependencies of a converted module on the static libraries
+// it links as whole archives.
+var bp2buildWholeArchiveDepTag = dependencyTag{name: "bp2build whole archive"}
+
+// addBp2BuildWholeArchiveLibDeps adds dependencies on the static libraries that a module links as
+// whole archives, including arch and os specific ones, so that wholeArchiveLibsBp2BuildMutator
+// can record the modules they resolve to.
+func addBp2BuildWholeArchiveLibDeps(ctx android.BottomUpMutatorContext, module *Module) {
+	if module.linker == nil {
+		return
+	}
+
+	var wholeStaticLibs []string
+	for _, props := range module.linker.linkerProps() {
+		if baseLinkerProps, ok := props.(*BaseLinkerProperties); ok {
+			wholeStaticLibs = append(wholeStaticLibs, baseLinkerProps.Whole_static_libs...)
+			if Bool(baseLinkerProps.Use_version_lib) {
+				wholeStaticLibs = append(wholeStaticLibs, "libbuildversion")
+			}
+		}
+	}
+	for _, p := range module.GetTargetProperties(&BaseLinkerProperties{}) {
+		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+			wholeStaticLibs = append(wholeStaticLibs, baseLinkerProps.Whole_static_libs...)
+		}
+	}
+	for _, p := range module.GetArchProperties(&BaseLinkerProperties{}) {
+		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+			wholeStaticLibs = append(wholeStaticLibs, baseLinkerProps.Whole_static_libs...)
+		}
+	}
+
+	ctx.AddDependency(module, bp2buildWholeArchiveDepTag, android.SortedUniqueStrings(wholeStaticLibs)...)
+}
+
+var bp2buildWholeArchiveLibsKey = android.NewOnceKey("Bp2buildWholeArchiveLibs")
+
+// wholeArchiveLibsBp2BuildMutator records the modules that converted modules link as whole
+// archives, so that their Bazel targets can be marked alwayslink by bp2BuildIsWholeArchiveLib.
+// Modules are recorded by their directory and name, as modules in different namespaces may share
+// a name.
+func wholeArchiveLibsBp2BuildMutator(ctx android.BottomUpMutatorContext) {
+	module, ok := ctx.Module().(*Module)
+	if !ok || !module.ConvertWithBp2build(ctx) {
+		return
+	}
+
+	wholeArchiveLibs := getNamedMapForConfig(ctx.Config(), bp2buildWholeArchiveLibsKey)
+	ctx.VisitDirectDepsWithTag(bp2buildWholeArchiveDepTag, func(dep android.Module) {
+		wholeArchiveLibs.Store(bp2buildQualifiedModuleName(ctx.OtherModuleDir(dep), ctx.OtherModuleName(dep)), true)
+	})
+}
+
+// bp2buildQualifiedModuleName returns the name of a module qualified by its directory.
+func bp2buildQualifiedModuleName(dir, name string) string {
+	return "//" + dir + ":" + name
+}
+
+// bp2BuildIsWholeArchiveLib returns true if a converted module links the module as a whole
+// archive, in which case its Bazel target must set alwayslink so that all of its objects are
+// linked.
+func bp2BuildIsWholeArchiveLib(ctx android.TopDownMutatorContext, module *Module) bool {
+	_, ok := getNamedMapForConfig(ctx.Config(), bp2buildWholeArchiveLibsKey).Load(
+		bp2buildQualifiedModuleName(ctx.ModuleDir(), module.Name()))
+	return ok
 }
 
 // bp2BuildParseHeaderLibs creates a label list attribute containing the header library deps of a module, including
@@ -81,16 +177,155 @@ func bp2BuildParseHeaderLibs(ctx android.TopDownMutatorContext, module *Module)
 		}
 	}
 
+	for arch, p := range module.GetArchProperties(&BaseLinkerProperties{}) {
+		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+			libs := baseLinkerProps.Header_libs
+			libs = append(libs, baseLinkerProps.Export_header_lib_headers...)
+			libs = android.SortedUniqueStrings(libs)
+			ret.SetValueForArch(arch.Name, android.BazelLabelForModuleDeps(ctx, libs))
+		}
+	}
+
 	return ret
 }
 
+// bp2BuildCompilerAttributes are the attributes converted from the compiler properties of a
+// module.
+type bp2BuildCompilerAttributes struct {
+	srcs       bazel.LabelListAttribute
+	copts      bazel.StringListAttribute
+	conlyflags bazel.StringListAttribute
+	cppflags   bazel.StringListAttribute
+	includes   bazel.LabelListAttribute
+}
+
+// bp2BuildParseCompilerProps converts the srcs, flags and include directories of a module,
+// including configurable attribute values.
+func bp2BuildParseCompilerProps(ctx android.TopDownMutatorContext, module *Module) bp2BuildCompilerAttributes {
+	var ret bp2BuildCompilerAttributes
+	srcs := func(props *BaseCompilerProperties) bazel.LabelList {
+		return android.BazelLabelForModuleSrcExcludes(ctx, props.Srcs, props.Exclude_srcs)
+	}
+	includes := func(props *BaseCompilerProperties) bazel.LabelList {
+		return android.BazelLabelForModuleSrc(ctx, append(android.CopyOf(props.Include_dirs), props.Local_include_dirs...))
+	}
+
+	for _, props := range module.compiler.compilerProps() {
+		if baseCompilerProps, ok := props.(*BaseCompilerProperties); ok {
+			ret.srcs = bazel.MakeLabelListAttribute(srcs(baseCompilerProps))
+			ret.copts.Value = baseCompilerProps.Cflags
+			ret.conlyflags.Value = baseCompilerProps.Conlyflags
+			ret.cppflags.Value = baseCompilerProps.Cppflags
+			ret.includes = bazel.MakeLabelListAttribute(includes(baseCompilerProps))
+			break
+		}
+	}
+
+	for os, p := range module.GetTargetProperties(&BaseCompilerProperties{}) {
+		if baseCompilerProps, ok := p.(*BaseCompilerProperties); ok {
+			ret.srcs.SetValueForOS(os.Name, srcs(baseCompilerProps))
+			ret.copts.SetValueForOS(os.Name, baseCompilerProps.Cflags)
+			ret.conlyflags.SetValueForOS(os.Name, baseCompilerProps.Conlyflags)
+			ret.cppflags.SetValueForOS(os.Name, baseCompilerProps.Cppflags)
+			ret.includes.SetValueForOS(os.Name, includes(baseCompilerProps))
+		}
+	}
+
+	for arch, p := range module.GetArchProperties(&BaseCompilerProperties{}) {
+		if baseCompilerProps, ok := p.(*BaseCompilerProperties); ok {
+			ret.srcs.SetValueForArch(arch.Name, srcs(baseCompilerProps))
+			ret.copts.SetValueForArch(arch.Name, baseCompilerProps.Cflags)
+			ret.conlyflags.SetValueForArch(arch.Name, baseCompilerProps.Conlyflags)
+			ret.cppflags.SetValueForArch(arch.Name, baseCompilerProps.Cppflags)
+			ret.includes.SetValueForArch(arch.Name, includes(baseCompilerProps))
+		}
+	}
+
+	for os, archProps := range module.GetTargetArchProperties(&BaseCompilerProperties{}) {
+		for arch, p := range archProps {
+			if baseCompilerProps, ok := p.(*BaseCompilerProperties); ok {
+				ret.srcs.SetValueForArchOS(arch.Name, os.Name, srcs(baseCompilerProps))
+			}
+		}
+	}
+	ret.srcs.FactorCommonArchValues()
+	ret.includes.FactorCommonArchValues()
+	return ret
+}
+
+// bp2BuildParseDeps creates label list attributes containing the static and header library deps
+// of a module, and its shared library deps, including configurable attribute values.
+func bp2BuildParseDeps(ctx android.TopDownMutatorContext, module *Module) (bazel.LabelListAttribute, bazel.LabelListAttribute) {
+	var deps, dynamicDeps bazel.LabelListAttribute
+	staticAndHeaderLibs := func(props *BaseLinkerProperties) bazel.LabelList {
+		libs := append(android.CopyOf(props.Static_libs), props.Whole_static_libs...)
+		libs = append(libs, props.Header_libs...)
+		libs = append(libs, props.Export_header_lib_headers...)
+		return android.BazelLabelForModuleDeps(ctx, android.SortedUniqueStrings(libs))
+	}
+	sharedLibs := func(props *BaseLinkerProperties) bazel.LabelList {
+		return android.BazelLabelForModuleDeps(ctx, android.SortedUniqueStrings(props.Shared_libs))
+	}
+
+	for _, linkerProps := range module.linker.linkerProps() {
+		if baseLinkerProps, ok := linkerProps.(*BaseLinkerProperties); ok {
+			deps = bazel.MakeLabelListAttribute(staticAndHeaderLibs(baseLinkerProps))
+			dynamicDeps = bazel.MakeLabelListAttribute(sharedLibs(baseLinkerProps))
+			break
+		}
+	}
+
+	for os, p := range module.GetTargetProperties(&BaseLinkerProperties{}) {
+		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+			deps.SetValueForOS(os.Name, staticAndHeaderLibs(baseLinkerProps))
+			dynamicDeps.SetValueForOS(os.Name, sharedLibs(baseLinkerProps))
+		}
+	}
+
+	for arch, p := range module.GetArchProperties(&BaseLinkerProperties{}) {
+		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+			deps.SetValueForArch(arch.Name, staticAndHeaderLibs(baseLinkerProps))
+			dynamicDeps.SetValueForArch(arch.Name, sharedLibs(baseLinkerProps))
+		}
+	}
+
+	for os, archProps := range module.GetTargetArchProperties(&BaseLinkerProperties{}) {
+		for arch, p := range archProps {
+			if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+				deps.SetValueForArchOS(arch.Name, os.Name, staticAndHeaderLibs(baseLinkerProps))
+				dynamicDeps.SetValueForArchOS(arch.Name, os.Name, sharedLibs(baseLinkerProps))
+			}
+		}
+	}
+	deps.FactorCommonArchValues()
+	dynamicDeps.FactorCommonArchValues()
+	return deps, dynamicDeps
+}
+
+// bp2BuildParseExportedGeneratedHeaders creates a label list attribute containing the generated
+// header modules that a module re-exports, which are public headers of its Bazel target.
+func bp2BuildParseExportedGeneratedHeaders(ctx android.TopDownMutatorContext, module *Module) bazel.LabelListAttribute {
+	for _, linkerProps := range module.linker.linkerProps() {
+		if baseLinkerProps, ok := linkerProps.(*BaseLinkerProperties); ok {
+			return bazel.MakeLabelListAttribute(
+				android.BazelLabelForModuleDeps(ctx, baseLinkerProps.Export_generated_headers))
+		}
+	}
+	return bazel.LabelListAttribute{}
+}
+
 // bp2BuildParseExportedIncludes creates a label list attribute contains the
 // exported included directories of a module.
 func bp2BuildParseExportedIncludes(ctx android.TopDownMutatorContext, module *Module) (bazel.LabelListAttribute, bazel.LabelListAttribute) {
 	libraryDecorator := module.linker.(*libraryDecorator)
 
-	includeDirs := libraryDecorator.flagExporter.Properties.Export_system_include_dirs
-	includeDirs = append(includeDirs, libraryDecorator.flagExporter.Properties.Export_include_dirs...)
+	var includeDirs []string
+	for _, dir := range libraryDecorator.flagExporter.Properties.Export_system_include_dirs {
+		includeDirs = append(includeDirs, bp2BuildStripPackagePrefix(ctx.ModuleDir(), dir))
+	}
+	for _, dir := range libraryDecorator.flagExporter.Properties.Export_include_dirs {
+		includeDirs = append(includeDirs, bp2BuildStripPackagePrefix(ctx.ModuleDir(), dir))
+	}
 
 	includeDirsLabels := android.BazelLabelForModuleSrc(ctx, includeDirs)
 
@@ -104,3 +339,328 @@ func bp2BuildParseExportedIncludes(ctx android.TopDownMutatorContext, module *Mo
 	headersLabels := android.BazelLabelForModuleSrc(ctx, includeDirGlobs)
 	return bazel.MakeLabelListAttribute(includeDirsLabels), bazel.MakeLabelListAttribute(headersLabels)
 }
+
+// bazelStripAttributes are the attributes controlling the stripping of the output of a target,
+// converted from the strip property of a module.
+type bazelStripAttributes struct {
+	None                         bazel.BoolAttribute
+	All                          bazel.BoolAttribute
+	Keep_symbols                 bazel.BoolAttribute
+	Keep_symbols_list            []string
+	Keep_symbols_and_debug_frame bazel.BoolAttribute
+}
+
+// bp2BuildParseStripProperties converts the strip property of a module. Unset properties are left
+// unset, so that the rule applies the same defaults as Soong.
+func bp2BuildParseStripProperties(stripper Stripper) bazelStripAttributes {
+	props := stripper.StripProperties.Strip
+	return bazelStripAttributes{
+		None:                         bazel.BoolAttributeFromProp(props.None),
+		All:                          bazel.BoolAttributeFromProp(props.All),
+		Keep_symbols:                 bazel.BoolAttributeFromProp(props.Keep_symbols),
+		Keep_symbols_list:            props.Keep_symbols_list,
+		Keep_symbols_and_debug_frame: bazel.BoolAttributeFromProp(props.Keep_symbols_and_debug_frame),
+	}
+}
+
+// bp2BuildParseData returns the files and modules installed alongside a module: its init_rc and
+// vintf_fragments files, and the modules it requires, selected by OS where needed.
+func bp2BuildParseData(ctx android.TopDownMutatorContext, module *Module) bazel.LabelListAttribute {
+	required := android.BazelLabelForModuleRequired(ctx, module)
+	data := required
+	data.Value = android.BazelLabelForModuleInitRcAndVintfFragments(ctx, module)
+	data.Value.Append(required.Value)
+	return data
+}
+
+// bp2BuildStripPackagePrefix returns includeDir relative to the package pkg. Include dirs may be
+// listed rooted at the top of the tree (e.g. "foo/bar/include" in package "foo/bar") or relative
+// to the module (e.g. "include"); both result in "include". The package itself becomes ".".
+// Dirs outside of the package are returned unchanged.
+func bp2BuildStripPackagePrefix(pkg, includeDir string) string {
+	dir := filepath.Clean(includeDir)
+	pkg = filepath.Clean(pkg)
+	if pkg == "." {
+		return dir
+	}
+	if dir == pkg {
+		return "."
+	}
+	if rel := strings.TrimPrefix(dir, pkg+"/"); rel != dir {
+		return rel
+	}
+	return dir
+}
+
+// bp2BuildImageVariantModuleTypes are the module types whose converters create a target for each
+// image variant of a module, see bp2BuildImageVariants.
+var bp2BuildImageVariantModuleTypes = map[string]bool{
+	"cc_library_headers": true,
+	"cc_library_static":  true,
+}
+
+// bp2BuildImageVariants returns the non-core images a module is available for, e.g. the vendor
+// image for a vendor_available module. Each of these builds for a different partition, so it is
+// converted to a separate Bazel target constrained to that image.
+func bp2BuildImageVariants(module *Module) []string {
+	var variants []string
+	if Bool(module.VendorProperties.Vendor_available) || Bool(module.VendorProperties.Odm_available) {
+		variants = append(variants, bazel.IMAGE_VENDOR)
+	}
+	if Bool(module.VendorProperties.Product_available) {
+		variants = append(variants, bazel.IMAGE_PRODUCT)
+	}
+	if Bool(module.Properties.Ramdisk_available) {
+		variants = append(variants, bazel.IMAGE_RAMDISK)
+	}
+	if Bool(module.Properties.Vendor_ramdisk_available) {
+		variants = append(variants, bazel.IMAGE_VENDOR_RAMDISK)
+	}
+	if Bool(module.Properties.Recovery_available) {
+		variants = append(variants, bazel.IMAGE_RECOVERY)
+	}
+	return variants
+}
+
+// bp2BuildImageVariantTargetName returns the name of the Bazel target for an image variant of the
+// module with the given name.
+func bp2BuildImageVariantTargetName(name, variant string) string {
+	return name + "_" + variant
+}
+
+// bp2BuildImageVariantDeps returns deps with the labels of the direct dependencies that have a
+// target for the given image variant replaced by the label of that target, so that an image
+// variant target only depends on targets built for the same image.
+func bp2BuildImageVariantDeps(ctx android.TopDownMutatorContext, deps bazel.LabelListAttribute, variant string) bazel.LabelListAttribute {
+	hasVariant := map[string]bool{}
+	ctx.VisitDirectDeps(func(dep android.Module) {
+		m, ok := dep.(*Module)
+		if !ok || m.HasHandcraftedLabel() || !bp2BuildImageVariantModuleTypes[ctx.OtherModuleType(dep)] {
+			return
+		}
+		if android.InList(variant, bp2BuildImageVariants(m)) {
+			hasVariant[m.BaseModuleName()] = true
+		}
+	})
+	return deps.MapLabels(func(l bazel.Label) bazel.Label {
+		// The Bp_text of a dependency is the module reference in the Android.bp file, either a
+		// module name or a fully qualified //namespace:name reference.
+		name := l.Bp_text[strings.LastIndex(l.Bp_text, ":")+1:]
+		if hasVariant[name] {
+			l.Label = bp2BuildImageVariantTargetName(l.Label, variant)
+		}
+		return l
+	})
+}
+
+// bp2BuildParseSanitizerFeatures creates a string list attribute containing the Bazel features
+// corresponding to the sanitizers enabled by the sanitize property of a module, including
+// configurable attribute values.
+func bp2BuildParseSanitizerFeatures(module *Module) bazel.StringListAttribute {
+	var ret bazel.StringListAttribute
+	if module.sanitize == nil {
+		return ret
+	}
+
+	ret.Value = sanitizerFeatures(module.sanitize.Properties.Sanitize)
+	for arch, p := range module.GetArchProperties(&SanitizeProperties{}) {
+		if sanitizeProps, ok := p.(*SanitizeProperties); ok {
+			ret.SetValueForArch(arch.Name, sanitizerFeatures(sanitizeProps.Sanitize))
+		}
+	}
+	return ret
+}
+
+// sanitizerFeatures returns the Bazel features enabling the sanitizers set in props.
+func sanitizerFeatures(props SanitizeUserProps) []string {
+	var features []string
+	if Bool(props.Address) {
+		features = append(features, "asan")
+	}
+	if Bool(props.Hwaddress) {
+		features = append(features, "hwasan")
+	}
+	if Bool(props.Cfi) {
+		features = append(features, "cfi")
+	}
+	if Bool(props.Undefined) {
+		features = append(features, "ubsan")
+	}
+	return features
+}
+
+// bp2BuildParseLinkerFeatures creates a string list attribute containing the Bazel features
+// controlling the linker of a module, including configurable attribute values. Only properties
+// which differ from the linker defaults result in a feature, e.g. use_clang_lld: false disabling
+// lld.
+func bp2BuildParseLinkerFeatures(module *Module) bazel.StringListAttribute {
+	var ret bazel.StringListAttribute
+	for _, linkerProps := range module.linker.linkerProps() {
+		if baseLinkerProps, ok := linkerProps.(*BaseLinkerProperties); ok {
+			ret.Value = linkerFeatures(baseLinkerProps)
+			break
+		}
+	}
+
+	for arch, p := range module.GetArchProperties(&BaseLinkerProperties{}) {
+		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+			ret.SetValueForArch(arch.Name, linkerFeatures(baseLinkerProps))
+		}
+	}
+	return ret
+}
+
+// linkerFeatures returns the Bazel features for the linker properties set in props.
+func linkerFeatures(props *BaseLinkerProperties) []string {
+	var features []string
+	if props.Use_clang_lld != nil && !*props.Use_clang_lld {
+		features = append(features, "-use_lld")
+	}
+	if props.Pack_relocations != nil && !*props.Pack_relocations {
+		features = append(features, "disable_pack_relocations")
+	}
+	if Bool(props.Allow_undefined_symbols) {
+		features = append(features, "-no_undefined_symbols")
+	}
+	return features
+}
+
+// bp2BuildParseCoverageFeatures returns the Bazel features enabling coverage instrumentation of a
+// module, for modules whose coverage variant would be built with coverage.
+func bp2BuildParseCoverageFeatures(ctx android.TopDownMutatorContext, module *Module) []string {
+	if module.coverage == nil {
+		return nil
+	}
+	props := SetCoverageProperties(ctx, module.coverage.Properties, module.nativeCoverage(),
+		module.UseSdk(), module.SdkVersion())
+	if !props.NeedCoverageBuild {
+		return nil
+	}
+	return []string{"coverage"}
+}
+
+// bp2BuildParseFeatures creates a string list attribute containing all Bazel features of a
+// module, including configurable attribute values.
+func bp2BuildParseFeatures(ctx android.TopDownMutatorContext, module *Module) bazel.StringListAttribute {
+	features := bp2BuildParseSanitizerFeatures(module)
+	linkerFeatures := bp2BuildParseLinkerFeatures(module)
+	features.Value = append(features.Value, linkerFeatures.Value...)
+	features.Value = append(features.Value, bp2BuildParseCoverageFeatures(ctx, module)...)
+	for _, arch := range bazel.SelectableArchs() {
+		features.SetValueForArch(arch,
+			append(features.GetValueForArch(arch), linkerFeatures.GetValueForArch(arch)...))
+	}
+	return features
+}
+
+// Linker flags that Bazel sets itself based on the rule type, and which would conflict with the
+// rule if passed through in linkopts.
+var bp2buildRuleLdflags = map[string]bool{
+	"-shared": true,
+	"-static": true,
+}
+
+// bp2BuildParseLinkopts creates a string list attribute containing the ldflags of a module,
+// including configurable attribute values. The order of the flags is preserved, as it is
+// significant to the linker.
+func bp2BuildParseLinkopts(module *Module) bazel.StringListAttribute {
+	var ret bazel.StringListAttribute
+	for _, linkerProps := range module.linker.linkerProps() {
+		if baseLinkerProps, ok := linkerProps.(*BaseLinkerProperties); ok {
+			ret.Value = bp2BuildLinkopts(baseLinkerProps.Ldflags)
+			break
+		}
+	}
+
+	for arch, p := range module.GetArchProperties(&BaseLinkerProperties{}) {
+		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+			ret.SetValueForArch(arch.Name, bp2BuildLinkopts(baseLinkerProps.Ldflags))
+		}
+	}
+	return ret
+}
+
+// bp2BuildLinkopts returns ldflags without the flags that Bazel sets itself.
+func bp2BuildLinkopts(ldflags []string) []string {
+	var linkopts []string
+	for _, flag := range ldflags {
+		if !bp2buildRuleLdflags[flag] {
+			linkopts = append(linkopts, flag)
+		}
+	}
+	return linkopts
+}
+
+// bp2BuildParseLinkerInputs creates a label list attribute containing the files a module passes
+// to the linker, i.e. its version script and dynamic list, along with a string list attribute of
+// the linkopts passing each of them to the linker. An arch-specific version script or dynamic list
+// replaces the one of the module, so the base value is used for the //conditions:default branch
+// and for architectures that don't override it.
+func bp2BuildParseLinkerInputs(ctx android.TopDownMutatorContext, module *Module) (bazel.LabelListAttribute, bazel.StringListAttribute) {
+	parse := func(versionScript, dynamicList *string) (bazel.LabelList, []string) {
+		var inputs bazel.LabelList
+		var linkopts []string
+		for _, input := range []struct {
+			path *string
+			flag string
+		}{
+			{versionScript, "-Wl,--version-script"},
+			{dynamicList, "-Wl,--dynamic-list"},
+		} {
+			if input.path == nil {
+				continue
+			}
+			labels := android.BazelLabelForModuleSrc(ctx, []string{*input.path})
+			for _, label := range labels.Includes {
+				linkopts = append(linkopts, fmt.Sprintf("%s,$(location %s)", input.flag, label.Label))
+			}
+			inputs.Append(labels)
+		}
+		return inputs, linkopts
+	}
+
+	var versionScript, dynamicList *string
+	for _, linkerProps := range module.linker.linkerProps() {
+		if baseLinkerProps, ok := linkerProps.(*BaseLinkerProperties); ok {
+			versionScript = baseLinkerProps.Version_script
+			dynamicList = baseLinkerProps.Dynamic_list
+			break
+		}
+	}
+	baseInputs, baseLinkopts := parse(versionScript, dynamicList)
+
+	var inputs bazel.LabelListAttribute
+	var linkopts bazel.StringListAttribute
+	for arch, p := range module.GetArchProperties(&BaseLinkerProperties{}) {
+		if baseLinkerProps, ok := p.(*BaseLinkerProperties); ok {
+			if baseLinkerProps.Version_script == nil && baseLinkerProps.Dynamic_list == nil {
+				continue
+			}
+			archVersionScript, archDynamicList := versionScript, dynamicList
+			if baseLinkerProps.Version_script != nil {
+				archVersionScript = baseLinkerProps.Version_script
+			}
+			if baseLinkerProps.Dynamic_list != nil {
+				archDynamicList = baseLinkerProps.Dynamic_list
+			}
+			archInputs, archLinkopts := parse(archVersionScript, archDynamicList)
+			inputs.SetValueForArch(arch.Name, archInputs)
+			linkopts.SetValueForArch(arch.Name, archLinkopts)
+		}
+	}
+
+	if !inputs.HasConfigurableValues() {
+		return bazel.MakeLabelListAttribute(baseInputs), bazel.StringListAttribute{Value: baseLinkopts}
+	}
+	// Architectures without an override also get the base value in their own branch, so that it
+	// isn't lost when the linkopts are combined with other arch-specific linkopts.
+	for _, arch := range bazel.SelectableArchs() {
+		if len(inputs.GetValueForArch(arch).Includes) == 0 {
+			inputs.SetValueForArch(arch, baseInputs)
+			linkopts.SetValueForArch(arch, baseLinkopts)
+		}
+	}
+	inputs.ArchValues.ConditionsDefault = baseInputs
+	linkopts.ArchValues.ConditionsDefault = baseLinkopts
+	return inputs, linkopts
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"testing"
+)
+
+func TestBp2BuildStripPackagePrefix(t *testing.T) {
+	testCases := []struct {
+		description string
+		pkg         string
+		includeDir  string
+		expected    string
+	}{
+		{
+			description: "rooted include dir",
+			pkg:         "foo/bar",
+			includeDir:  "foo/bar/include",
+			expected:    "include",
+		},
+		{
+			description: "module-relative include dir",
+			pkg:         "foo/bar",
+			includeDir:  "include",
+			expected:    "include",
+		},
+		{
+			description: "rooted package dir",
+			pkg:         "foo/bar",
+			includeDir:  "foo/bar",
+			expected:    ".",
+		},
+		{
+			description: "module-relative dir sharing a prefix with the package",
+			pkg:         "foo/bar",
+			includeDir:  "foo/barbaz/include",
+			expected:    "foo/barbaz/include",
+		},
+		{
+			description: "top level package",
+			pkg:         ".",
+			includeDir:  "include/",
+			expected:    "include",
+		},
+	}
+
+	for _, tc := range testCases {
+		if actual := bp2BuildStripPackagePrefix(tc.pkg, tc.includeDir); actual != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.description, tc.expected, actual)
+		}
+	}
+}
@@ -4263,3 +4263,25 @@ func TestIncludeDirsExporting(t *testing.T) {
 		)
 	})
 }
+
+func TestDynamicList(t *testing.T) {
+	bp := `
+		cc_library_shared {
+			name: "libfoo",
+			srcs: ["foo.c"],
+			dynamic_list: "foo.dynamic.txt",
+		}`
+
+	result := android.GroupFixturePreparers(
+		prepareForCcTest,
+		android.FixtureAddTextFile("foo.dynamic.txt", ""),
+	).RunTestWithBp(t, bp)
+
+	ld := result.ModuleForTests("libfoo", "android_arm64_armv8-a_shared").Rule("ld")
+	android.AssertStringDoesContain(t, "libfoo ldFlags", ld.Args["ldFlags"], "-Wl,--dynamic-list,foo.dynamic.txt")
+	var implicits []string
+	for _, p := range ld.Implicits {
+		implicits = append(implicits, p.String())
+	}
+	android.AssertStringListContains(t, "libfoo ld implicits", implicits, "foo.dynamic.txt")
+}
@@ -206,6 +206,7 @@ func init() {
 	RegisterLibraryBuildComponents(android.InitRegistrationContext)
 
 	android.RegisterBp2BuildMutator("cc_library_static", CcLibraryStaticBp2Build)
+	android.RegisterBp2BuildMutator("cc_library_shared", CcLibrarySharedBp2Build)
 }
 
 func RegisterLibraryBuildComponents(ctx android.RegistrationContext) {
@@ -2048,11 +2049,20 @@ func maybeInjectBoringSSLHash(ctx android.ModuleContext, outputFile android.Modu
 
 type bazelCcLibraryStaticAttributes struct {
 	Copts      []string
+	Conlyflags []string
+	Cppflags   []string
 	Srcs       bazel.LabelListAttribute
 	Deps       bazel.LabelListAttribute
 	Linkstatic bool
+	Alwayslink bool
 	Includes   bazel.LabelListAttribute
 	Hdrs       bazel.LabelListAttribute
+	Features   bazel.StringListAttribute
+	Linkopts   bazel.StringListAttribute
+
+	Additional_linker_inputs bazel.LabelListAttribute
+	Data                     bazel.LabelListAttribute
+	Target_compatible_with   []string
 }
 
 type bazelCcLibraryStatic struct {
@@ -2081,12 +2091,16 @@ func CcLibraryStaticBp2Build(ctx android.TopDownMutatorContext) {
 	}
 
 	var copts []string
+	var conlyflags []string
+	var cppflags []string
 	var srcs []string
 	var includeDirs []string
 	var localIncludeDirs []string
 	for _, props := range module.compiler.compilerProps() {
 		if baseCompilerProps, ok := props.(*BaseCompilerProperties); ok {
 			copts = baseCompilerProps.Cflags
+			conlyflags = baseCompilerProps.Conlyflags
+			cppflags = baseCompilerProps.Cppflags
 			srcs = baseCompilerProps.Srcs
 			includeDirs = baseCompilerProps.Include_dirs
 			localIncludeDirs = baseCompilerProps.Local_include_dirs
@@ -2120,16 +2134,31 @@ func CcLibraryStaticBp2Build(ctx android.TopDownMutatorContext) {
 	exportedIncludesLabels, exportedIncludesHeadersLabels := bp2BuildParseExportedIncludes(ctx, module)
 	includesLabels.Append(exportedIncludesLabels.Value)
 
+	exportedGeneratedHeadersLabels := bp2BuildParseExportedGeneratedHeaders(ctx, module)
+	exportedIncludesHeadersLabels.Value.Append(exportedGeneratedHeadersLabels.Value)
+
 	headerLibsLabels := bp2BuildParseHeaderLibs(ctx, module)
 	depsLabels.Append(headerLibsLabels.Value)
 
+	linkopts := bp2BuildParseLinkopts(module)
+	linkerInputs, linkerInputsLinkopts := bp2BuildParseLinkerInputs(ctx, module)
+	linkopts.Append(linkerInputsLinkopts)
+
 	attrs := &bazelCcLibraryStaticAttributes{
 		Copts:      copts,
+		Conlyflags: conlyflags,
+		Cppflags:   cppflags,
 		Srcs:       srcsLabels,
 		Deps:       bazel.MakeLabelListAttribute(depsLabels),
 		Linkstatic: true,
+		Alwayslink: bp2BuildIsWholeArchiveLib(ctx, module),
 		Includes:   bazel.MakeLabelListAttribute(includesLabels),
 		Hdrs:       exportedIncludesHeadersLabels,
+		Features:   bp2BuildParseFeatures(ctx, module),
+		Linkopts:   linkopts,
+
+		Additional_linker_inputs: linkerInputs,
+		Data:                     bp2BuildParseData(ctx, module),
 	}
 
 	props := bazel.BazelTargetModuleProperties{
@@ -2138,6 +2167,14 @@ func CcLibraryStaticBp2Build(ctx android.TopDownMutatorContext) {
 	}
 
 	ctx.CreateBazelTargetModule(BazelCcLibraryStaticFactory, module.Name(), props, attrs)
+
+	for _, variant := range bp2BuildImageVariants(module) {
+		variantAttrs := *attrs
+		variantAttrs.Deps = bp2BuildImageVariantDeps(ctx, attrs.Deps, variant)
+		variantAttrs.Target_compatible_with = []string{bazel.ImageConstraintValue(variant)}
+		ctx.CreateBazelTargetModule(BazelCcLibraryStaticFactory,
+			bp2BuildImageVariantTargetName(module.Name(), variant), props, &variantAttrs)
+	}
 }
 
 func (m *bazelCcLibraryStatic) Name() string {
@@ -2145,3 +2182,153 @@ func (m *bazelCcLibraryStatic) Name() string {
 }
 
 func (m *bazelCcLibraryStatic) GenerateAndroidBuildActions(ctx android.ModuleContext) {}
+
+type bazelCcLibrarySharedAttributes struct {
+	Srcs         bazel.LabelListAttribute
+	Copts        bazel.StringListAttribute
+	Conlyflags   bazel.StringListAttribute
+	Cppflags     bazel.StringListAttribute
+	Includes     bazel.LabelListAttribute
+	Hdrs         bazel.LabelListAttribute
+	Deps         bazel.LabelListAttribute
+	Dynamic_deps bazel.LabelListAttribute
+	Features     bazel.StringListAttribute
+	Linkopts     bazel.StringListAttribute
+
+	Additional_linker_inputs bazel.LabelListAttribute
+	Data                     bazel.LabelListAttribute
+	Strip                    bazelStripAttributes
+}
+
+type bazelCcLibraryShared struct {
+	android.BazelTargetModuleBase
+	bazelCcLibrarySharedAttributes
+}
+
+func BazelCcLibrarySharedFactory() android.Module {
+	module := &bazelCcLibraryShared{}
+	module.AddProperties(&module.bazelCcLibrarySharedAttributes)
+	android.InitBazelTargetModule(module)
+	return module
+}
+
+// CcLibrarySharedBp2Build is the bp2build converter from cc_library_shared modules to the Bazel
+// equivalent target, along with the stub libraries of its stubs versions.
+func CcLibrarySharedBp2Build(ctx android.TopDownMutatorContext) {
+	module, ok := ctx.Module().(*Module)
+	if !ok || !module.ConvertWithBp2build(ctx) {
+		return
+	}
+	if ctx.ModuleType() != "cc_library_shared" {
+		return
+	}
+
+	compilerAttrs := bp2BuildParseCompilerProps(ctx, module)
+	deps, dynamicDeps := bp2BuildParseDeps(ctx, module)
+
+	includes := compilerAttrs.includes
+	exportedIncludes, exportedIncludesHeaders := bp2BuildParseExportedIncludes(ctx, module)
+	includes.Value.Append(exportedIncludes.Value)
+	includes.Value = bazel.UniqueBazelLabelList(includes.Value)
+	exportedIncludesHeaders.Value.Append(bp2BuildParseExportedGeneratedHeaders(ctx, module).Value)
+
+	linkopts := bp2BuildParseLinkopts(module)
+	linkerInputs, linkerInputsLinkopts := bp2BuildParseLinkerInputs(ctx, module)
+	linkopts.Append(linkerInputsLinkopts)
+
+	var strip bazelStripAttributes
+	if library, ok := module.linker.(*libraryDecorator); ok {
+		strip = bp2BuildParseStripProperties(library.stripper)
+	}
+
+	attrs := &bazelCcLibrarySharedAttributes{
+		Srcs:         compilerAttrs.srcs,
+		Copts:        compilerAttrs.copts,
+		Conlyflags:   compilerAttrs.conlyflags,
+		Cppflags:     compilerAttrs.cppflags,
+		Includes:     includes,
+		Hdrs:         exportedIncludesHeaders,
+		Deps:         deps,
+		Dynamic_deps: dynamicDeps,
+		Features:     bp2BuildParseFeatures(ctx, module),
+		Linkopts:     linkopts,
+
+		Additional_linker_inputs: linkerInputs,
+		Data:                     bp2BuildParseData(ctx, module),
+		Strip:                    strip,
+	}
+
+	props := bazel.BazelTargetModuleProperties{
+		Rule_class:        "cc_library_shared",
+		Bzl_load_location: "//build/bazel/rules:cc_library_shared.bzl",
+	}
+
+	ctx.CreateBazelTargetModule(BazelCcLibrarySharedFactory, module.Name(), props, attrs)
+
+	ccStubLibrariesBp2Build(ctx, module)
+}
+
+func (m *bazelCcLibraryShared) Name() string {
+	return m.BaseModuleName()
+}
+
+func (m *bazelCcLibraryShared) GenerateAndroidBuildActions(ctx android.ModuleContext) {}
+
+type bazelCcStubLibraryAttributes struct {
+	Symbol_file    bazel.Label
+	Version        string
+	Source_library bazel.Label
+}
+
+type bazelCcStubLibrary struct {
+	android.BazelTargetModuleBase
+	bazelCcStubLibraryAttributes
+}
+
+func BazelCcStubLibraryFactory() android.Module {
+	module := &bazelCcStubLibrary{}
+	module.AddProperties(&module.bazelCcStubLibraryAttributes)
+	android.InitBazelTargetModule(module)
+	return module
+}
+
+// ccStubLibrariesBp2Build creates a cc_stub_library target for each version of the stubs of a
+// shared library, including the implicit "current" version, mirroring the stubs variants Soong
+// creates.
+func ccStubLibrariesBp2Build(ctx android.TopDownMutatorContext, module *Module) {
+	library, ok := module.linker.(*libraryDecorator)
+	if !ok || !library.buildShared() || library.Properties.Stubs.Symbol_file == nil {
+		return
+	}
+
+	versions := android.CopyOf(library.Properties.Stubs.Versions)
+	if !android.InList("current", versions) {
+		versions = append(versions, "current")
+	}
+
+	symbolFiles := android.BazelLabelForModuleSrc(ctx, []string{*library.Properties.Stubs.Symbol_file}).Includes
+	if len(symbolFiles) != 1 {
+		ctx.PropertyErrorf("stubs.symbol_file", "expected a single symbol file, got %q", symbolFiles)
+		return
+	}
+	symbolFile := symbolFiles[0]
+	props := bazel.BazelTargetModuleProperties{
+		Rule_class:        "cc_stub_library",
+		Bzl_load_location: "//build/bazel/rules:cc_stub_library.bzl",
+	}
+	for _, version := range versions {
+		attrs := &bazelCcStubLibraryAttributes{
+			Symbol_file:    symbolFile,
+			Version:        version,
+			Source_library: bazel.Label{Label: ":" + module.Name()},
+		}
+		ctx.CreateBazelTargetModule(BazelCcStubLibraryFactory,
+			module.Name()+"_stub_libs-"+version, props, attrs)
+	}
+}
+
+func (m *bazelCcStubLibrary) Name() string {
+	return m.BaseModuleName()
+}
+
+func (m *bazelCcStubLibrary) GenerateAndroidBuildActions(ctx android.ModuleContext) {}
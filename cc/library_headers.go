@@ -65,6 +65,8 @@ type bazelCcLibraryHeadersAttributes struct {
 	Hdrs     bazel.LabelListAttribute
 	Includes bazel.LabelListAttribute
 	Deps     bazel.LabelListAttribute
+
+	Target_compatible_with []string
 }
 
 type bazelCcLibraryHeaders struct {
@@ -110,6 +112,14 @@ func CcLibraryHeadersBp2Build(ctx android.TopDownMutatorContext) {
 	}
 
 	ctx.CreateBazelTargetModule(BazelCcLibraryHeadersFactory, module.Name(), props, attrs)
+
+	for _, variant := range bp2BuildImageVariants(module) {
+		variantAttrs := *attrs
+		variantAttrs.Deps = bp2BuildImageVariantDeps(ctx, attrs.Deps, variant)
+		variantAttrs.Target_compatible_with = []string{bazel.ImageConstraintValue(variant)}
+		ctx.CreateBazelTargetModule(BazelCcLibraryHeadersFactory,
+			bp2BuildImageVariantTargetName(module.Name(), variant), props, &variantAttrs)
+	}
 }
 
 func (m *bazelCcLibraryHeaders) Name() string {
@@ -16,6 +16,7 @@ package cc
 
 import (
 	"fmt"
+	"strings"
 
 	"android/soong/android"
 	"android/soong/bazel"
@@ -117,6 +118,7 @@ type bazelObjectAttributes struct {
 	Copts              bazel.StringListAttribute
 	Asflags            []string
 	Local_include_dirs []string
+	Features           bazel.StringListAttribute
 }
 
 type bazelObject struct {
@@ -185,22 +187,37 @@ func ObjectBp2Build(ctx android.TopDownMutatorContext) {
 		}
 	}
 
+	var unconvertedProperties []string
 	productVariableProps := android.ProductVariableProperties(ctx)
-	if props, exists := productVariableProps["Asflags"]; exists {
-		// TODO(b/183595873): consider deduplicating handling of product variable properties
-		for _, prop := range props {
+	for _, name := range android.SortedStringKeys(productVariableProps) {
+		for _, prop := range productVariableProps[name] {
+			propName := fmt.Sprintf("product_variables.%s.%s",
+				strings.ToLower(prop.ProductConfigVariable), strings.ToLower(name))
+			if name == "Srcs" {
+				if srcsProp, ok := prop.Property.([]string); ok {
+					srcs.SetValueForProductVariable(prop.ProductConfigVariable,
+						android.BazelLabelForModuleSrc(ctx, srcsProp))
+					continue
+				}
+			}
+			if name != "Asflags" {
+				// TODO(b/183595873) handle other product variable usages -- as selects?
+				unconvertedProperties = append(unconvertedProperties, propName)
+				continue
+			}
+			// TODO(b/183595873): consider deduplicating handling of product variable properties
 			flags, ok := prop.Property.([]string)
 			if !ok {
 				ctx.ModuleErrorf("Could not convert product variable asflag property")
 				return
 			}
-			// TODO(b/183595873) handle other product variable usages -- as selects?
 			if newFlags, subbed := bazel.TryVariableSubstitutions(flags, prop.ProductConfigVariable); subbed {
 				asFlags = append(asFlags, newFlags...)
+			} else {
+				unconvertedProperties = append(unconvertedProperties, propName)
 			}
 		}
 	}
-	// TODO(b/183595872) warn/error if we're not handling product variables
 
 	for arch, p := range m.GetArchProperties(&BaseCompilerProperties{}) {
 		if cProps, ok := p.(*BaseCompilerProperties); ok {
@@ -209,17 +226,28 @@ func ObjectBp2Build(ctx android.TopDownMutatorContext) {
 		}
 	}
 
+	for arch, featureProps := range m.GetArchFeatureProperties(&BaseCompilerProperties{}) {
+		for feature, p := range featureProps {
+			if cProps, ok := p.(*BaseCompilerProperties); ok && len(cProps.Srcs) > 0 {
+				srcs.SetValueForArchFeature(arch.Name, feature,
+					android.BazelLabelForModuleSrcExcludes(ctx, cProps.Srcs, cProps.Exclude_srcs))
+			}
+		}
+	}
+
 	attrs := &bazelObjectAttributes{
 		Srcs:               srcs,
 		Deps:               deps,
 		Copts:              copts,
 		Asflags:            asFlags,
 		Local_include_dirs: localIncludeDirs,
+		Features:           bp2BuildParseSanitizerFeatures(m),
 	}
 
 	props := bazel.BazelTargetModuleProperties{
-		Rule_class:        "cc_object",
-		Bzl_load_location: "//build/bazel/rules:cc_object.bzl",
+		Rule_class:             "cc_object",
+		Bzl_load_location:      "//build/bazel/rules:cc_object.bzl",
+		Unconverted_properties: unconvertedProperties,
 	}
 
 	ctx.CreateBazelTargetModule(BazelObjectFactory, m.Name(), props, attrs)
@@ -20,6 +20,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -233,6 +234,33 @@ func writeUsedVariablesFile(path string, configuration android.Config) {
 // Run Soong in the bp2build mode. This creates a standalone context that registers
 // an alternate pipeline of mutators and singletons specifically for generating
 // Bazel BUILD files instead of Ninja files.
+// bp2buildIndentation returns the indentation of generated BUILD files set with
+// BP2BUILD_INDENT_UNIT, either "space" or "tab", and BP2BUILD_INDENT_WIDTH, the number of units per
+// level. ok is false if neither is set, in which case the default indentation is kept.
+func bp2buildIndentation(configuration android.Config) (unit string, width int, ok bool, err error) {
+	unitName := configuration.Getenv("BP2BUILD_INDENT_UNIT")
+	widthString := configuration.Getenv("BP2BUILD_INDENT_WIDTH")
+	if unitName == "" && widthString == "" {
+		return "", 0, false, nil
+	}
+
+	switch unitName {
+	case "", "space":
+		unit, width = " ", 4
+	case "tab":
+		unit, width = "\t", 1
+	default:
+		return "", 0, false, fmt.Errorf("BP2BUILD_INDENT_UNIT must be \"space\" or \"tab\", got %q", unitName)
+	}
+	if widthString != "" {
+		width, err = strconv.Atoi(widthString)
+		if err != nil || width < 1 {
+			return "", 0, false, fmt.Errorf("BP2BUILD_INDENT_WIDTH must be a positive number, got %q", widthString)
+		}
+	}
+	return unit, width, true, nil
+}
+
 func runBp2Build(configuration android.Config, extraNinjaDeps []string) {
 	// Register an alternate set of singletons and mutators for bazel
 	// conversion for Bazel conversion.
@@ -263,7 +291,21 @@ func runBp2Build(configuration android.Config, extraNinjaDeps []string) {
 	// Run the code-generation phase to convert BazelTargetModules to BUILD files
 	// and print conversion metrics to the user.
 	codegenContext := bp2build.NewCodegenContext(configuration, *bp2buildCtx, bp2build.Bp2Build)
-	metrics := bp2build.Codegen(codegenContext)
+	codegenContext.SetAnnotateSourcePositions(configuration.IsEnvTrue("BP2BUILD_ANNOTATE_SOURCE_POSITIONS"))
+	codegenContext.SetHoistCommonAttributes(configuration.IsEnvTrue("BP2BUILD_HOIST_COMMON_ATTRIBUTES"))
+	codegenContext.SetBuildifierFormatting(configuration.IsEnvTrue("BP2BUILD_BUILDIFIER_FORMATTING"))
+	codegenContext.SetStrict(configuration.IsEnvTrue("BP2BUILD_STRICT"))
+	if unit, width, ok, err := bp2buildIndentation(configuration); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	} else if ok {
+		codegenContext.SetIndentation(unit, width)
+	}
+	metrics, err := bp2build.Codegen(codegenContext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
 
 	// Only report metrics when in bp2build mode. The metrics aren't relevant
 	// for queryview, since that's a total repo-wide conversion and there's a
@@ -27,7 +27,10 @@ func createBazelQueryView(ctx *bp2build.CodegenContext, bazelQueryViewDir string
 
 	// Ignore metrics reporting for queryview, since queryview is already a full-repo
 	// conversion and can use data from bazel query directly.
-	buildToTargets, _ := bp2build.GenerateBazelTargets(ctx)
+	buildToTargets, _, err := bp2build.GenerateBazelTargets(ctx)
+	if err != nil {
+		return err
+	}
 
 	filesToWrite := bp2build.CreateBazelFiles(ruleShims, buildToTargets, bp2build.QueryView)
 	for _, f := range filesToWrite {
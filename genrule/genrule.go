@@ -798,10 +798,11 @@ type genRuleProperties struct {
 }
 
 type bazelGenruleAttributes struct {
-	Srcs  bazel.LabelListAttribute
-	Outs  []string
-	Tools bazel.LabelListAttribute
-	Cmd   string
+	Srcs       bazel.LabelListAttribute
+	Outs       []string
+	Tools      bazel.LabelListAttribute
+	Toolchains []string
+	Cmd        string
 }
 
 type bazelGenrule struct {
@@ -832,6 +833,13 @@ func GenruleBp2Build(ctx android.TopDownMutatorContext) {
 	tool_files_prop := android.BazelLabelForModuleSrc(ctx, m.properties.Tool_files)
 	tools_prop.Append(tool_files_prop)
 
+	// As in Soong, $(location) and $(locations) without a label refer to the first of the tools,
+	// followed by the tool_files. This must be determined before the labels are sorted.
+	var firstTool string
+	if len(tools_prop.Includes) > 0 {
+		firstTool = tools_prop.Includes[0].Label
+	}
+
 	tools := bazel.MakeLabelListAttribute(tools_prop)
 	srcs := bazel.MakeLabelListAttribute(android.BazelLabelForModuleSrc(ctx, m.properties.Srcs))
 
@@ -845,9 +853,9 @@ func GenruleBp2Build(ctx android.TopDownMutatorContext) {
 		cmd = strings.Replace(*m.properties.Cmd, "$(in)", "$(SRCS)", -1)
 		cmd = strings.Replace(cmd, "$(out)", "$(OUTS)", -1)
 		cmd = strings.Replace(cmd, "$(genDir)", "$(GENDIR)", -1)
-		if len(tools.Value.Includes) > 0 {
-			cmd = strings.Replace(cmd, "$(location)", fmt.Sprintf("$(location %s)", tools.Value.Includes[0].Label), -1)
-			cmd = strings.Replace(cmd, "$(locations)", fmt.Sprintf("$(locations %s)", tools.Value.Includes[0].Label), -1)
+		if firstTool != "" {
+			cmd = strings.Replace(cmd, "$(location)", fmt.Sprintf("$(location %s)", firstTool), -1)
+			cmd = strings.Replace(cmd, "$(locations)", fmt.Sprintf("$(locations %s)", firstTool), -1)
 		}
 		for _, l := range allReplacements.Includes {
 			bpLoc := fmt.Sprintf("$(location %s)", l.Bp_text)
@@ -871,10 +879,11 @@ func GenruleBp2Build(ctx android.TopDownMutatorContext) {
 	}
 
 	attrs := &bazelGenruleAttributes{
-		Srcs:  srcs,
-		Outs:  outs,
-		Cmd:   cmd,
-		Tools: tools,
+		Srcs:       srcs,
+		Outs:       outs,
+		Cmd:        cmd,
+		Tools:      tools,
+		Toolchains: m.BazelToolchains(),
 	}
 
 	props := bazel.BazelTargetModuleProperties{
@@ -486,6 +486,7 @@ func ShTestHostFactory() android.Module {
 
 type bazelShBinaryAttributes struct {
 	Srcs bazel.LabelListAttribute
+	Tags []string
 	// Bazel also supports the attributes below, but (so far) these are not required for Bionic
 	// deps
 	// data
@@ -500,7 +501,6 @@ type bazelShBinaryAttributes struct {
 	// licenses
 	// output_licenses
 	// restricted_to
-	// tags
 	// target_compatible_with
 	// testonly
 	// toolchains
@@ -528,8 +528,15 @@ func ShBinaryBp2Build(ctx android.TopDownMutatorContext) {
 	srcs := bazel.MakeLabelListAttribute(
 		android.BazelLabelForModuleSrc(ctx, []string{*m.properties.Src}))
 
+	var tags []string
+	if !m.Installable() {
+		// The binary is still built, but must not be picked up by install rules.
+		tags = append(tags, "no-install")
+	}
+
 	attrs := &bazelShBinaryAttributes{
 		Srcs: srcs,
+		Tags: tags,
 	}
 
 	props := bazel.BazelTargetModuleProperties{